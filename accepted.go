@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultStartup is the expected startup duration of a service that was never started by the scaler
+const defaultStartup = 5 * time.Second
+
+// remainingStartup returns how long the starting service should still take to be up, from its average cold start
+func (service *Service) remainingStartup(now time.Time) time.Duration {
+	expected := averageColdStart(service.name)
+	if expected == 0 {
+		expected = defaultStartup
+	}
+	remaining := expected
+	if startQueue.position(service) == 0 {
+		// Once out of the queue, the service started at startedAt
+		remaining -= now.Sub(service.startedAt)
+	}
+	if remaining < time.Second {
+		return time.Second
+	}
+	return remaining
+}
+
+// setAcceptedHeaders sets the headers of a 202 response for a starting service: when to retry, and where to poll its status
+func setAcceptedHeaders(w http.ResponseWriter, service *Service) {
+	w.Header().Set("Retry-After", retryAfter(service.remainingStartup(time.Now())))
+	w.Header().Set("Location", "/api/services/"+url.PathEscape(service.name)+"/status")
+}
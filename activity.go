@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to keep it up while its containers are active,
+// even when the proxy does not request it anymore (e.g. WebSocket connections)
+const (
+	idleNetworkLabel = "ondemand.idle.network"
+	idleCPULabel     = "ondemand.idle.cpu"
+)
+
+// activitySample holds the cumulated counters of the containers of a service at a given time
+type activitySample struct {
+	time         time.Time
+	cpuNanos     uint64
+	networkBytes uint64
+}
+
+// activityThresholds are the usages above which a service is considered active
+type activityThresholds struct {
+	// networkBytesPerSecond is the received and sent bytes per second
+	networkBytesPerSecond float64
+	// cpuPercent is the percentage of one CPU
+	cpuPercent float64
+}
+
+func parseActivityThresholds(labels map[string]string) (*activityThresholds, error) {
+	thresholds := &activityThresholds{}
+	configured := false
+	if value, ok := labels[idleNetworkLabel]; ok {
+		network, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be a number of bytes per second", idleNetworkLabel)
+		}
+		thresholds.networkBytesPerSecond = network
+		configured = true
+	}
+	if value, ok := labels[idleCPULabel]; ok {
+		cpu, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be a percentage of one CPU", idleCPULabel)
+		}
+		thresholds.cpuPercent = cpu
+		configured = true
+	}
+	if !configured {
+		return nil, nil
+	}
+	return thresholds, nil
+}
+
+func sampleActivity(ctx context.Context, client *client.Client, containerIDs []string) (*activitySample, error) {
+	sample := &activitySample{time: time.Now()}
+	for _, containerID := range containerIDs {
+		response, err := containerClient(client, containerID).ContainerStats(ctx, containerID, false)
+		if err != nil {
+			return nil, err
+		}
+		stats := types.StatsJSON{}
+		err = json.NewDecoder(response.Body).Decode(&stats)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		sample.cpuNanos += stats.CPUStats.CPUUsage.TotalUsage
+		for _, network := range stats.Networks {
+			sample.networkBytes += network.RxBytes + network.TxBytes
+		}
+	}
+	return sample, nil
+}
+
+// isActive reports whether the containers of the service used more network or CPU than their thresholds
+// since the previous call, in which case the service should not be stopped yet
+func (service *Service) isActive(client *client.Client) bool {
+	if service.members != nil {
+		active := false
+		for _, member := range service.members {
+			if member.isActive(client) {
+				active = true
+			}
+		}
+		return active
+	}
+
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return false
+	}
+	thresholds, err := parseActivityThresholds(service.labels(dockerService))
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	if thresholds == nil {
+		return false
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	sample, err := sampleActivity(ctx, client, containerIDs)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	previous := service.lastActivity
+	service.lastActivity = sample
+	if previous == nil || sample.cpuNanos < previous.cpuNanos || sample.networkBytes < previous.networkBytes {
+		// Without a previous sample of the same containers, the service is active until the next window
+		return true
+	}
+
+	seconds := sample.time.Sub(previous.time).Seconds()
+	if seconds <= 0 {
+		return false
+	}
+	networkRate := float64(sample.networkBytes-previous.networkBytes) / seconds
+	cpuPercent := float64(sample.cpuNanos-previous.cpuNanos) / (seconds * 1e9) * 100
+	if thresholds.networkBytesPerSecond > 0 && networkRate > thresholds.networkBytesPerSecond {
+		fmt.Printf("- Service %v is still active: %.0f bytes/s on the network\n", service.name, networkRate)
+		return true
+	}
+	if thresholds.cpuPercent > 0 && cpuPercent > thresholds.cpuPercent {
+		fmt.Printf("- Service %v is still active: %.1f%% of CPU\n", service.name, cpuPercent)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// serviceDump is the in-memory state of a service, for debugging
+type serviceDump struct {
+	Name             string    `json:"name"`
+	Timeout          uint64    `json:"timeout"`
+	Status           Status    `json:"status,omitempty"`
+	Strategy         Strategy  `json:"strategy,omitempty"`
+	Handled          bool      `json:"handled"`
+	Missing          bool      `json:"missing,omitempty"`
+	Parent           string    `json:"parent,omitempty"`
+	Members          []string  `json:"members,omitempty"`
+	Sessions         int       `json:"sessions"`
+	ProxyConnections int32     `json:"proxyConnections"`
+	StartedAt        time.Time `json:"startedAt,omitempty"`
+	StoppedAt        time.Time `json:"stoppedAt,omitempty"`
+	LastRequestAt    time.Time `json:"lastRequestAt,omitempty"`
+	DeferredSince    time.Time `json:"deferredSince,omitempty"`
+	ReadyContainer   string    `json:"readyContainer,omitempty"`
+}
+
+type registryDump struct {
+	Goroutines int            `json:"goroutines"`
+	Services   []serviceDump  `json:"services"`
+	Registered []string       `json:"registered"`
+	Queue      map[string]int `json:"queue,omitempty"`
+}
+
+func dumpRegistry() registryDump {
+	servicesMutex.Lock()
+	list := []*Service{}
+	for _, service := range services {
+		list = append(list, service)
+	}
+	servicesMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	dump := registryDump{Goroutines: runtime.NumGoroutine(), Services: []serviceDump{}, Registered: []string{}, Queue: map[string]int{}}
+	for _, service := range list {
+		serviceDump := serviceDump{
+			Name:             service.name,
+			Timeout:          service.timeout,
+			Status:           service.status,
+			Strategy:         service.strategy,
+			Handled:          service.isHandled,
+			Missing:          service.missing,
+			Sessions:         service.countSessions(),
+			ProxyConnections: atomic.LoadInt32(&service.proxyConnections),
+			StartedAt:        service.startedAt,
+			StoppedAt:        service.stoppedAt,
+			LastRequestAt:    service.lastRequestAt,
+			DeferredSince:    service.deferredSince,
+			ReadyContainer:   service.readyContainer,
+		}
+		if service.parent != nil {
+			serviceDump.Parent = service.parent.name
+		}
+		for name := range service.members {
+			serviceDump.Members = append(serviceDump.Members, name)
+		}
+		sort.Strings(serviceDump.Members)
+		if position := startQueue.position(service); position > 0 {
+			dump.Queue[service.name] = position
+		}
+		dump.Services = append(dump.Services, serviceDump)
+	}
+	registryMutex.RLock()
+	for name := range registrations {
+		dump.Registered = append(dump.Registered, name)
+	}
+	registryMutex.RUnlock()
+	sort.Strings(dump.Registered)
+	return dump
+}
+
+// requireToken rejects the requests without the bearer token, or a valid JWT of an operator, when there is one
+func requireToken(token string, handler http.Handler) http.Handler {
+	if token == "" && oidcVerifier == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		static := token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+		if !static && (oidcVerifier == nil || oidcVerifier.operatorToken(r) == nil) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("a valid bearer token is required"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// adminMux serves the debug endpoints on the admin listener
+var adminMux = http.NewServeMux()
+
+func init() {
+	adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+	adminMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	adminMux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Handler("goroutine").ServeHTTP(w, withQuery(r, "debug", "2"))
+	})
+	adminMux.HandleFunc("/debug/registry", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, dumpRegistry())
+	})
+}
+
+// withQuery returns the request with a query parameter set
+func withQuery(r *http.Request, key string, value string) *http.Request {
+	query := r.URL.Query()
+	query.Set(key, value)
+	clone := r.Clone(r.Context())
+	clone.URL.RawQuery = query.Encode()
+	return clone
+}
+
+// isPublicEndpoint reports whether the request is served by the public listener with --admin-api: the wake requests,
+// the metrics and the sessions and status of the services, used by traefik, the plugin and the waiting pages
+func isPublicEndpoint(r *http.Request) bool {
+	if isPublic(r) || !isManagement(r) {
+		return true
+	}
+	segments := pathSegments(r, "/api/services")
+	if !strings.HasPrefix(r.URL.Path, "/api/services/") || len(segments) < 2 {
+		return false
+	}
+	switch {
+	case segments[1] == "sessions":
+		return true
+	case len(segments) == 2 && (segments[1] == "status" || segments[1] == "wait"):
+		return r.Method == http.MethodGet
+	}
+	return false
+}
+
+// publicEndpoints answers not found to the requests of the endpoints served by the admin listener only
+func publicEndpoints(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isPublicEndpoint(r) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("%s %s is served on the admin listener", r.Method, r.URL.Path))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// serveAdmin serves the admin endpoints, on a listener that should not be exposed publicly
+func serveAdmin(address string, token string) error {
+	fmt.Printf("Admin listening on %s.\n", address)
+	return http.ListenAndServe(address, logRequests(recoverPanics(requireNetworks(allowedNetworks, requireToken(token, adminMux)))))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// agentRoutes are the docker API endpoints the agents expose, by method: the container operations the controller
+// needs on the node of a container and the image pulls of the pre-pull, but nothing that could create or reconfigure
+// containers
+var agentRoutes = map[string]*regexp.Regexp{
+	http.MethodGet:    regexp.MustCompile(`^(/v[0-9.]+)?/(_ping|version|containers/[^/]+/(json|logs|stats|checkpoints)|exec/[^/]+/json)$`),
+	http.MethodPost:   regexp.MustCompile(`^(/v[0-9.]+)?/(containers/[^/]+/(exec|pause|unpause|kill|wait|start|checkpoints)|exec/[^/]+/start|images/create)$`),
+	http.MethodDelete: regexp.MustCompile(`^(/v[0-9.]+)?/containers/[^/]+/checkpoints/[^/]+$`),
+}
+
+var (
+	execCreatePath = regexp.MustCompile(`^(/v[0-9.]+)?/containers/[^/]+/exec$`)
+	execStartPath  = regexp.MustCompile(`^(/v[0-9.]+)?/exec/[^/]+/start$`)
+)
+
+// checkAgentBody rejects the execs other than the detached, unprivileged sh -c commands of the exec probes
+func checkAgentBody(r *http.Request) error {
+	var check func(body []byte) error
+	switch {
+	case execCreatePath.MatchString(r.URL.Path):
+		check = func(body []byte) error {
+			var config types.ExecConfig
+			if err := json.Unmarshal(body, &config); err != nil {
+				return err
+			}
+			if config.Privileged || config.User != "" || config.Tty || config.AttachStdin || len(config.Env) > 0 ||
+				len(config.Cmd) != 3 || config.Cmd[0] != "sh" || config.Cmd[1] != "-c" {
+				return fmt.Errorf("only the exec probes are allowed")
+			}
+			return nil
+		}
+	case execStartPath.MatchString(r.URL.Path):
+		check = func(body []byte) error {
+			var start types.ExecStartCheck
+			if err := json.Unmarshal(body, &start); err != nil {
+				return err
+			}
+			if !start.Detach {
+				return fmt.Errorf("only detached execs are allowed")
+			}
+			return nil
+		}
+	default:
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return check(body)
+}
+
+// serveAgent exposes the container operations of the local docker socket to the controller, over TLS when it has
+// a certificate. Without one, the token travels in clear: the agent then only listens on an explicit address
+// (loopback or overlay), never on all the interfaces of the node
+func serveAgent(address string, token string, certFile string, keyFile string) error {
+	if token == "" {
+		return fmt.Errorf("--agent requires --agent-token")
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("--agent-cert and --agent-key should be set together")
+	}
+	if certFile == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			return fmt.Errorf("--agent without --agent-cert should listen on a loopback or overlay address (e.g. 10.0.0.11:10001), not %s", address)
+		}
+	}
+	network, socket := "unix", strings.TrimPrefix(client.DefaultDockerHost, "unix://")
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		parts := strings.SplitN(host, "://", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("DOCKER_HOST should be unix://path or tcp://host:port")
+		}
+		network, socket = parts[0], parts[1]
+	}
+	var dialer net.Dialer
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = "docker"
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, socket)
+			},
+		},
+		// Logs and stats are streamed
+		FlushInterval: -1,
+	}
+	fmt.Printf("Agent listening on %s.\n", address)
+	expected := []byte("Bearer " + token)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if route, ok := agentRoutes[r.Method]; !ok || !route.MatchString(r.URL.Path) {
+			http.Error(w, fmt.Sprintf("%s %s is not allowed", r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		if err := checkAgentBody(r); err != nil {
+			http.Error(w, fmt.Sprintf("%s %s is not allowed: %v", r.Method, r.URL.Path, err), http.StatusForbidden)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+	if certFile != "" {
+		return http.ListenAndServeTLS(address, certFile, keyFile, handler)
+	}
+	return http.ListenAndServe(address, handler)
+}
+
+// agents are the docker clients of the agents, by swarm node ID or hostname
+var agents = map[string]*client.Client{}
+
+// parseAgents parses the comma separated node=host:port addresses of the agents, reached over TLS when caFile
+// holds the certificate authority of their certificates
+func parseAgents(addresses string, token string, caFile string, version string) (map[string]*client.Client, error) {
+	parsed := map[string]*client.Client{}
+	headers := map[string]string{}
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	var httpClient *http.Client
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("--agent-ca should hold PEM certificates")
+		}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	for _, agent := range strings.Split(addresses, ",") {
+		parts := strings.SplitN(strings.TrimSpace(agent), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("agent %s should be node=host:port", agent)
+		}
+		agentClient, err := client.NewClient("tcp://"+parts[1], version, httpClient, headers)
+		if err != nil {
+			return nil, err
+		}
+		parsed[parts[0]] = agentClient
+	}
+	return parsed, nil
+}
+
+var nodesMutex sync.Mutex
+
+// containerNodes holds the swarm node ID of the containers of the tasks, by container ID
+var containerNodes = map[string]string{}
+
+// serviceContainers holds the containers of containerNodes, by docker service ID, for those removed to be evicted
+var serviceContainers = map[string]map[string]bool{}
+
+// nodeHostnames caches the hostnames of the swarm nodes, by node ID
+var nodeHostnames = map[string]string{}
+
+func recordContainerNode(serviceID string, containerID string, nodeID string) {
+	nodesMutex.Lock()
+	defer nodesMutex.Unlock()
+	containerNodes[containerID] = nodeID
+	if serviceContainers[serviceID] == nil {
+		serviceContainers[serviceID] = map[string]bool{}
+	}
+	serviceContainers[serviceID][containerID] = true
+}
+
+// evictContainerNodes forgets the nodes of the containers of a docker service, but those kept
+func evictContainerNodes(serviceID string, kept []string) {
+	nodesMutex.Lock()
+	defer nodesMutex.Unlock()
+	keep := map[string]bool{}
+	for _, containerID := range kept {
+		keep[containerID] = true
+	}
+	for containerID := range serviceContainers[serviceID] {
+		if !keep[containerID] {
+			delete(containerNodes, containerID)
+			delete(serviceContainers[serviceID], containerID)
+		}
+	}
+	if len(serviceContainers[serviceID]) == 0 {
+		delete(serviceContainers, serviceID)
+	}
+}
+
+// containerClient returns the client of the agent of the node of a container, or cli when the node has no agent
+func containerClient(cli *client.Client, containerID string) *client.Client {
+	if len(agents) == 0 {
+		return cli
+	}
+	nodesMutex.Lock()
+	nodeID := containerNodes[containerID]
+	hostname, known := nodeHostnames[nodeID]
+	nodesMutex.Unlock()
+	if nodeID == "" {
+		return cli
+	}
+	if agent, ok := agents[nodeID]; ok {
+		return agent
+	}
+	if !known {
+		ctx, cancel := dockerContext(context.Background())
+		node, _, err := cli.NodeInspectWithRaw(ctx, nodeID)
+		cancel()
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			return cli
+		}
+		hostname = node.Description.Hostname
+		nodesMutex.Lock()
+		nodeHostnames[nodeID] = hostname
+		nodesMutex.Unlock()
+	}
+	if agent, ok := agents[hostname]; ok {
+		return agent
+	}
+	return cli
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestServeAgentAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		cert    string
+		key     string
+		err     string
+	}{
+		{"all interfaces without certificate", ":10001", "", "", "--agent without --agent-cert should listen on a loopback or overlay address (e.g. 10.0.0.11:10001), not :10001"},
+		{"unspecified IPv4 without certificate", "0.0.0.0:10001", "", "", "--agent without --agent-cert should listen on a loopback or overlay address (e.g. 10.0.0.11:10001), not 0.0.0.0:10001"},
+		{"unspecified IPv6 without certificate", "[::]:10001", "", "", "--agent without --agent-cert should listen on a loopback or overlay address (e.g. 10.0.0.11:10001), not [::]:10001"},
+		{"certificate without key", ":10001", "agent.pem", "", "--agent-cert and --agent-key should be set together"},
+		{"key without certificate", ":10001", "", "agent.key", "--agent-cert and --agent-key should be set together"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := serveAgent(test.address, "token", test.cert, test.key)
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestEvictContainerNodes(t *testing.T) {
+	defer func() {
+		containerNodes = map[string]string{}
+		serviceContainers = map[string]map[string]bool{}
+	}()
+	recordContainerNode("web", "removed", "node-1")
+	recordContainerNode("web", "running", "node-1")
+	recordContainerNode("web", "checkpointed", "node-2")
+	recordContainerNode("api", "other", "node-2")
+
+	evictContainerNodes("web", []string{"running", "checkpointed", "unknown"})
+	expected := map[string]string{"running": "node-1", "checkpointed": "node-2", "other": "node-2"}
+	if !reflect.DeepEqual(containerNodes, expected) {
+		t.Errorf("expected %v, got %v", expected, containerNodes)
+	}
+
+	evictContainerNodes("web", nil)
+	if _, ok := serviceContainers["web"]; ok || len(containerNodes) != 1 {
+		t.Errorf("expected the containers of the stopped service to be forgotten, got %v", containerNodes)
+	}
+}
+
+func TestAgentRoutes(t *testing.T) {
+	tests := []struct {
+		method  string
+		path    string
+		allowed bool
+	}{
+		{http.MethodGet, "/v1.25/containers/abc/json", true},
+		{http.MethodPost, "/v1.25/containers/abc/pause", true},
+		{http.MethodPost, "/v1.25/images/create", true},
+		{http.MethodDelete, "/v1.25/containers/abc/checkpoints/ondemand", true},
+		{http.MethodPost, "/v1.25/containers/create", false},
+		{http.MethodPost, "/v1.25/services/create", false},
+		{http.MethodDelete, "/v1.25/images/alpine", false},
+		{http.MethodPost, "/v1.25/containers/abc/update", false},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			route, ok := agentRoutes[test.method]
+			if allowed := ok && route.MatchString(test.path); allowed != test.allowed {
+				t.Errorf("expected allowed to be %v", test.allowed)
+			}
+		})
+	}
+}
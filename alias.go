@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+// aliases maps request names or hosts to the name of the service they wake up
+var aliases = map[string]string{}
+
+func loadAliases(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]string{}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	return loaded, nil
+}
+
+// requestHost returns the host, without port, the original request was sent to
+func requestHost(r *http.Request) string {
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// resolveName returns the service name an alias points to, or name itself
+func resolveName(name string) string {
+	if target, ok := aliases[name]; ok {
+		return target
+	}
+	return name
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// allowedNetworks are the networks from which the API accepts requests, any when empty
+var allowedNetworks []*net.IPNet
+
+// parseCIDRs parses comma separated CIDRs, a single IP being accepted as a /32 or /128 network
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%s is not a valid IP nor CIDR", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid CIDR", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isAllowed reports whether the peer of the request is in one of the networks.
+// The forwarded headers are ignored, the peer being the proxy calling the API (e.g. traefik)
+func isAllowed(networks []*net.IPNet, r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireNetworks rejects the requests coming from outside of the networks, when there are some
+func requireNetworks(networks []*net.IPNet, handler http.Handler) http.Handler {
+	if len(networks) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAllowed(networks, r) {
+			fmt.Printf("- Request from %s rejected: not in the allowed networks\n", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, fmt.Errorf("requests from %s are not allowed", r.RemoteAddr))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		networks []string
+		err      string
+	}{
+		{"IPv4 CIDRs", "10.0.0.0/8, 192.168.1.0/24", []string{"10.0.0.0/8", "192.168.1.0/24"}, ""},
+		{"IPv6 CIDR", "fd00::/8", []string{"fd00::/8"}, ""},
+		{"single IPv4", "10.0.0.1", []string{"10.0.0.1/32"}, ""},
+		{"single IPv6", "::1", []string{"::1/128"}, ""},
+		{"empty entries", ",10.0.0.0/8,,", []string{"10.0.0.0/8"}, ""},
+		{"invalid IP", "10.0.0.256", nil, "10.0.0.256 is not a valid IP nor CIDR"},
+		{"invalid CIDR", "10.0.0.0/33", nil, "10.0.0.0/33 is not a valid CIDR"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			networks, err := parseCIDRs(test.value)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(networks) != len(test.networks) {
+				t.Fatalf("expected %v, got %v", test.networks, networks)
+			}
+			for i, network := range networks {
+				if network.String() != test.networks[i] {
+					t.Errorf("expected %s, got %s", test.networks[i], network)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireNetworks(t *testing.T) {
+	networks, err := parseCIDRs("10.0.0.0/8,fd00::/8,192.168.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := requireNetworks(networks, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		status     int
+	}{
+		{"IPv4 in a network", "10.1.2.3:51000", nil, http.StatusNoContent},
+		{"single IPv4", "192.168.1.5:51000", nil, http.StatusNoContent},
+		{"IPv6 in a network", "[fd00::1]:51000", nil, http.StatusNoContent},
+		{"IPv4 mapped IPv6 in a network", "[::ffff:10.1.2.3]:51000", nil, http.StatusNoContent},
+		{"peer without port", "10.1.2.3", nil, http.StatusNoContent},
+		{"IPv4 outside of the networks", "192.168.1.6:51000", nil, http.StatusForbidden},
+		{"IPv6 outside of the networks", "[2001:db8::1]:51000", nil, http.StatusForbidden},
+		{"invalid peer", "proxy:51000", nil, http.StatusForbidden},
+		{"forwarded from an allowed network", "203.0.113.7:51000", map[string]string{"X-Forwarded-For": "10.1.2.3", "X-Real-Ip": "10.1.2.3", "Forwarded": "for=10.1.2.3"}, http.StatusForbidden},
+		{"allowed proxy forwarding another network", "10.1.2.3:51000", map[string]string{"X-Forwarded-For": "203.0.113.7"}, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+			request.RemoteAddr = test.remoteAddr
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("expected %d, got %d", test.status, recorder.Code)
+			}
+		})
+	}
+
+	// Without networks, every request is accepted
+	request := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	request.RemoteAddr = "203.0.113.7:51000"
+	recorder := httptest.NewRecorder()
+	requireNetworks(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the request to be accepted, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+type apiError struct {
+	Error string `json:"error"`
+	// Suggestions are the names of the services close to a service that could not be found
+	Suggestions []string `json:"suggestions,omitempty"`
+	// Project is the name under which a stack or a compose project deployed a service that could not be found
+	Project string `json:"project,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError answers the error as JSON, the unexpected timeouts and unknown services with their own status
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError && isTimeout(err) {
+		status = http.StatusGatewayTimeout
+	}
+	response := apiError{Error: err.Error()}
+	if notFound, ok := err.(*NotFoundError); ok {
+		if status == http.StatusInternalServerError {
+			status = http.StatusNotFound
+		}
+		response.Suggestions = notFound.suggestions
+		response.Project = notFound.project
+	}
+	writeJSON(w, status, response)
+}
+
+// pathSegments returns the unescaped segments of the path of the request after prefix
+func pathSegments(r *http.Request, prefix string) []string {
+	path := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	if path == "" {
+		return nil
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[i] = unescaped
+		}
+	}
+	return segments
+}
+
+// handleServicesAPI serves /api/services and its sub resources
+func handleServicesAPI(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveServicesAPI(w, r, cli)
+	}
+}
+
+func serveServicesAPI(w http.ResponseWriter, r *http.Request, cli *client.Client) {
+	segments := pathSegments(r, "/api/services")
+	if len(segments) >= 2 && segments[1] == "sessions" {
+		if err := verifySignature(r, segments[0]); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		handleSessionsAPI(w, r, segments[0], segments[2:])
+		return
+	}
+	if len(segments) == 2 && segments[1] == "shutdown" {
+		if err := verifySignature(r, segments[0]); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		handleShutdownAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "drain" {
+		if err := verifySignature(r, segments[0]); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		handleDrainAPI(w, r, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "status" && r.Method == http.MethodGet {
+		handleStatusAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "wait" && r.Method == http.MethodGet {
+		handleWaitAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "budget" && r.Method == http.MethodGet {
+		handleBudgetAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "predictions" {
+		handlePredictionsAPI(w, r, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 1 && segments[0] == "batch" && r.Method == http.MethodPost {
+		handleBatchAPI(w, r, cli)
+		return
+	}
+	if len(segments) == 2 && segments[1] == "transitions" && r.Method == http.MethodGet {
+		handleTransitionsAPI(w, r, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "details" && r.Method == http.MethodGet {
+		handleDetailsAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "logs" && r.Method == http.MethodGet {
+		handleLogsAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "start" && r.Method == http.MethodPost {
+		handleStartAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "stop" && r.Method == http.MethodPost {
+		handleStopAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "restart" && r.Method == http.MethodPost {
+		handleRestartAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "redeploy" && r.Method == http.MethodPost {
+		handleRedeployAPI(w, r, cli, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "override" {
+		handleOverrideAPI(w, r, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "config" {
+		handleServiceConfigAPI(w, r, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) == 2 && segments[1] == "pin" {
+		handlePinAPI(w, r, requestedService(r, segments[0]))
+		return
+	}
+	if len(segments) > 1 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+	name := ""
+	if len(segments) == 1 {
+		name = qualify(requestNamespace(r), segments[0])
+	}
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		registryMutex.RLock()
+		list := []*Registration{}
+		for _, registration := range registrations {
+			if inNamespace(requestNamespace(r), registration.Name) {
+				list = append(list, registration)
+			}
+		}
+		registryMutex.RUnlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writeJSON(w, http.StatusOK, list)
+	case name == "" && r.Method == http.MethodPost:
+		registration := &Registration{}
+		if err := json.NewDecoder(r.Body).Decode(registration); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid registration: %v", err))
+			return
+		}
+		registration.Name = qualify(requestNamespace(r), registration.Name)
+		if err := registration.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := register(registration); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, registration)
+	case name != "" && r.Method == http.MethodDelete:
+		found, err := deregister(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("service %s is not registered", name))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+type sessionResponse struct {
+	ID       string `json:"id"`
+	Sessions int    `json:"sessions"`
+}
+
+// handleSessionsAPI serves POST /api/services/{name}/sessions and DELETE /api/services/{name}/sessions/{id}
+func handleSessionsAPI(w http.ResponseWriter, r *http.Request, name string, segments []string) {
+	service := getService(requestedService(r, name))
+	if service == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s has not been requested yet", name))
+		return
+	}
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		session := sessionResponse{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid session: %v", err))
+				return
+			}
+		}
+		id, err := service.startSession(session.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, sessionResponse{id, service.countSessions()})
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if !service.endSession(segments[0]) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("session %s not found", segments[0]))
+			return
+		}
+		writeJSON(w, http.StatusOK, sessionResponse{segments[0], service.countSessions()})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+// handlePredictionsAPI serves GET, PUT and DELETE /api/services/{name}/predictions
+func handlePredictionsAPI(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, getPredictions(name))
+	case http.MethodPut:
+		override := &PredictionOverride{}
+		if err := json.NewDecoder(r.Body).Decode(override); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid predictions: %v", err))
+			return
+		}
+		if err := override.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := overridePredictions(name, override); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, getPredictions(name))
+	case http.MethodDelete:
+		if err := overridePredictions(name, nil); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, getPredictions(name))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+// handleBudgetAPI serves GET /api/services/{name}/budget
+func handleBudgetAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	service := getService(name)
+	if service == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s has not been requested yet", name))
+		return
+	}
+	budget, err := service.getBudget(cli)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, budget)
+}
+
+type transitionsResponse struct {
+	Name        string       `json:"name"`
+	State       Status       `json:"state"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// handleTransitionsAPI serves GET /api/services/{name}/transitions, the state of the service and its last transitions
+func handleTransitionsAPI(w http.ResponseWriter, r *http.Request, name string) {
+	service := getService(name)
+	if service == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s has not been requested yet", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, transitionsResponse{name, service.machine.State(), service.machine.History()})
+}
+
+type statusResponse struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// State is the state of the service as managed by the scaler (e.g. stopping or failed)
+	State Status `json:"state"`
+	// QueuePosition is the position of the service in line to start, 0 when it is not waiting
+	QueuePosition int `json:"queuePosition,omitempty"`
+	// ContainerState is the docker state of the container of the service (e.g. created, restarting, exited or dead)
+	ContainerState string `json:"containerState,omitempty"`
+	// RetryAt is when a crash looping service can be started again
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+	// StopRetryAt is when the failed stop of the service is attempted again, after StopAttempts failures
+	StopRetryAt  *time.Time `json:"stopRetryAt,omitempty"`
+	StopAttempts int        `json:"stopAttempts,omitempty"`
+	// Members are the status of the services of a group or pattern
+	Members map[string]Status `json:"members,omitempty"`
+}
+
+// handleStatusAPI serves GET /api/services/{name}/status, without starting the service
+func handleStatusAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	service := getService(name)
+	if service == nil {
+		service = &Service{name: name}
+	}
+	status, err := service.getStatus(r.Context(), cli)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := statusResponse{Name: name, Status: status, State: service.machine.State(), ContainerState: service.containerState, QueuePosition: startQueue.position(service)}
+	if service.isBackingOff() != nil {
+		response.RetryAt = &service.backoffUntil
+	}
+	if at, attempts := getStopRetry(name); attempts > 0 {
+		response.StopRetryAt, response.StopAttempts = &at, attempts
+	}
+	if service.members != nil {
+		response.Members = map[string]Status{}
+		for memberName, member := range service.members {
+			response.Members[memberName] = member.status
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
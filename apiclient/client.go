@@ -0,0 +1,721 @@
+// Package apiclient is a Go client of the API of traefik-ondemand-service, as specified by /api/openapi.json
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of a running instance
+type Client struct {
+	// BaseURL is the URL of the instance (e.g. http://ondemand:10000)
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token is the bearer token sent with the requests, when the instance requires one
+	Token string
+}
+
+// New returns a client of the instance at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// Definition describes how to create a docker service that does not exist yet
+type Definition struct {
+	Image          string            `json:"image"`
+	Env            []string          `json:"env,omitempty"`
+	Ports          []string          `json:"ports,omitempty"`
+	Volumes        []string          `json:"volumes,omitempty"`
+	Networks       []string          `json:"networks,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	RemoveWhenIdle bool              `json:"removeWhenIdle,omitempty"`
+}
+
+// Registration is a service registered at runtime
+type Registration struct {
+	Name       string            `json:"name"`
+	Timeout    uint64            `json:"timeout,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Definition *Definition       `json:"definition,omitempty"`
+	Pinned     bool              `json:"pinned,omitempty"`
+}
+
+// Status is the status of a service
+type Status struct {
+	Name          string `json:"name"`
+	Status        string `json:"status"`
+	State         string `json:"state"`
+	QueuePosition int    `json:"queuePosition,omitempty"`
+	// ContainerState is the docker state of the container of the service (e.g. created, restarting, exited or dead)
+	ContainerState string `json:"containerState,omitempty"`
+	// RetryAt is when a crash looping service can be started again
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+	// StopRetryAt is when the failed stop of the service is attempted again, after StopAttempts failures
+	StopRetryAt  *time.Time `json:"stopRetryAt,omitempty"`
+	StopAttempts int        `json:"stopAttempts,omitempty"`
+	// Members are the status of the services of a group or pattern
+	Members map[string]string `json:"members,omitempty"`
+}
+
+// Control is the result of a start, stop, pin or unpin
+type Control struct {
+	Name     string `json:"name"`
+	Response string `json:"response,omitempty"`
+	Pinned   bool   `json:"pinned"`
+}
+
+// Wait is the status of a service once waited for
+type Wait struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// WaitedSeconds is how long the request was held
+	WaitedSeconds float64 `json:"waitedSeconds"`
+}
+
+// ServiceSnapshot is the live state of a service
+type ServiceSnapshot struct {
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	State         string    `json:"state"`
+	Error         string    `json:"error,omitempty"`
+	Timeout       uint64    `json:"timeout"`
+	Remaining     int64     `json:"remaining,omitempty"`
+	Pinned        bool      `json:"pinned"`
+	Sessions      int       `json:"sessions"`
+	StartedAt     time.Time `json:"startedAt,omitempty"`
+	StoppedAt     time.Time `json:"stoppedAt,omitempty"`
+	LastRequestAt time.Time `json:"lastRequestAt,omitempty"`
+}
+
+// Transition is a change of the state of a service
+type Transition struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Transitions are the state of a service and its last transitions
+type Transitions struct {
+	Name        string       `json:"name"`
+	State       string       `json:"state"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// Redeploy is the result of a redeploy
+type Redeploy struct {
+	Name          string `json:"name"`
+	Image         string `json:"image"`
+	PreviousImage string `json:"previousImage"`
+	Pulled        bool   `json:"pulled"`
+}
+
+// Port is a port of a service
+type Port struct {
+	Protocol      string `json:"protocol"`
+	TargetPort    uint32 `json:"targetPort"`
+	PublishedPort uint32 `json:"publishedPort,omitempty"`
+}
+
+// Container is a running container of a service, with a snapshot of its resource usage
+type Container struct {
+	ID               string    `json:"id"`
+	State            string    `json:"state"`
+	Health           string    `json:"health,omitempty"`
+	StartedAt        time.Time `json:"startedAt"`
+	CPUPercent       float64   `json:"cpuPercent"`
+	MemoryBytes      uint64    `json:"memoryBytes"`
+	MemoryLimitBytes uint64    `json:"memoryLimitBytes,omitempty"`
+}
+
+// Details describe a docker service and the state of the scaler about it
+type Details struct {
+	Name           string      `json:"name"`
+	Image          string      `json:"image"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	UpdatedAt      time.Time   `json:"updatedAt"`
+	Replicas       uint64      `json:"replicas"`
+	Ports          []Port      `json:"ports"`
+	Containers     []Container `json:"containers"`
+	Status         string      `json:"status"`
+	State          string      `json:"state"`
+	ContainerState string      `json:"containerState,omitempty"`
+	Strategy       string      `json:"strategy"`
+	Timeout        uint64      `json:"timeout,omitempty"`
+	IdleDeadline   *time.Time  `json:"idleDeadline,omitempty"`
+	Pinned         bool        `json:"pinned"`
+	LastRequestAt  *time.Time  `json:"lastRequestAt,omitempty"`
+	LastError      string      `json:"lastError,omitempty"`
+	Flapping       *Flapping   `json:"flapping,omitempty"`
+}
+
+// Flapping reports the recent wake-ups of a service soon after its stop, and the extension of its timeout
+type Flapping struct {
+	Flaps            int        `json:"flaps"`
+	Factor           int        `json:"factor"`
+	EffectiveTimeout uint64     `json:"effectiveTimeout"`
+	ExtendedUntil    *time.Time `json:"extendedUntil,omitempty"`
+}
+
+// BatchResult is the status of a service, or the response to its start, in a batch
+type BatchResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status,omitempty"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Budget is the runtime budget of a service
+type Budget struct {
+	MaxRuntime   string `json:"maxRuntime,omitempty"`
+	DailyBudget  string `json:"dailyBudget,omitempty"`
+	RuntimeToday string `json:"runtimeToday"`
+	Remaining    string `json:"remaining,omitempty"`
+	Running      bool   `json:"running"`
+}
+
+// Prediction is a time of the week a service is predicted to be requested at
+type Prediction struct {
+	Weekday string `json:"weekday"`
+	Time    string `json:"time"`
+	Weeks   int    `json:"weeks,omitempty"`
+}
+
+// PredictionOverride replaces the predictions of a service computed from its usage
+type PredictionOverride struct {
+	Disabled    bool         `json:"disabled,omitempty"`
+	Predictions []Prediction `json:"predictions,omitempty"`
+}
+
+// Session is a session deferring the stop of a service
+type Session struct {
+	ID       string `json:"id"`
+	Sessions int    `json:"sessions"`
+}
+
+// AuditEvent is an action taken on a service
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason,omitempty"`
+	// RequestID is the correlation ID of the request that led to the action
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Stats is the usage report of a service, or the total of all of them
+type Stats struct {
+	Name                    string    `json:"name,omitempty"`
+	Since                   time.Time `json:"since"`
+	WakeUps                 int       `json:"wakeUps"`
+	RunningSeconds          float64   `json:"runningSeconds"`
+	AverageColdStartSeconds float64   `json:"averageColdStartSeconds"`
+	SavedHours              float64   `json:"savedHours"`
+	CostPerHour             float64   `json:"costPerHour,omitempty"`
+	CostSaved               float64   `json:"costSaved,omitempty"`
+	Shadow                  bool      `json:"shadow,omitempty"`
+}
+
+// StatsReport is the usage report of the services started by the scaler
+type StatsReport struct {
+	Services []Stats `json:"services"`
+	Total    Stats   `json:"total"`
+}
+
+// Error is an error answered by the API
+type Error struct {
+	StatusCode int
+	Message    string `json:"error"`
+	// Suggestions are the names of the services close to a service that could not be found
+	Suggestions []string `json:"suggestions,omitempty"`
+	// Project is the name under which a stack or a compose project deployed a service that could not be found
+	Project string `json:"project,omitempty"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%d: %s", err.StatusCode, err.Message)
+}
+
+func (client *Client) do(method string, path string, body interface{}, result interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request, err := http.NewRequest(method, client.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := client.send(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{StatusCode: response.StatusCode}
+		if json.Unmarshal(content, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(content))
+		}
+		return apiErr
+	}
+	if result == nil || len(content) == 0 {
+		return nil
+	}
+	return json.Unmarshal(content, result)
+}
+
+func servicePath(name string, resource string) string {
+	path := "/api/services/" + url.PathEscape(name)
+	if resource != "" {
+		path += "/" + resource
+	}
+	return path
+}
+
+// send sends the request with the token of the client
+func (client *Client) send(request *http.Request) (*http.Response, error) {
+	if client.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+	return client.HTTPClient.Do(request)
+}
+
+func (client *Client) get(path string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, client.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.send(request)
+}
+
+// Wake wakes the service up if needed and resets its timeout, answering started, starting or exhausted.
+// A zero timeout uses the registered timeout of the service.
+func (client *Client) Wake(name string, timeout uint64) (string, error) {
+	query := url.Values{"name": {name}}
+	if timeout > 0 {
+		query.Set("timeout", strconv.FormatUint(timeout, 10))
+	}
+	response, err := client.get("/?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusAccepted {
+		return "", &Error{StatusCode: response.StatusCode, Message: strings.TrimSpace(string(content))}
+	}
+	return string(content), nil
+}
+
+// ListRegistrations lists the registered services
+func (client *Client) ListRegistrations() ([]Registration, error) {
+	registrations := []Registration{}
+	err := client.do(http.MethodGet, "/api/services", nil, &registrations)
+	return registrations, err
+}
+
+// Register registers a service, replacing its previous registration
+func (client *Client) Register(registration Registration) (*Registration, error) {
+	registered := &Registration{}
+	err := client.do(http.MethodPost, "/api/services", registration, registered)
+	return registered, err
+}
+
+// Deregister deregisters a service
+func (client *Client) Deregister(name string) error {
+	return client.do(http.MethodDelete, servicePath(name, ""), nil, nil)
+}
+
+// GetStatus reports the status of the service without waking it up
+func (client *Client) GetStatus(name string) (*Status, error) {
+	status := &Status{}
+	err := client.do(http.MethodGet, servicePath(name, "status"), nil, status)
+	return status, err
+}
+
+func timeoutQuery(timeout uint64) string {
+	if timeout == 0 {
+		return ""
+	}
+	return "?timeout=" + strconv.FormatUint(timeout, 10)
+}
+
+// Start wakes the service up if needed and resets its timeout, the registered or last one when timeout is zero
+func (client *Client) Start(name string, timeout uint64) (*Control, error) {
+	control := &Control{}
+	err := client.do(http.MethodPost, servicePath(name, "start")+timeoutQuery(timeout), nil, control)
+	return control, err
+}
+
+// Restart replaces the containers of the service and waits until it is started again, up to wait
+// (the default of the server when zero)
+func (client *Client) Restart(name string, timeout uint64, wait time.Duration) (*Control, error) {
+	query := url.Values{}
+	if timeout > 0 {
+		query.Set("timeout", strconv.FormatUint(timeout, 10))
+	}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	path := servicePath(name, "restart")
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	control := &Control{}
+	err := client.do(http.MethodPost, path, nil, control)
+	return control, err
+}
+
+// Wait waits until the service is up, without waking it up, up to timeout (the default of the server when zero).
+// The status is still not up when the timeout elapsed first
+func (client *Client) Wait(name string, timeout time.Duration) (*Wait, error) {
+	path := servicePath(name, "wait")
+	if timeout > 0 {
+		path += "?timeout=" + url.QueryEscape(timeout.String())
+	}
+	wait := &Wait{}
+	err := client.do(http.MethodGet, path, nil, wait)
+	return wait, err
+}
+
+// Redeploy replaces the containers of the service by new ones from its spec, from the latest image of its tag with pull
+func (client *Client) Redeploy(name string, pull bool) (*Redeploy, error) {
+	path := servicePath(name, "redeploy")
+	if pull {
+		path += "?pull=true"
+	}
+	redeploy := &Redeploy{}
+	err := client.do(http.MethodPost, path, nil, redeploy)
+	return redeploy, err
+}
+
+// Stop stops the service without waiting for its timeout
+func (client *Client) Stop(name string) (*Control, error) {
+	control := &Control{}
+	err := client.do(http.MethodPost, servicePath(name, "stop"), nil, control)
+	return control, err
+}
+
+// Pin keeps the service up until it is unpinned
+func (client *Client) Pin(name string, timeout uint64) (*Control, error) {
+	control := &Control{}
+	err := client.do(http.MethodPut, servicePath(name, "pin")+timeoutQuery(timeout), nil, control)
+	return control, err
+}
+
+// ServiceConfig is the configuration of a service that can be changed at runtime
+type ServiceConfig struct {
+	Timeout uint64            `json:"timeout,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Pinned  bool              `json:"pinned"`
+}
+
+// ServiceConfigPatch changes the fields it sets, the labels with an empty value being removed
+type ServiceConfigPatch struct {
+	Timeout *uint64           `json:"timeout,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Pinned  *bool             `json:"pinned,omitempty"`
+}
+
+// Override is an environment and a command applied to the containers of a service when it is woken up
+type Override struct {
+	Env     []string `json:"env,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+// SetOverride sets the environment and command override of the next wake-up of the service
+func (client *Client) SetOverride(name string, override Override) (*Override, error) {
+	result := &Override{}
+	err := client.do(http.MethodPut, servicePath(name, "override"), override, result)
+	return result, err
+}
+
+// ClearOverride cancels the override of the next wake-up of the service
+func (client *Client) ClearOverride(name string) error {
+	return client.do(http.MethodDelete, servicePath(name, "override"), nil, nil)
+}
+
+// GetConfig returns the flags of the instance that can be changed at runtime, by name
+func (client *Client) GetConfig() (map[string]string, error) {
+	config := map[string]string{}
+	err := client.do(http.MethodGet, "/api/config", nil, &config)
+	return config, err
+}
+
+// UpdateConfig changes flags of the instance at runtime (e.g. max-running), persisted in its state
+func (client *Client) UpdateConfig(changes map[string]string) (map[string]string, error) {
+	config := map[string]string{}
+	err := client.do(http.MethodPatch, "/api/config", changes, &config)
+	return config, err
+}
+
+// GetServiceConfig returns the configuration of the service that can be changed at runtime
+func (client *Client) GetServiceConfig(name string) (*ServiceConfig, error) {
+	config := &ServiceConfig{}
+	err := client.do(http.MethodGet, servicePath(name, "config"), nil, config)
+	return config, err
+}
+
+// UpdateServiceConfig changes the timeout, labels or pin of the service, persisted in its registration
+func (client *Client) UpdateServiceConfig(name string, patch ServiceConfigPatch) (*ServiceConfig, error) {
+	config := &ServiceConfig{}
+	err := client.do(http.MethodPatch, servicePath(name, "config"), patch, config)
+	return config, err
+}
+
+// Unpin lets the service be stopped when idle again
+func (client *Client) Unpin(name string) (*Control, error) {
+	control := &Control{}
+	err := client.do(http.MethodDelete, servicePath(name, "pin"), nil, control)
+	return control, err
+}
+
+// ListStatus reports the live state of the requested and registered services
+func (client *Client) ListStatus() ([]ServiceSnapshot, error) {
+	snapshots := []ServiceSnapshot{}
+	err := client.do(http.MethodGet, "/api/status", nil, &snapshots)
+	return snapshots, err
+}
+
+// Logs returns the last tail lines of the logs of the service, to be closed by the caller
+func (client *Client) Logs(name string, tail string, follow bool) (io.ReadCloser, error) {
+	path := servicePath(name, "logs")
+	query := url.Values{}
+	if tail != "" {
+		query.Set("tail", tail)
+	}
+	if follow {
+		query.Set("follow", "true")
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	response, err := client.get(path)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		apiErr := &Error{StatusCode: response.StatusCode}
+		json.NewDecoder(response.Body).Decode(apiErr)
+		return nil, apiErr
+	}
+	return response.Body, nil
+}
+
+// BatchStatus reports the status of several services
+func (client *Client) BatchStatus(names []string) ([]BatchResult, error) {
+	results := []BatchResult{}
+	err := client.do(http.MethodPost, "/api/services/batch", map[string]interface{}{"names": names}, &results)
+	return results, err
+}
+
+// BatchStart wakes several services up in dependency order, a zero timeout using their registered or last one.
+// The services waiting for their dependencies are reported as starting, calling it again moves the batch forward.
+func (client *Client) BatchStart(names []string, timeout uint64) ([]BatchResult, error) {
+	results := []BatchResult{}
+	request := map[string]interface{}{"names": names, "start": true, "timeout": timeout}
+	err := client.do(http.MethodPost, "/api/services/batch", request, &results)
+	return results, err
+}
+
+// GetTransitions reports the state of the service and its last transitions
+func (client *Client) GetTransitions(name string) (*Transitions, error) {
+	transitions := &Transitions{}
+	err := client.do(http.MethodGet, servicePath(name, "transitions"), nil, transitions)
+	return transitions, err
+}
+
+// GetDetails describes the docker service, its running containers and the state of the scaler about it
+func (client *Client) GetDetails(name string) (*Details, error) {
+	details := &Details{}
+	err := client.do(http.MethodGet, servicePath(name, "details"), nil, details)
+	return details, err
+}
+
+// GetBudget reports the runtime budget of the service
+func (client *Client) GetBudget(name string) (*Budget, error) {
+	budget := &Budget{}
+	err := client.do(http.MethodGet, servicePath(name, "budget"), nil, budget)
+	return budget, err
+}
+
+// GetPredictions lists the predicted requests of the service
+func (client *Client) GetPredictions(name string) ([]Prediction, error) {
+	predictions := []Prediction{}
+	err := client.do(http.MethodGet, servicePath(name, "predictions"), nil, &predictions)
+	return predictions, err
+}
+
+// OverridePredictions replaces the predictions computed from the usage of the service
+func (client *Client) OverridePredictions(name string, override PredictionOverride) ([]Prediction, error) {
+	predictions := []Prediction{}
+	err := client.do(http.MethodPut, servicePath(name, "predictions"), override, &predictions)
+	return predictions, err
+}
+
+// ResetPredictions removes the override of the predictions of the service
+func (client *Client) ResetPredictions(name string) ([]Prediction, error) {
+	predictions := []Prediction{}
+	err := client.do(http.MethodDelete, servicePath(name, "predictions"), nil, &predictions)
+	return predictions, err
+}
+
+// StartSession starts a session deferring the stop of the service, generating its ID when empty
+func (client *Client) StartSession(name string, id string) (*Session, error) {
+	session := &Session{}
+	err := client.do(http.MethodPost, servicePath(name, "sessions"), Session{ID: id}, session)
+	return session, err
+}
+
+// EndSession ends a session of the service
+func (client *Client) EndSession(name string, id string) (*Session, error) {
+	session := &Session{}
+	err := client.do(http.MethodDelete, servicePath(name, "sessions/"+url.PathEscape(id)), nil, session)
+	return session, err
+}
+
+// Drain tells whether a service is draining before its idle stop
+type Drain struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// GetDrain tells whether the service is draining, its new requests not being routed to it anymore
+func (client *Client) GetDrain(name string) (*Drain, error) {
+	drain := &Drain{}
+	err := client.do(http.MethodGet, servicePath(name, "drain"), nil, drain)
+	return drain, err
+}
+
+// ReportDrained reports the in-flight requests of the draining service completed, for it to be stopped
+func (client *Client) ReportDrained(name string) (*Drain, error) {
+	drain := &Drain{}
+	err := client.do(http.MethodPost, servicePath(name, "drain"), nil, drain)
+	return drain, err
+}
+
+// ShutdownNotice tells when a service is stopped, for a countdown banner
+type ShutdownNotice struct {
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	ShutdownAt *time.Time `json:"shutdownAt,omitempty"`
+	ShutdownIn *int64     `json:"shutdownIn,omitempty"`
+	Warning    bool       `json:"warning"`
+	Message    string     `json:"message,omitempty"`
+	KeepAlive  bool       `json:"keepAlive"`
+}
+
+// GetShutdownNotice tells when the service is stopped unless it is requested again
+func (client *Client) GetShutdownNotice(name string) (*ShutdownNotice, error) {
+	notice := &ShutdownNotice{}
+	err := client.do(http.MethodGet, servicePath(name, "shutdown"), nil, notice)
+	return notice, err
+}
+
+// KeepAlive delays the stop of the service on behalf of an active tab
+func (client *Client) KeepAlive(name string) (*ShutdownNotice, error) {
+	notice := &ShutdownNotice{}
+	err := client.do(http.MethodPost, servicePath(name, "shutdown"), nil, notice)
+	return notice, err
+}
+
+// GetAuditLog lists the actions taken on the services, only those of service when it is not empty
+func (client *Client) GetAuditLog(service string) ([]AuditEvent, error) {
+	path := "/api/audit"
+	if service != "" {
+		path += "?" + url.Values{"service": {service}}.Encode()
+	}
+	events := []AuditEvent{}
+	err := client.do(http.MethodGet, path, nil, &events)
+	return events, err
+}
+
+// GetStats reports the usage of the services started by the scaler and the time saved by stopping them
+func (client *Client) GetStats() (*StatsReport, error) {
+	report := &StatsReport{}
+	err := client.do(http.MethodGet, "/api/stats", nil, report)
+	return report, err
+}
+
+// Activity is the activity signal of a service, for external autoscalers
+type Activity struct {
+	Name              string     `json:"name"`
+	Requests          int        `json:"requests"`
+	RequestsPerSecond float64    `json:"requestsPerSecond"`
+	LastRequestAt     *time.Time `json:"lastRequestAt,omitempty"`
+	Active            bool       `json:"active"`
+	State             string     `json:"state"`
+}
+
+// ListActivity reports the rate of the wake requests of the services and their last request
+func (client *Client) ListActivity() ([]Activity, error) {
+	report := &struct {
+		Services []Activity `json:"services"`
+	}{}
+	err := client.do(http.MethodGet, "/api/activity", nil, report)
+	return report.Services, err
+}
+
+// GetActivity reports the activity of the service, idle when it is unknown to the scaler
+func (client *Client) GetActivity(name string) (*Activity, error) {
+	activity := &Activity{}
+	err := client.do(http.MethodGet, "/api/activity/"+url.PathEscape(name), nil, activity)
+	return activity, err
+}
+
+// ImportResult reports what an import restored
+type ImportResult struct {
+	Services      int `json:"services"`
+	Registrations int `json:"registrations"`
+	Stats         int `json:"stats"`
+}
+
+// ExportState snapshots the state of the scaler (services, deadlines, pins, registrations and statistics), to be
+// imported as is by another instance
+func (client *Client) ExportState() (json.RawMessage, error) {
+	export := json.RawMessage{}
+	err := client.do(http.MethodGet, "/api/state/export", nil, &export)
+	return export, err
+}
+
+// ImportState restores a snapshot of ExportState, replacing the state of the services it holds
+func (client *Client) ImportState(export json.RawMessage) (*ImportResult, error) {
+	result := &ImportResult{}
+	err := client.do(http.MethodPost, "/api/state/import", export, result)
+	return result, err
+}
+
+// TraefikConfig returns the traefik dynamic configuration generated for the services with the ondemand.traefik.rule
+// label, served with --traefik-provider
+func (client *Client) TraefikConfig() (json.RawMessage, error) {
+	config := json.RawMessage{}
+	err := client.do(http.MethodGet, "/api/traefik/config", nil, &config)
+	return config, err
+}
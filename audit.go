@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxAuditEvents is the number of events kept in the audit log
+const maxAuditEvents = 1000
+
+// AuditEvent is an action taken by the scaler on a service
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason,omitempty"`
+	// RequestID is the correlation ID of the request that led to the action
+	RequestID string `json:"requestId,omitempty"`
+}
+
+var auditMutex sync.Mutex
+var auditEvents = []AuditEvent{}
+
+// audit records an action taken on a service in the audit log, requestID being empty for actions not caused by a request
+func audit(service string, action string, reason string, requestID string) {
+	event := AuditEvent{Time: time.Now(), Service: service, Action: action, Reason: reason, RequestID: requestID}
+	fmt.Printf("Audit: %s %s %s request_id=%s\n", event.Service, event.Action, event.Reason, event.RequestID)
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditEvents = append(auditEvents, event)
+	if len(auditEvents) > maxAuditEvents {
+		auditEvents = auditEvents[len(auditEvents)-maxAuditEvents:]
+	}
+}
+
+// auditLog returns the events of the audit log, only those of a service when it is not empty
+func auditLog(service string) []AuditEvent {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	events := []AuditEvent{}
+	for _, event := range auditEvents {
+		if service == "" || event.Service == service {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// handleAuditAPI serves GET /api/audit, filtered by the optional service query parameter
+func handleAuditAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	namespace := requestNamespace(r)
+	service := qualify(namespace, r.URL.Query().Get("service"))
+	events := []AuditEvent{}
+	for _, event := range auditLog(service) {
+		if inNamespace(namespace, event.Service) {
+			events = append(events, event)
+		}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to scale it up with the rate of its wake requests, once woken up
+const (
+	// autoscaleMaxLabel is the maximum number of replicas of the service, which is not scaled beyond one without it
+	autoscaleMaxLabel = "ondemand.autoscale.max"
+	// autoscaleRateLabel is the rate of wake requests per second a replica handles
+	autoscaleRateLabel = "ondemand.autoscale.rate"
+)
+
+const (
+	// rateWindow is the period over which the rate of the wake requests of a service is measured
+	rateWindow = time.Minute
+	// autoscaleInterval is the interval between two adjustments of the replicas of the services
+	autoscaleInterval = 10 * time.Second
+	// autoscaleDownDelay is how long the rate of a service must stay low before it is scaled down, for bursts not to
+	// scale it up and down repeatedly
+	autoscaleDownDelay = 2 * time.Minute
+	// defaultAutoscaleRate is the rate of wake requests per second a replica handles without the label
+	defaultAutoscaleRate = 10.0
+)
+
+func init() {
+	metrics.Register("ondemand_replicas", "gauge", "Number of replicas of a service scaled by the autoscaler")
+}
+
+// requestRate counts the wake requests of a service by second over rateWindow
+type requestRate struct {
+	seconds [int(rateWindow / time.Second)]int
+	last    int64
+}
+
+var requestRates = map[string]*requestRate{}
+var requestRatesMutex sync.Mutex
+
+// add counts a request at now, clearing the seconds elapsed since the last one
+func (rate *requestRate) add(now time.Time, count int) {
+	second := now.Unix()
+	size := int64(len(rate.seconds))
+	if second-rate.last >= size {
+		rate.seconds = [len(rate.seconds)]int{}
+	} else {
+		for elapsed := rate.last + 1; elapsed <= second; elapsed++ {
+			rate.seconds[elapsed%size] = 0
+		}
+	}
+	if second > rate.last {
+		rate.last = second
+	}
+	rate.seconds[second%size] += count
+}
+
+// count returns the number of requests over rateWindow
+func (rate *requestRate) count(now time.Time) int {
+	rate.add(now, 0)
+	total := 0
+	for _, count := range rate.seconds {
+		total += count
+	}
+	return total
+}
+
+// perSecond returns the average number of requests per second over rateWindow
+func (rate *requestRate) perSecond(now time.Time) float64 {
+	return float64(rate.count(now)) / rateWindow.Seconds()
+}
+
+// recordWakeRequest counts a wake request of the service
+func recordWakeRequest(name string, now time.Time) {
+	metrics.Add("ondemand_wake_requests_total", 1, "service", name)
+	requestRatesMutex.Lock()
+	defer requestRatesMutex.Unlock()
+	rate := requestRates[name]
+	if rate == nil {
+		rate = &requestRate{last: now.Unix()}
+		requestRates[name] = rate
+	}
+	rate.add(now, 1)
+}
+
+// wakeRequestRate returns the rate of the wake requests of the service, per second over the last minute
+func wakeRequestRate(name string, now time.Time) float64 {
+	requestRatesMutex.Lock()
+	defer requestRatesMutex.Unlock()
+	if rate := requestRates[name]; rate != nil {
+		return rate.perSecond(now)
+	}
+	return 0
+}
+
+// autoscaleConfig returns the maximum number of replicas of the service and the rate a replica handles, 0 replicas
+// when it is not autoscaled
+func autoscaleConfig(labels map[string]string) (uint64, float64, error) {
+	value, ok := labels[autoscaleMaxLabel]
+	if !ok {
+		return 0, 0, nil
+	}
+	max, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || max < 1 {
+		return 0, 0, fmt.Errorf("%s should be a number of replicas", autoscaleMaxLabel)
+	}
+	perReplica := defaultAutoscaleRate
+	if value, ok := labels[autoscaleRateLabel]; ok {
+		if perReplica, err = strconv.ParseFloat(value, 64); err != nil || perReplica <= 0 {
+			return 0, 0, fmt.Errorf("%s should be a positive number of requests per second", autoscaleRateLabel)
+		}
+	}
+	return max, perReplica, nil
+}
+
+// desiredReplicas returns the replicas handling the rate, between one and max
+func desiredReplicas(rate float64, perReplica float64, max uint64) uint64 {
+	desired := uint64(math.Ceil(rate / perReplica))
+	if desired < 1 {
+		return 1
+	}
+	if desired > max {
+		return max
+	}
+	return desired
+}
+
+// autoscale scales the service, when up, to the replicas handling the rate of its wake requests: up at once, and down
+// once the rate stayed low for autoscaleDownDelay
+func (service *Service) autoscale(cli *client.Client, now time.Time) error {
+	if service.machine.State() != UP || isPattern(service.name) || service.shadow {
+		return nil
+	}
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	dockerService, err := service.getDockerService(ctx, cli)
+	if err != nil {
+		return err
+	}
+	max, perReplica, err := autoscaleConfig(service.labels(dockerService))
+	if err != nil || max == 0 || dockerService.Spec.Mode.Replicated == nil || dockerService.Spec.Mode.Replicated.Replicas == nil {
+		return err
+	}
+	current := *dockerService.Spec.Mode.Replicated.Replicas
+	rate := wakeRequestRate(service.name, now)
+	desired := desiredReplicas(rate, perReplica, max)
+	if desired >= current {
+		service.scaledDownSince = time.Time{}
+	}
+	if desired == current || current == 0 {
+		return nil
+	}
+	if desired < current {
+		if service.scaledDownSince.IsZero() {
+			service.scaledDownSince = now
+		}
+		if now.Sub(service.scaledDownSince) < autoscaleDownDelay {
+			return nil
+		}
+	}
+	reason := fmt.Sprintf("from %d to %d replicas at %.2f requests/s", current, desired, rate)
+	fmt.Printf("- Service %v is autoscaled %s\n", service.name, reason)
+	audit(service.name, "autoscale", reason, "")
+	if dryRun.Get() {
+		return nil
+	}
+	dockerService.Spec.Mode.Replicated.Replicas = getPointer(desired)
+	if _, err := cli.ServiceUpdate(ctx, dockerService.ID, dockerService.Meta.Version, dockerService.Spec, types.ServiceUpdateOptions{}); err != nil {
+		return err
+	}
+	service.scaledDownSince = time.Time{}
+	invalidateStatus(service.name)
+	metrics.Set("ondemand_replicas", float64(desired), "service", service.name)
+	return nil
+}
+
+// runAutoscaler adjusts the replicas of the services every autoscaleInterval
+func runAutoscaler(cli *client.Client) {
+	for now := range time.Tick(autoscaleInterval) {
+		servicesMutex.Lock()
+		list := []*Service{}
+		for _, service := range services {
+			list = append(list, service)
+		}
+		servicesMutex.Unlock()
+		for _, service := range list {
+			if err := service.autoscale(cli, now); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+		}
+	}
+}
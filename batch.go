@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// dependsLabel lists the services (comma separated) that must be started before the service in a batch start
+const dependsLabel = "ondemand.depends"
+
+// batchRequest is the body of POST /api/services/batch
+type batchRequest struct {
+	Names []string `json:"names"`
+	// Start wakes the services up, in dependency order, instead of only reporting their status
+	Start   bool   `json:"start,omitempty"`
+	Timeout uint64 `json:"timeout,omitempty"`
+}
+
+type batchResult struct {
+	Name   string `json:"name"`
+	Status Status `json:"status,omitempty"`
+	// Response is started, starting or exhausted for a start
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// dependencies returns the services the service depends on
+func (service *Service) dependencies(cli *client.Client) ([]string, error) {
+	labels, err := service.config(context.Background(), cli)
+	if err != nil {
+		return nil, err
+	}
+	dependencies := []string{}
+	for _, name := range strings.Split(labels[dependsLabel], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			dependencies = append(dependencies, relatedService(service.name, name))
+		}
+	}
+	return dependencies, nil
+}
+
+// dependencyOrder returns the names and their dependencies, each after its dependencies, with the dependencies of each name
+func dependencyOrder(cli *client.Client, names []string) ([]string, map[string][]string, error) {
+	ordered := []string{}
+	dependencies := map[string][]string{}
+	// visiting detects the cycles, dependencies holding the names already visited
+	visiting := map[string]bool{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		if _, visited := dependencies[name]; visited {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("%s depends on itself", name)
+		}
+		visiting[name] = true
+		service := getService(name)
+		if service == nil {
+			service = &Service{name: name}
+		}
+		nameDependencies, err := service.dependencies(cli)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range nameDependencies {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		dependencies[name] = nameDependencies
+		ordered = append(ordered, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ordered, dependencies, nil
+}
+
+// batchStatus reports the status of the services, without starting them
+func batchStatus(ctx context.Context, cli *client.Client, names []string) []batchResult {
+	results := []batchResult{}
+	for _, name := range names {
+		service := getService(name)
+		if service == nil {
+			service = &Service{name: name}
+		}
+		status, err := service.getStatus(ctx, cli)
+		result := batchResult{Name: name, Status: status}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// batchStart wakes the services up in dependency order: a service is only woken up once its dependencies are started,
+// and is reported as starting meanwhile, so that calling it again moves the batch forward
+func batchStart(ctx context.Context, cli *client.Client, ordered []string, dependencies map[string][]string, timeout uint64, requestID string) []batchResult {
+	responses := map[string]string{}
+	results := []batchResult{}
+	for _, name := range ordered {
+		result := batchResult{Name: name, Response: "starting"}
+		ready := true
+		for _, dependency := range dependencies[name] {
+			ready = ready && responses[dependency] == "started"
+		}
+		serviceTimeout := timeout
+		if registration := getRegistration(name); serviceTimeout == 0 && registration != nil {
+			serviceTimeout = registration.Timeout
+		}
+		if service := getService(name); serviceTimeout == 0 && service != nil {
+			serviceTimeout = service.timeout
+		}
+		if serviceTimeout == 0 {
+			result.Response = ""
+			result.Error = "timeout is required"
+		} else if ready {
+			service := GetOrCreateService(name, serviceTimeout)
+			service.lastRequestAt = time.Now()
+			service.requestID = requestID
+			response, err := service.HandleServiceState(ctx, cli)
+			if err != nil {
+				result.Error = err.Error()
+				response = ""
+			}
+			result.Response = response
+		}
+		responses[name] = result.Response
+		results = append(results, result)
+	}
+	return results
+}
+
+// isBatch reports whether the request is a batch, whose services are only known to its handler
+func isBatch(r *http.Request) bool {
+	segments := pathSegments(r, "/api/services")
+	return strings.HasPrefix(r.URL.Path, "/api/services/") && len(segments) == 1 && segments[0] == "batch"
+}
+
+// checkCovered returns an error for the first service the token of the request does not cover
+func checkCovered(r *http.Request, names []string) error {
+	token := requestToken(r)
+	if token == nil {
+		return nil
+	}
+	for _, name := range names {
+		if !token.covers(name) {
+			return fmt.Errorf("the token is not allowed to act on %s", name)
+		}
+	}
+	return nil
+}
+
+// handleBatchAPI serves POST /api/services/batch
+func handleBatchAPI(w http.ResponseWriter, r *http.Request, cli *client.Client) {
+	request := &batchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid batch: %v", err))
+		return
+	}
+	if len(request.Names) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("names are required"))
+		return
+	}
+	names := []string{}
+	for _, name := range request.Names {
+		names = append(names, requestedService(r, name))
+	}
+	if err := checkCovered(r, names); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if !request.Start {
+		writeJSON(w, http.StatusOK, batchStatus(r.Context(), cli, names))
+		return
+	}
+	ordered, dependencies, err := dependencyOrder(cli, names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// The dependencies are started too
+	if err := checkCovered(r, ordered); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, batchStart(r.Context(), cli, ordered, dependencies, request.Timeout, requestID(r)))
+}
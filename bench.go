@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const benchUsage = `Usage:
+  ondemand bench [flags] [-- scaler flags]
+
+Simulates services with the mock provider and sends them wake requests at a given rate, reporting
+the latencies, the docker calls and the allocations. The scaler flags (e.g. -- --coalesce 0) configure the scaler.
+
+Flags:
+`
+
+// benchResults are the outcomes of the wake requests of a run
+type benchResults struct {
+	mutex     sync.Mutex
+	latencies []time.Duration
+	responses map[string]int
+}
+
+func (results *benchResults) record(latency time.Duration, response string) {
+	results.mutex.Lock()
+	defer results.mutex.Unlock()
+	results.latencies = append(results.latencies, latency)
+	results.responses[response]++
+}
+
+// percentile returns the latency under which are the percent fastest requests, of sorted latencies
+func percentile(latencies []time.Duration, percent float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	index := int(float64(len(latencies))*percent/100+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(latencies) {
+		index = len(latencies) - 1
+	}
+	return latencies[index]
+}
+
+// runBench runs the bench subcommand when it is the one of args, reporting whether it was
+func runBench(args []string) bool {
+	if len(args) == 0 || args[0] != "bench" {
+		return false
+	}
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	count := flags.Int("services", 100, "Number of simulated services")
+	rate := flags.Float64("rate", 200, "Wake requests per second, spread randomly over the services")
+	duration := flags.Duration("duration", 30*time.Second, "Duration of the run")
+	startup := flags.Duration("startup", 2*time.Second, "Startup duration of the simulated containers")
+	timeout := flags.Uint64("timeout", 10, "Idle timeout in seconds of the simulated services, for them to stop and start again during the run")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), benchUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+	flag.CommandLine.Parse(flags.Args())
+	if *count <= 0 || *rate <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := bench(*count, *rate, *duration, *startup, *timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func bench(count int, rate float64, duration time.Duration, startup time.Duration, timeout uint64) error {
+	configs := map[string]*MockService{}
+	for i := 0; i < count; i++ {
+		configs[fmt.Sprintf("bench-%d", i)] = &MockService{startup: startup}
+	}
+	docker := newMockDocker(configs)
+	cli, err := docker.serve()
+	if err != nil {
+		return err
+	}
+	corsPolicy = newCORS(*corsOrigins, *corsMethods)
+	dockerPool = newDockerPool(*dockerConcurrency)
+	handler := handleRequests(cli)
+
+	// The logs of the scaler are silenced during the run
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	fmt.Fprintf(stdout, "Sending %.0f requests per second to %d services for %s...\n", rate, count, duration)
+	os.Stdout = devNull
+
+	results := &benchResults{responses: map[string]int{}}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+	var inFlight, maxInFlight int32
+	var wait sync.WaitGroup
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	end := time.After(duration)
+	started := time.Now()
+send:
+	for {
+		select {
+		case <-end:
+			break send
+		case <-ticker.C:
+		}
+		wait.Add(1)
+		go func(name string) {
+			defer wait.Done()
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+					break
+				}
+			}
+			request := httptest.NewRequest(http.MethodGet, "/?name="+name+"&timeout="+strconv.FormatUint(timeout, 10), nil)
+			recorder := httptest.NewRecorder()
+			requestStarted := time.Now()
+			handler(recorder, request)
+			response := strings.TrimSpace(recorder.Body.String())
+			if recorder.Code >= http.StatusBadRequest {
+				response = strconv.Itoa(recorder.Code)
+			}
+			results.record(time.Since(requestStarted), response)
+		}(fmt.Sprintf("bench-%d", rand.Intn(count)))
+	}
+	ticker.Stop()
+	wait.Wait()
+	elapsed := time.Since(started)
+	runtime.ReadMemStats(&after)
+	os.Stdout = stdout
+
+	sort.Slice(results.latencies, func(i, j int) bool { return results.latencies[i] < results.latencies[j] })
+	requests := len(results.latencies)
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Requests\t%d (%.0f/s)\n", requests, float64(requests)/elapsed.Seconds())
+	for _, percent := range []float64{50, 90, 99} {
+		fmt.Fprintf(writer, "Latency p%.0f\t%s\n", percent, percentile(results.latencies, percent))
+	}
+	fmt.Fprintf(writer, "Latency max\t%s\n", percentile(results.latencies, 100))
+	fmt.Fprintf(writer, "Max in flight\t%d\n", maxInFlight)
+	responses := []string{}
+	for response := range results.responses {
+		responses = append(responses, response)
+	}
+	sort.Strings(responses)
+	for _, response := range responses {
+		fmt.Fprintf(writer, "Response %s\t%d\n", response, results.responses[response])
+	}
+	docker.mutex.Lock()
+	calls := []string{}
+	total := 0
+	for call, made := range docker.calls {
+		calls = append(calls, call)
+		total += made
+	}
+	sort.Strings(calls)
+	fmt.Fprintf(writer, "Docker calls\t%d (%.1f per request)\n", total, float64(total)/float64(requests))
+	for _, call := range calls {
+		fmt.Fprintf(writer, "  %s\t%d\n", call, docker.calls[call])
+	}
+	docker.mutex.Unlock()
+	fmt.Fprintf(writer, "Allocations\t%d (%d per request)\n", after.Mallocs-before.Mallocs, (after.Mallocs-before.Mallocs)/uint64(requests))
+	fmt.Fprintf(writer, "Allocated\t%.1f MB (%.1f kB per request)\n", float64(after.TotalAlloc-before.TotalAlloc)/1e6, float64(after.TotalAlloc-before.TotalAlloc)/1e3/float64(requests))
+	fmt.Fprintf(writer, "GC cycles\t%d\n", after.NumGC-before.NumGC)
+	fmt.Fprintf(writer, "Goroutines left\t%d\n", runtime.NumGoroutine()-goroutines)
+	return writer.Flush()
+}
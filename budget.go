@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to cap its running time
+const (
+	maxRuntimeLabel  = "ondemand.maxruntime"
+	dailyBudgetLabel = "ondemand.budget.daily"
+)
+
+// budgetInterval is the maximum delay between two checks of the budget of a running service
+const budgetInterval = time.Minute
+
+// Budget caps the running time of a service, regardless of its traffic
+type Budget struct {
+	// MaxRuntime is how long the service can run after being started
+	MaxRuntime time.Duration
+	// Daily is how long the service can run per day
+	Daily time.Duration
+}
+
+func parseBudget(labels map[string]string) (Budget, error) {
+	maxRuntime, err := parseDurationLabel(labels, maxRuntimeLabel)
+	if err != nil {
+		return Budget{}, err
+	}
+	daily, err := parseDurationLabel(labels, dailyBudgetLabel)
+	if err != nil {
+		return Budget{}, err
+	}
+	return Budget{MaxRuntime: maxRuntime, Daily: daily}, nil
+}
+
+func startOfDay(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// isRunning reports whether the service was started by the scaler and not stopped since
+func (service *Service) isRunning() bool {
+	return !service.startedAt.IsZero() && service.startedAt.After(service.stoppedAt)
+}
+
+// runtimeToday returns how long the service ran today since being started by the scaler
+func (service *Service) runtimeToday(now time.Time) time.Duration {
+	midnight := startOfDay(now)
+	runtime := time.Duration(0)
+	if service.runtimeDay.Equal(midnight) {
+		runtime = service.runtime
+	}
+	if service.isRunning() {
+		since := service.startedAt
+		if since.Before(midnight) {
+			since = midnight
+		}
+		runtime += now.Sub(since)
+	}
+	return runtime
+}
+
+// recordRuntime adds the running time since the service started to the running time of the day
+func (service *Service) recordRuntime(now time.Time) {
+	if !service.isRunning() {
+		return
+	}
+	service.runtime = service.runtimeToday(now)
+	service.runtimeDay = startOfDay(now)
+}
+
+// remaining returns how long the service can still run, and whether it has a budget at all
+func (service *Service) remaining(budget Budget, now time.Time) (time.Duration, bool) {
+	remaining := time.Duration(-1)
+	if budget.MaxRuntime > 0 && service.isRunning() {
+		remaining = budget.MaxRuntime - now.Sub(service.startedAt)
+	}
+	if budget.Daily > 0 {
+		daily := budget.Daily - service.runtimeToday(now)
+		if remaining < 0 || daily < remaining {
+			remaining = daily
+		}
+	}
+	if budget.MaxRuntime == 0 && budget.Daily == 0 {
+		return 0, false
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// hasExhaustedBudget reports whether the service already ran for its whole daily budget, in which case it is not started
+func (service *Service) hasExhaustedBudget(client *client.Client) error {
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return nil
+	}
+	budget, err := parseBudget(labels)
+	if err != nil {
+		return err
+	}
+	if budget.Daily > 0 && service.runtimeToday(time.Now()) >= budget.Daily {
+		return fmt.Errorf("service %s has exhausted its daily budget of %v", service.name, budget.Daily)
+	}
+	return nil
+}
+
+// enforceBudget stops the service started at startedAt as soon as it exhausts its budget
+func (service *Service) enforceBudget(client *client.Client, startedAt time.Time) {
+	for service.startedAt.Equal(startedAt) && service.isRunning() {
+		labels, err := service.config(context.Background(), client)
+		if err != nil {
+			time.Sleep(budgetInterval)
+			continue
+		}
+		budget, err := parseBudget(labels)
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			return
+		}
+		remaining, capped := service.remaining(budget, time.Now())
+		if !capped {
+			return
+		}
+		if remaining <= 0 {
+			fmt.Printf("- Service %v exhausted its budget\n", service.name)
+			service.shutdown(client, "budget exhausted")
+			return
+		}
+		if remaining > budgetInterval {
+			remaining = budgetInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+type budgetResponse struct {
+	MaxRuntime   string `json:"maxRuntime,omitempty"`
+	DailyBudget  string `json:"dailyBudget,omitempty"`
+	RuntimeToday string `json:"runtimeToday"`
+	Remaining    string `json:"remaining,omitempty"`
+	Running      bool   `json:"running"`
+}
+
+func (service *Service) getBudget(client *client.Client) (budgetResponse, error) {
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return budgetResponse{}, err
+	}
+	budget, err := parseBudget(labels)
+	if err != nil {
+		return budgetResponse{}, err
+	}
+	now := time.Now()
+	response := budgetResponse{
+		RuntimeToday: service.runtimeToday(now).Round(time.Second).String(),
+		Running:      service.isRunning(),
+	}
+	if budget.MaxRuntime > 0 {
+		response.MaxRuntime = budget.MaxRuntime.String()
+	}
+	if budget.Daily > 0 {
+		response.DailyBudget = budget.Daily.String()
+	}
+	if remaining, capped := service.remaining(budget, now); capped {
+		response.Remaining = remaining.Round(time.Second).String()
+	}
+	return response, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Labels used on the docker service to skip its schedule on some days (e.g. holidays or maintenance windows)
+const (
+	// scheduleExceptionsLabel lists dates or ranges of dates, like "2024-12-25,2024-12-31..2025-01-01"
+	scheduleExceptionsLabel = "ondemand.schedule.exceptions"
+	// scheduleCalendarLabel is the URL of an iCal calendar, whose events are exceptions
+	scheduleCalendarLabel = "ondemand.schedule.calendar"
+)
+
+// calendarRefresh is how long a calendar is used before it is downloaded again
+const calendarRefresh = time.Hour
+
+// calendarTimeout bounds the download of a calendar
+const calendarTimeout = 10 * time.Second
+
+// exception is a period during which the windows of a schedule are skipped
+type exception struct {
+	start time.Time
+	end   time.Time
+}
+
+// calendar is a downloaded iCal calendar, parsed in the timezone of each schedule using it
+type calendar struct {
+	content   string
+	fetchedAt time.Time
+}
+
+var calendars = map[string]*calendar{}
+var calendarsMutex sync.Mutex
+
+// parseExceptionDay parses a date of the exceptions, in the timezone of the schedule
+func parseExceptionDay(day string, location *time.Location) (time.Time, error) {
+	parsed, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(day), location)
+	if err != nil {
+		return parsed, fmt.Errorf("%s should be dates (YYYY-MM-DD) or ranges of dates (YYYY-MM-DD..YYYY-MM-DD)", scheduleExceptionsLabel)
+	}
+	return parsed, nil
+}
+
+// parseExceptionDays parses the dates and ranges of dates separated by commas, each one being skipped all day long
+func parseExceptionDays(value string, location *time.Location) ([]exception, error) {
+	exceptions := []exception{}
+	for _, part := range strings.Split(value, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "..", 2)
+		first, err := parseExceptionDay(bounds[0], location)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseExceptionDay(bounds[1], location); err != nil {
+				return nil, err
+			}
+		}
+		exceptions = append(exceptions, exception{start: first, end: last.AddDate(0, 0, 1)})
+	}
+	return exceptions, nil
+}
+
+// parseCalendarTime parses the DTSTART or DTEND property of an event, a date being the beginning of the day, and
+// whether it is a date
+func parseCalendarTime(property string, location *time.Location) (time.Time, bool, error) {
+	separator := strings.Index(property, ":")
+	if separator < 0 {
+		return time.Time{}, false, fmt.Errorf("%q is not an iCal property", property)
+	}
+	value := property[separator+1:]
+	for _, parameter := range strings.Split(property[:separator], ";")[1:] {
+		if strings.HasPrefix(parameter, "TZID=") {
+			if tzid, err := time.LoadLocation(strings.Trim(strings.TrimPrefix(parameter, "TZID="), "\"")); err == nil {
+				location = tzid
+			}
+		}
+	}
+	switch {
+	case len(value) == len("20060102"):
+		parsed, err := time.ParseInLocation("20060102", value, location)
+		return parsed, true, err
+	case strings.HasSuffix(value, "Z"):
+		parsed, err := time.Parse("20060102T150405Z", value)
+		return parsed, false, err
+	}
+	parsed, err := time.ParseInLocation("20060102T150405", value, location)
+	return parsed, false, err
+}
+
+// parseCalendar returns the events of an iCal calendar as exceptions, an event without end lasting all the day it
+// starts. Their recurrences are not expanded
+func parseCalendar(content string, location *time.Location) ([]exception, error) {
+	// The long lines are folded, their continuations beginning with a space or a tab
+	content = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(content)
+	exceptions := []exception{}
+	var event *exception
+	allDay := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		name := strings.ToUpper(strings.SplitN(strings.SplitN(line, ":", 2)[0], ";", 2)[0])
+		var err error
+		switch {
+		case line == "BEGIN:VEVENT":
+			event = &exception{}
+		case line == "END:VEVENT" && event != nil:
+			if event.end.IsZero() && allDay {
+				event.end = event.start.AddDate(0, 0, 1)
+			}
+			if !event.start.IsZero() && event.end.After(event.start) {
+				exceptions = append(exceptions, *event)
+			}
+			event = nil
+		case name == "DTSTART" && event != nil:
+			event.start, allDay, err = parseCalendarTime(line, location)
+		case name == "DTEND" && event != nil:
+			event.end, _, err = parseCalendarTime(line, location)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exceptions, scanner.Err()
+}
+
+// fetchCalendar returns the content of the calendar at url, downloaded less than calendarRefresh ago, or the last
+// one downloaded when it cannot be downloaded again
+func fetchCalendar(url string, now time.Time) (string, error) {
+	calendarsMutex.Lock()
+	defer calendarsMutex.Unlock()
+	cached := calendars[url]
+	if cached != nil && now.Sub(cached.fetchedAt) < calendarRefresh {
+		return cached.content, nil
+	}
+	content, err := downloadCalendar(url)
+	if err != nil {
+		if cached != nil {
+			fmt.Printf("Error: could not refresh calendar %s, using the last one: %+v\n ", url, err)
+			cached.fetchedAt = now
+			return cached.content, nil
+		}
+		return "", err
+	}
+	calendars[url] = &calendar{content: content, fetchedAt: now}
+	return content, nil
+}
+
+func downloadCalendar(url string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), calendarTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %d", url, response.StatusCode)
+	}
+	content, err := ioutil.ReadAll(response.Body)
+	return string(content), err
+}
+
+// parseExceptions adds the exceptions of the labels to the schedule. A calendar that cannot be downloaded is
+// logged and skipped, for the schedule to keep working
+func (schedule *Schedule) parseExceptions(labels map[string]string, now time.Time) error {
+	if value, ok := labels[scheduleExceptionsLabel]; ok {
+		exceptions, err := parseExceptionDays(value, schedule.location)
+		if err != nil {
+			return err
+		}
+		schedule.exceptions = append(schedule.exceptions, exceptions...)
+	}
+	url, ok := labels[scheduleCalendarLabel]
+	if !ok {
+		return nil
+	}
+	content, err := fetchCalendar(url, now)
+	if err == nil {
+		var exceptions []exception
+		if exceptions, err = parseCalendar(content, schedule.location); err == nil {
+			schedule.exceptions = append(schedule.exceptions, exceptions...)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: calendar %s: %+v\n ", url, err)
+	}
+	return nil
+}
+
+// isException reports whether now is in one of the exceptions of the schedule
+func (schedule *Schedule) isException(now time.Time) bool {
+	for _, exception := range schedule.exceptions {
+		if !now.Before(exception.start) && now.Before(exception.end) {
+			return true
+		}
+	}
+	return false
+}
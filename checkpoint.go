@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const checkpointID = "ondemand"
+
+// checkpointSupported is true when checkpointing is enabled and the docker daemon runs in experimental mode
+var checkpointSupported = false
+
+func detectCheckpointSupport(client *client.Client) bool {
+	version, err := client.ServerVersion(context.Background())
+	if err != nil {
+		fmt.Printf("Checkpoint disabled, could not get docker version: %+v\n", err)
+		return false
+	}
+	if version.Os == "windows" {
+		fmt.Println("Checkpoint disabled, CRIU is not available for Windows containers")
+		return false
+	}
+	if !version.Experimental {
+		fmt.Println("Checkpoint disabled, the docker daemon is not running in experimental mode")
+		return false
+	}
+	fmt.Println("Checkpoint enabled")
+	return true
+}
+
+// checkpoint saves the running containers of the service to disk and stops them, then scales the service down
+// right away as swarm would otherwise replace the exited tasks per their restart policy
+func (service *Service) checkpoint(ctx context.Context, client *client.Client) error {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return err
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		return err
+	}
+	scaled := dockerService.Spec.Mode.Replicated != nil && *dockerService.Spec.Mode.Replicated.Replicas != zeroReplica
+	containerIDs = append(containerIDs, service.restored...)
+	service.restored = nil
+	for _, containerID := range containerIDs {
+		// A previous checkpoint would prevent creating a new one with the same ID
+		containerClient(client, containerID).CheckpointDelete(ctx, containerID, types.CheckpointDeleteOptions{CheckpointID: checkpointID})
+		err := containerClient(client, containerID).CheckpointCreate(ctx, containerID, types.CheckpointCreateOptions{
+			CheckpointID: checkpointID,
+			Exit:         true,
+		})
+		if err != nil {
+			return err
+		}
+		service.checkpointed = append(service.checkpointed, containerID)
+	}
+	if scaled {
+		return service.setServiceReplicas(client, 0)
+	}
+	return nil
+}
+
+// restore starts the checkpointed containers of the service from their checkpoint, outside of swarm which would
+// otherwise start new tasks next to them. The service is scaled back up when they cannot be restored
+func (service *Service) restore(ctx context.Context, client *client.Client) error {
+	for len(service.checkpointed) > 0 {
+		containerID := service.checkpointed[0]
+		err := containerClient(client, containerID).ContainerStart(ctx, containerID, types.ContainerStartOptions{CheckpointID: checkpointID})
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			service.checkpointed = nil
+			return service.setServiceReplicas(client, 1)
+		}
+		service.checkpointed = service.checkpointed[1:]
+		service.restored = append(service.restored, containerID)
+	}
+	return nil
+}
+
+// getRestoredContainers returns the restored containers that are running, which are unknown to swarm
+func (service *Service) getRestoredContainers(ctx context.Context, client *client.Client) ([]string, error) {
+	running := []string{}
+	for _, containerID := range service.restored {
+		container, err := containerClient(client, containerID).ContainerInspect(ctx, containerID)
+		if err != nil {
+			return nil, err
+		}
+		if container.State.Running {
+			running = append(running, containerID)
+		}
+	}
+	return running, nil
+}
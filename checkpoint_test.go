@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckpointScalesDown(t *testing.T) {
+	docker := newMockDocker(map[string]*MockService{"web": {Replicas: 1}})
+	client, err := docker.serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &Service{name: "web", strategy: CHECKPOINT}
+	ctx := context.Background()
+
+	if err := service.checkpoint(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if len(service.checkpointed) != 1 {
+		t.Fatalf("expected the container to be checkpointed, got %v", service.checkpointed)
+	}
+	// The checkpointed container exited: swarm would replace its task if the service kept its replica
+	mock := docker.services["mock0"]
+	if replicas := *mock.service.Spec.Mode.Replicated.Replicas; replicas != 0 || len(mock.slots) != 0 {
+		t.Fatalf("expected the service to be scaled down, got %d replicas and %d tasks", replicas, len(mock.slots))
+	}
+	status, err := service.readDockerStatus(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != DOWN {
+		t.Errorf("expected the checkpointed service to be down, got %s", status)
+	}
+
+	// A service already scaled down is not updated again
+	updates := docker.calls["POST /services"]
+	service.checkpointed = nil
+	if err := service.checkpoint(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if docker.calls["POST /services"] != updates {
+		t.Errorf("expected no update of the scaled down service")
+	}
+}
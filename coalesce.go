@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+func init() {
+	metrics.Register("ondemand_coalesced_requests_total", "counter", "Number of wake requests answered with the result of another one")
+}
+
+// coalescedResult is the result of handling the state of a service for a request, shared with the concurrent
+// and following requests of the service
+type coalescedResult struct {
+	response string
+	err      error
+	at       time.Time
+	done     chan struct{}
+}
+
+// handleCoalesced handles the state of the service like HandleServiceState, at most once per --coalesce interval:
+// the requests arriving while it is handled get the same result, and those arriving shortly after a started response
+// get it too, without looking the service up nor resetting its timeout again
+func (service *Service) handleCoalesced(ctx context.Context, cli *client.Client) (string, error) {
+	if coalesceInterval.Get() <= 0 {
+		return service.HandleServiceState(ctx, cli)
+	}
+	service.coalesceMutex.Lock()
+	if result := service.coalesced; result != nil {
+		select {
+		case <-result.done:
+			// Only the started responses are reused, the others needing the service to be handled again
+			if result.err == nil && result.response == "started" && time.Since(result.at) < coalesceInterval.Get() {
+				service.coalesceMutex.Unlock()
+				metrics.Add("ondemand_coalesced_requests_total", 1, "service", service.name)
+				return result.response, nil
+			}
+		default:
+			service.coalesceMutex.Unlock()
+			select {
+			case <-result.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if errors.Is(result.err, context.Canceled) {
+				// The request that handled the service went away, which says nothing about this one
+				return service.HandleServiceState(ctx, cli)
+			}
+			metrics.Add("ondemand_coalesced_requests_total", 1, "service", service.name)
+			return result.response, result.err
+		}
+	}
+	result := &coalescedResult{done: make(chan struct{})}
+	service.coalesced = result
+	service.coalesceMutex.Unlock()
+	defer close(result.done)
+	result.response, result.err = service.HandleServiceState(ctx, cli)
+	result.at = time.Now()
+	return result.response, result.err
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"githuc.com/acouvreur/traefik-ondemand-plugin/apiclient"
+)
+
+// commandOptions are the flags of the subcommands
+type commandOptions struct {
+	timeout uint64
+	tail    string
+	follow  bool
+}
+
+// commands are the subcommands talking to the API of a running instance
+var commands = map[string]func(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error{
+	"status":  statusCommand,
+	"start":   startCommand,
+	"stop":    stopCommand,
+	"restart": restartCommand,
+	"logs":    logsCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+}
+
+const commandsUsage = `Usage:
+  ondemand status [name]         Show the status of the services, or of one service
+  ondemand start <name>          Wake a service up
+  ondemand stop <name>           Stop a service without waiting for its timeout
+  ondemand restart <name>        Replace the containers of a service and wait until it is started again
+  ondemand logs <name>           Show the last lines of the logs of a service, and the next ones with --follow
+  ondemand export                Write the state of the scaler as JSON to the standard output
+  ondemand import <file>         Restore the state of the scaler from an export, - for the standard input
+
+Flags:
+`
+
+// runCommand runs the subcommand of args, reporting whether args is a subcommand
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+	defaultURL := os.Getenv("ONDEMAND_URL")
+	if defaultURL == "" {
+		defaultURL = "http://localhost:10000"
+	}
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	url := flags.String("url", defaultURL, "URL of the running instance (or ONDEMAND_URL)")
+	token := flags.String("token", os.Getenv("ONDEMAND_TOKEN"), "Bearer token of the API (or ONDEMAND_TOKEN)")
+	timeout := flags.Uint64("timeout", 0, "Timeout in seconds of the started service, the registered or last one by default")
+	tail := flags.String("tail", defaultLogsTail, "Number of lines of logs to show")
+	follow := flags.Bool("follow", false, "Keep streaming the logs")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), commandsUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+	apiClient := apiclient.New(*url)
+	apiClient.Token = *token
+	if err := command(apiClient, flags, commandOptions{timeout: *timeout, tail: *tail, follow: *follow}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func requireName(flags *flag.FlagSet) (string, error) {
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return "", fmt.Errorf("a service name is required")
+	}
+	return flags.Arg(0), nil
+}
+
+func statusCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	if flags.NArg() == 1 {
+		status, err := ondemand.GetStatus(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Println(status.Status)
+		return nil
+	}
+	snapshots, err := ondemand.ListStatus()
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tSTATUS\tIDLE IN\tSESSIONS\tLAST REQUEST")
+	for _, snapshot := range snapshots {
+		idle := ""
+		if snapshot.Pinned {
+			idle = "pinned"
+		} else if snapshot.Remaining > 0 {
+			idle = (time.Duration(snapshot.Remaining) * time.Second).String()
+		}
+		lastRequest := ""
+		if !snapshot.LastRequestAt.IsZero() {
+			lastRequest = snapshot.LastRequestAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\n", snapshot.Name, snapshot.Status, idle, snapshot.Sessions, lastRequest)
+	}
+	return writer.Flush()
+}
+
+func startCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	name, err := requireName(flags)
+	if err != nil {
+		return err
+	}
+	control, err := ondemand.Start(name, options.timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Println(control.Response)
+	return nil
+}
+
+func stopCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	name, err := requireName(flags)
+	if err != nil {
+		return err
+	}
+	control, err := ondemand.Stop(name)
+	if err != nil {
+		return err
+	}
+	fmt.Println(control.Response)
+	return nil
+}
+
+func restartCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	name, err := requireName(flags)
+	if err != nil {
+		return err
+	}
+	control, err := ondemand.Restart(name, options.timeout, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Println(control.Response)
+	return nil
+}
+
+func logsCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	name, err := requireName(flags)
+	if err != nil {
+		return err
+	}
+	logs, err := ondemand.Logs(name, options.tail, options.follow)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	_, err = io.Copy(os.Stdout, logs)
+	return err
+}
+
+func exportCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	export, err := ondemand.ExportState()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(export))
+	return err
+}
+
+func importCommand(ondemand *apiclient.Client, flags *flag.FlagSet, options commandOptions) error {
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("an export file is required")
+	}
+	var export []byte
+	var err error
+	if flags.Arg(0) == "-" {
+		export, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		export, err = ioutil.ReadFile(flags.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	result, err := ondemand.ImportState(export)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d services, %d registrations and the statistics of %d services\n", result.Services, result.Registrations, result.Stats)
+	return nil
+}
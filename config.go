@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// runtimeFlags are the flags the config API can change without a restart, the scaler reading them each time it uses
+// them through their Get method, with the checks of their values beyond their type
+var runtimeFlags = map[string]func(value string) error{
+	"docker-timeout":     nil,
+	"disable-pull":       nil,
+	"dry-run":            nil,
+	"max-running":        nil,
+	"max-starting":       nil,
+	"max-load":           nil,
+	"min-free-memory":    validateMemory,
+	"on-exhausted":       validateOnExhausted,
+	"cost-per-hour":      nil,
+	"coalesce":           nil,
+	"status-cache":       nil,
+	"profile":            validateProfile,
+	"gc-after":           nil,
+	"max-services":       nil,
+	"sidecar-delay":      nil,
+	"hmac-max-skew":      nil,
+	"idempotency-window": nil,
+	"shutdown-warning":   nil,
+}
+
+// configMutex serializes the changes of the runtime flags
+var configMutex sync.Mutex
+
+// runtimeMutex guards the values of the runtime flags, read while the config API changes them
+var runtimeMutex sync.RWMutex
+
+// RuntimeString is a string flag that can be changed at runtime
+type RuntimeString struct{ value string }
+
+func runtimeString(name string, value string, usage string) *RuntimeString {
+	runtimeFlag := &RuntimeString{value: value}
+	flag.Var(runtimeFlag, name, usage)
+	return runtimeFlag
+}
+
+func (runtimeFlag *RuntimeString) Get() string {
+	runtimeMutex.RLock()
+	defer runtimeMutex.RUnlock()
+	return runtimeFlag.value
+}
+
+func (runtimeFlag *RuntimeString) Set(value string) error {
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+	runtimeFlag.value = value
+	return nil
+}
+
+func (runtimeFlag *RuntimeString) String() string {
+	return runtimeFlag.Get()
+}
+
+// RuntimeBool is a boolean flag that can be changed at runtime
+type RuntimeBool struct{ value bool }
+
+func runtimeBool(name string, value bool, usage string) *RuntimeBool {
+	runtimeFlag := &RuntimeBool{value: value}
+	flag.Var(runtimeFlag, name, usage)
+	return runtimeFlag
+}
+
+func (runtimeFlag *RuntimeBool) Get() bool {
+	runtimeMutex.RLock()
+	defer runtimeMutex.RUnlock()
+	return runtimeFlag.value
+}
+
+func (runtimeFlag *RuntimeBool) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+	runtimeFlag.value = parsed
+	return nil
+}
+
+func (runtimeFlag *RuntimeBool) String() string {
+	return strconv.FormatBool(runtimeFlag.Get())
+}
+
+// IsBoolFlag lets the flag be set without a value, like the flags of flag.Bool
+func (runtimeFlag *RuntimeBool) IsBoolFlag() bool {
+	return true
+}
+
+// RuntimeInt is an integer flag that can be changed at runtime
+type RuntimeInt struct{ value int }
+
+func runtimeInt(name string, value int, usage string) *RuntimeInt {
+	runtimeFlag := &RuntimeInt{value: value}
+	flag.Var(runtimeFlag, name, usage)
+	return runtimeFlag
+}
+
+func (runtimeFlag *RuntimeInt) Get() int {
+	runtimeMutex.RLock()
+	defer runtimeMutex.RUnlock()
+	return runtimeFlag.value
+}
+
+func (runtimeFlag *RuntimeInt) Set(value string) error {
+	parsed, err := strconv.ParseInt(value, 0, strconv.IntSize)
+	if err != nil {
+		return err
+	}
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+	runtimeFlag.value = int(parsed)
+	return nil
+}
+
+func (runtimeFlag *RuntimeInt) String() string {
+	return strconv.Itoa(runtimeFlag.Get())
+}
+
+// RuntimeFloat is a float flag that can be changed at runtime
+type RuntimeFloat struct{ value float64 }
+
+func runtimeFloat(name string, value float64, usage string) *RuntimeFloat {
+	runtimeFlag := &RuntimeFloat{value: value}
+	flag.Var(runtimeFlag, name, usage)
+	return runtimeFlag
+}
+
+func (runtimeFlag *RuntimeFloat) Get() float64 {
+	runtimeMutex.RLock()
+	defer runtimeMutex.RUnlock()
+	return runtimeFlag.value
+}
+
+func (runtimeFlag *RuntimeFloat) Set(value string) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+	runtimeFlag.value = parsed
+	return nil
+}
+
+func (runtimeFlag *RuntimeFloat) String() string {
+	return strconv.FormatFloat(runtimeFlag.Get(), 'g', -1, 64)
+}
+
+// RuntimeDuration is a duration flag that can be changed at runtime
+type RuntimeDuration struct{ value time.Duration }
+
+func runtimeDuration(name string, value time.Duration, usage string) *RuntimeDuration {
+	runtimeFlag := &RuntimeDuration{value: value}
+	flag.Var(runtimeFlag, name, usage)
+	return runtimeFlag
+}
+
+func (runtimeFlag *RuntimeDuration) Get() time.Duration {
+	runtimeMutex.RLock()
+	defer runtimeMutex.RUnlock()
+	return runtimeFlag.value
+}
+
+func (runtimeFlag *RuntimeDuration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+	runtimeFlag.value = parsed
+	return nil
+}
+
+func (runtimeFlag *RuntimeDuration) String() string {
+	return runtimeFlag.Get().String()
+}
+
+func validateMemory(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, err := units.RAMInBytes(value)
+	return err
+}
+
+func validateOnExhausted(value string) error {
+	if value != REJECT && value != QUEUE && value != EVICT {
+		return fmt.Errorf("should be one of %s, %s, %s", REJECT, QUEUE, EVICT)
+	}
+	return nil
+}
+
+func validateProfile(value string) error {
+	if _, ok := profiles[value]; !ok {
+		return fmt.Errorf("%s is not a response profile", value)
+	}
+	return nil
+}
+
+// runtimeConfig returns the values of the runtime flags
+func runtimeConfig() map[string]string {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config := map[string]string{}
+	for name := range runtimeFlags {
+		config[name] = flag.Lookup(name).Value.String()
+	}
+	return config
+}
+
+// setRuntimeFlags changes the runtime flags, none of them when one of the values is not valid
+func setRuntimeFlags(changes map[string]string) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	names := []string{}
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	previous := map[string]string{}
+	for _, name := range names {
+		validate, ok := runtimeFlags[name]
+		if !ok {
+			return fmt.Errorf("--%s cannot be changed at runtime", name)
+		}
+		if validate != nil {
+			if err := validate(changes[name]); err != nil {
+				return fmt.Errorf("--%s: %v", name, err)
+			}
+		}
+		previous[name] = flag.Lookup(name).Value.String()
+	}
+	for _, name := range names {
+		if err := flag.Set(name, changes[name]); err != nil {
+			for restored, value := range previous {
+				flag.Set(restored, value)
+			}
+			return fmt.Errorf("--%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// loadConfig applies the runtime flags changed through the API before a restart, which take precedence over the
+// command line
+func loadConfig() error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	changes := map[string]string{}
+	for name, value := range state.Config {
+		if _, ok := runtimeFlags[name]; !ok {
+			// e.g. notify-url, which can no longer be changed at runtime
+			fmt.Printf("Config --%s=%s changed through the API is ignored: it cannot be changed at runtime\n", name, value)
+			continue
+		}
+		fmt.Printf("Config --%s=%s changed through the API\n", name, value)
+		changes[name] = value
+	}
+	return setRuntimeFlags(changes)
+}
+
+// handleConfigAPI serves GET and PATCH /api/config, whose changes are persisted
+func handleConfigAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, runtimeConfig())
+	case http.MethodPatch:
+		if requestNamespace(r) != "" {
+			// The flags apply to the services of all the namespaces
+			writeError(w, http.StatusForbidden, fmt.Errorf("the config cannot be changed with a token bound to a namespace"))
+			return
+		}
+		changes := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid config: %v", err))
+			return
+		}
+		if err := setRuntimeFlags(changes); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		err := store.Update(func(state *State) {
+			for name, value := range changes {
+				state.Config[name] = value
+			}
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for name, value := range changes {
+			audit("", "config", fmt.Sprintf("--%s=%s", name, value), requestID(r))
+		}
+		writeJSON(w, http.StatusOK, runtimeConfig())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+// ServiceConfig is the configuration of a service that can be changed at runtime, persisted in its registration
+type ServiceConfig struct {
+	Timeout uint64 `json:"timeout,omitempty"`
+	// Labels configure the service like the ondemand.* labels of the docker service (probes, strategy...)
+	Labels map[string]string `json:"labels,omitempty"`
+	Pinned bool              `json:"pinned"`
+}
+
+// serviceConfigPatch changes the fields it sets, the labels with an empty value being removed
+type serviceConfigPatch struct {
+	Timeout *uint64           `json:"timeout"`
+	Labels  map[string]string `json:"labels"`
+	Pinned  *bool             `json:"pinned"`
+}
+
+func getServiceConfig(name string) ServiceConfig {
+	config := ServiceConfig{Labels: map[string]string{}}
+	if registration := getRegistration(name); registration != nil {
+		config.Timeout = registration.Timeout
+		for key, value := range registration.Labels {
+			config.Labels[key] = value
+		}
+		config.Pinned = registration.Pinned
+	}
+	if service := getService(name); service != nil {
+		config.Pinned = service.pinned
+	}
+	return config
+}
+
+// apply returns the registration of the service changed by the patch, a new one when it is not registered
+func (patch *serviceConfigPatch) apply(name string) (*Registration, error) {
+	registration := &Registration{Name: name, Labels: map[string]string{}}
+	if existing := getRegistration(name); existing != nil {
+		copied := *existing
+		registration = &copied
+		registration.Labels = map[string]string{}
+		for key, value := range existing.Labels {
+			registration.Labels[key] = value
+		}
+	}
+	if patch.Timeout != nil {
+		registration.Timeout = *patch.Timeout
+	}
+	for key, value := range patch.Labels {
+		if value == "" {
+			delete(registration.Labels, key)
+		} else {
+			registration.Labels[key] = value
+		}
+	}
+	if patch.Pinned != nil {
+		registration.Pinned = *patch.Pinned
+	}
+	if err := registration.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := parseReadinessProbe(registration.Labels); err != nil {
+		return nil, err
+	}
+	if _, err := parseStrategy(registration.Labels); err != nil {
+		return nil, err
+	}
+	if _, err := parseExternalPolicy(registration.Labels); err != nil {
+		return nil, err
+	}
+	if _, _, err := snapshotConfig(name, registration.Labels); err != nil {
+		return nil, err
+	}
+	if _, err := parseQuietHours(registration.Labels); err != nil {
+		return nil, err
+	}
+	if _, _, err := autoscaleConfig(registration.Labels); err != nil {
+		return nil, err
+	}
+	return registration, nil
+}
+
+// update applies the patch to the service when the scaler tracks it, or pins it
+func (patch *serviceConfigPatch) update(name string, registration *Registration) {
+	service := getService(name)
+	if service == nil && patch.Pinned != nil && *patch.Pinned {
+		service = GetOrCreateService(name, registration.Timeout)
+	}
+	// The labels are read again with the status of the service
+	invalidateStatus(name)
+	if service == nil {
+		return
+	}
+	if patch.Pinned != nil {
+		service.pinned = *patch.Pinned
+	}
+	if patch.Timeout != nil && *patch.Timeout > 0 {
+		service.timeout = *patch.Timeout
+	}
+	if len(patch.Labels) > 0 {
+		// The readiness probe may have changed
+		service.readyContainer = ""
+	}
+}
+
+// handleServiceConfigAPI serves GET and PATCH /api/services/{name}/config
+func handleServiceConfigAPI(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, getServiceConfig(name))
+	case http.MethodPatch:
+		patch := &serviceConfigPatch{}
+		if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid config: %v", err))
+			return
+		}
+		registration, err := patch.apply(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := register(registration); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		patch.update(name, registration)
+		content, _ := json.Marshal(patch)
+		audit(name, "config", string(content), requestID(r))
+		writeJSON(w, http.StatusOK, getServiceConfig(name))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// serviceIDLabel is set by swarm on the containers of a task to the ID of its service
+const serviceIDLabel = "com.docker.swarm.service.id"
+
+// containerIDRegexp matches the full and short IDs of the containers
+var containerIDRegexp = regexp.MustCompile(`^([0-9a-f]{12}|[0-9a-f]{64})$`)
+
+// findContainerService returns the service of the container whose ID, or short ID, is name, unambiguous when
+// several services have similar names, nil when there is no such container
+func findContainerService(ctx context.Context, client *client.Client, services []swarm.Service, name string) *swarm.Service {
+	if !containerIDRegexp.MatchString(name) {
+		return nil
+	}
+	container, err := containerClient(client, name).ContainerInspect(ctx, name)
+	if err != nil || container.Config == nil {
+		return nil
+	}
+	serviceID := container.Config.Labels[serviceIDLabel]
+	for i := range services {
+		if services[i].ID == serviceID {
+			return &services[i]
+		}
+	}
+	return nil
+}
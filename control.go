@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+type controlResponse struct {
+	Name string `json:"name"`
+	// Response is started, starting or exhausted for a start, stopped for a stop
+	Response string `json:"response,omitempty"`
+	Pinned   bool   `json:"pinned"`
+}
+
+// requestedTimeout returns the timeout query parameter, or the timeout of the registration or of the known service
+func requestedTimeout(r *http.Request, name string) (uint64, error) {
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		timeout, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("timeout should be an integer")
+		}
+		return timeout, nil
+	}
+	if registration := getRegistration(name); registration != nil && registration.Timeout > 0 {
+		return registration.Timeout, nil
+	}
+	if service := getService(name); service != nil {
+		return service.timeout, nil
+	}
+	return 0, fmt.Errorf("timeout is required")
+}
+
+// handleStartAPI serves POST /api/services/{name}/start, which wakes the service up like a request
+func handleStartAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	timeout, err := requestedTimeout(r, name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	service := GetOrCreateService(name, timeout)
+	service.lastRequestAt = time.Now()
+	service.requestID = requestID(r)
+	response, err := service.HandleServiceState(r.Context(), cli)
+	if _, asleep := err.(*AsleepError); asleep {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logRequest(r, name, response)
+	if response == "starting" {
+		setAcceptedHeaders(w, service)
+		writeJSON(w, http.StatusAccepted, controlResponse{name, response, service.pinned})
+		return
+	}
+	writeJSON(w, http.StatusOK, controlResponse{name, response, service.pinned})
+}
+
+// handleStopAPI serves POST /api/services/{name}/stop, which stops the service without waiting for its timeout
+func handleStopAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	service := getService(name)
+	if service == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s has not been requested yet", name))
+		return
+	}
+	status, err := service.getStatus(r.Context(), cli)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	service.pinned = false
+	if err := persistPin(name, false); err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+	if status == DOWN {
+		writeJSON(w, http.StatusOK, controlResponse{name, "stopped", false})
+		return
+	}
+	service.requestID = requestID(r)
+	service.shutdown(cli, "stopped through the API")
+	logRequest(r, name, "stopped")
+	writeJSON(w, http.StatusOK, controlResponse{name, "stopped", false})
+}
+
+// scaleDown removes the containers of the service whatever its strategy, for them to be created again on wake-up
+func (service *Service) scaleDown(client *client.Client) error {
+	service.checkpointed = nil
+	service.restored = nil
+	return service.setServiceReplicas(client, 0)
+}
+
+// handleRestartAPI serves POST /api/services/{name}/restart, which replaces the containers of the service
+// and waits until it is started again (wait query parameter, --proxy-wait by default)
+func handleRestartAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	if isPattern(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("a pattern cannot be restarted"))
+		return
+	}
+	timeout, err := requestedTimeout(r, name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	wait := *proxyWait
+	if value := r.URL.Query().Get("wait"); value != "" {
+		if wait, err = time.ParseDuration(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("wait should be a duration (e.g. 30s)"))
+			return
+		}
+	}
+	service := GetOrCreateService(name, timeout)
+	status, err := service.getStatus(r.Context(), cli)
+	if _, notFound := err.(*NotFoundError); notFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	service.lastRequestAt = time.Now()
+	service.requestID = requestID(r)
+	if status != DOWN {
+		audit(name, "restart", "", service.requestID)
+		service.shutdownWith(cli, "restarted through the API", service.scaleDown)
+	}
+	if err := service.waitUntilStarted(r.Context(), cli, wait); err != nil {
+		// A service still starting did not get ready in time
+		if service.machine.State() == STARTING {
+			writeError(w, http.StatusGatewayTimeout, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logRequest(r, name, "started")
+	writeJSON(w, http.StatusOK, controlResponse{name, "started", service.pinned})
+}
+
+// handlePinAPI serves PUT and DELETE /api/services/{name}/pin: a pinned service is kept up until it is unpinned
+func handlePinAPI(w http.ResponseWriter, r *http.Request, name string) {
+	timeout, err := requestedTimeout(r, name)
+	if err != nil && r.Method == http.MethodPut {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		service := GetOrCreateService(name, timeout)
+		service.pinned = true
+		audit(name, "pin", "", requestID(r))
+		if err := persistPin(name, true); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		writeJSON(w, http.StatusOK, controlResponse{Name: name, Pinned: true})
+	case http.MethodDelete:
+		if service := getService(name); service != nil && service.pinned {
+			service.pinned = false
+			audit(name, "unpin", "", requestID(r))
+		}
+		if err := persistPin(name, false); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		writeJSON(w, http.StatusOK, controlResponse{Name: name, Pinned: false})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers a browser can send cross-origin
+var corsAllowedHeaders = strings.Join([]string{"Authorization", "Content-Type", requestIDHeader, idempotencyKeyHeader, profileHeader, timestampHeader, signatureHeader}, ", ")
+
+// corsExposedHeaders are the response headers the scripts of another origin can read
+var corsExposedHeaders = strings.Join([]string{"Retry-After", "X-Queue-Position", requestIDHeader}, ", ")
+
+// CORS holds the origins allowed to call the API from a browser, and the methods they can use
+type CORS struct {
+	origins map[string]bool
+	any     bool
+	methods string
+}
+
+// corsPolicy is the CORS policy of the API, nil when no origin is allowed
+var corsPolicy *CORS
+
+// newCORS returns the policy of comma separated origins (* for any) and methods
+func newCORS(origins string, methods string) *CORS {
+	policy := &CORS{origins: map[string]bool{}}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			policy.any = true
+		} else if origin != "" {
+			policy.origins[origin] = true
+		}
+	}
+	if !policy.any && len(policy.origins) == 0 {
+		return nil
+	}
+	allowed := []string{}
+	for _, method := range strings.Split(methods, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	policy.methods = strings.Join(allowed, ", ")
+	return policy
+}
+
+func (policy *CORS) allows(origin string) bool {
+	return policy.any || policy.origins[origin]
+}
+
+// allowCORS adds the CORS headers to the responses to the allowed origins, and answers their preflight requests
+// before they reach the authentication, which browsers do not send on preflights
+func allowCORS(policy *CORS, handler http.Handler) http.Handler {
+	if policy == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !policy.allows(origin) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", policy.methods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
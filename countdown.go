@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// keepAliveLabel is used on the docker service to let its active browser tabs delay its stop
+const keepAliveLabel = "ondemand.keepalive"
+
+// ShutdownNotice tells the plugin or the application when the service is stopped, for a countdown banner
+type ShutdownNotice struct {
+	Name  string `json:"name"`
+	State Status `json:"state"`
+	// ShutdownAt is when the service is stopped unless it is requested again, ShutdownIn the seconds left until then
+	ShutdownAt *time.Time `json:"shutdownAt,omitempty"`
+	ShutdownIn *int64     `json:"shutdownIn,omitempty"`
+	// Warning reports whether the stop is less than --shutdown-warning away, the banner being shown then
+	Warning bool   `json:"warning"`
+	Message string `json:"message,omitempty"`
+	// KeepAlive reports whether the active tabs can delay the stop
+	KeepAlive bool `json:"keepAlive"`
+}
+
+// shutdownDeadline returns when the service is stopped if it is not requested again, zero when it is not stopped
+// when idle. A request received while it waits for its deadline pushes it back by its timeout
+func (service *Service) shutdownDeadline() time.Time {
+	if !service.isHandled || service.pinned || service.idleDeadline.IsZero() {
+		return time.Time{}
+	}
+	if len(service.time) > 0 {
+		return service.idleDeadline.Add(time.Duration(service.effectiveTimeout()) * time.Second)
+	}
+	return service.idleDeadline
+}
+
+// shutdownNotice returns the shutdown notice of the service at now
+func (service *Service) shutdownNotice(now time.Time, keepAlive bool) ShutdownNotice {
+	notice := ShutdownNotice{Name: service.name, State: service.machine.State(), KeepAlive: keepAlive}
+	deadline := service.shutdownDeadline()
+	if drain := service.drainStatus(); drain.Deadline != nil {
+		// The service is stopped at the latest when its drain times out
+		deadline = *drain.Deadline
+	}
+	if (notice.State != UP && notice.State != STARTING && notice.State != DRAINING) || deadline.IsZero() {
+		return notice
+	}
+	remaining := int64(deadline.Sub(now).Seconds())
+	if remaining < 0 {
+		// Its stop is deferred (e.g. by its sessions or its open connections), and checked again shortly
+		remaining = 0
+	}
+	notice.ShutdownAt = timePointer(deadline)
+	notice.ShutdownIn = &remaining
+	notice.Warning = deadline.Sub(now) <= shutdownWarning.Get()
+	if notice.Warning {
+		notice.Message = fmt.Sprintf("shutting down in %ds", remaining)
+	}
+	return notice
+}
+
+// keepAlive resets the timeout of the service on behalf of an active browser tab, like a wake request
+func (service *Service) keepAlive() {
+	service.lastRequestAt = time.Now()
+	select {
+	case service.time <- service.effectiveTimeout():
+	default:
+	}
+	fmt.Printf("- Service %v is kept alive by an active tab\n", service.name)
+}
+
+// handleShutdownAPI serves GET /api/services/{name}/shutdown, polled to show a countdown banner before the stop, and
+// POST, with which an active tab delays the stop of a service with the ondemand.keepalive label
+func handleShutdownAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	service := getService(name)
+	if service == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s has not been requested yet", name))
+		return
+	}
+	labels, err := service.config(r.Context(), cli)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	keepAlive := labels[keepAliveLabel] == "true"
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !keepAlive {
+			writeError(w, http.StatusForbidden, fmt.Errorf("service %s does not have the %s label", name, keepAliveLabel))
+			return
+		}
+		if state := service.machine.State(); state != UP && state != STARTING {
+			writeError(w, http.StatusConflict, fmt.Errorf("service %s is %s", name, state))
+			return
+		}
+		service.keepAlive()
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	writeJSON(w, http.StatusOK, service.shutdownNotice(time.Now(), keepAlive))
+}
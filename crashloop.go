@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// crashLoopExits is the number of task exits since the start of a service that makes it a crash loop
+const crashLoopExits = 3
+
+// Delays before a crash looping service can be started again, doubled after each crash loop
+const (
+	minCrashBackoff = 30 * time.Second
+	maxCrashBackoff = 30 * time.Minute
+)
+
+// countExits returns the number of tasks of the service that exited since it was started
+func (service *Service) countExits(ctx context.Context, client *client.Client) (int, error) {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("service", dockerService.ID)
+	tasks, err := client.TaskList(ctx, types.TaskListOptions{Filters: filterArgs})
+	if err != nil {
+		return 0, err
+	}
+	exits := 0
+	for _, task := range tasks {
+		switch task.Status.State {
+		case swarm.TaskStateFailed, swarm.TaskStateRejected, swarm.TaskStateComplete:
+			if task.Status.Timestamp.After(service.startedAt) {
+				exits++
+			}
+		}
+	}
+	return exits, nil
+}
+
+// isCrashLooping reports whether the tasks of the starting service keep exiting, in which case the service is
+// put down and marked as failed, and can only be started again after a backoff delay
+func (service *Service) isCrashLooping(ctx context.Context, client *client.Client) bool {
+	if service.members != nil || service.startedAt.IsZero() {
+		return false
+	}
+	exits, err := service.countExits(ctx, client)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	if exits < crashLoopExits {
+		return false
+	}
+	if service.crashBackoff == 0 {
+		service.crashBackoff = minCrashBackoff
+	} else if service.crashBackoff *= 2; service.crashBackoff > maxCrashBackoff {
+		service.crashBackoff = maxCrashBackoff
+	}
+	service.backoffUntil = time.Now().Add(service.crashBackoff)
+	reason := fmt.Sprintf("crash loop: %d exits since started, next start in %s", exits, service.crashBackoff)
+	fmt.Printf("- Service %v is crash looping\n", service.name)
+	service.shutdown(client, reason)
+	service.transition(FAILED, reason)
+	notify("crashloop", service, reason)
+	return true
+}
+
+// isBackingOff returns an error while a crash looping service cannot be started again
+func (service *Service) isBackingOff() error {
+	if remaining := time.Until(service.backoffUntil); remaining > 0 {
+		return fmt.Errorf("Service %s is crash looping, next start in %s", service.name, remaining.Round(time.Second))
+	}
+	return nil
+}
+
+// resetBackoff forgets the crash loops of a service that started successfully
+func (service *Service) resetBackoff() {
+	service.crashBackoff = 0
+	service.backoffUntil = time.Time{}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// eventsInterval is the delay between two snapshots sent on the events stream
+const eventsInterval = 5 * time.Second
+
+// serviceSnapshot is the live state of a service, as shown on the dashboard
+type serviceSnapshot struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// State is the state of the service as managed by the scaler
+	State   Status `json:"state"`
+	Error   string `json:"error,omitempty"`
+	Timeout uint64 `json:"timeout"`
+	// Remaining is the idle time in seconds before the service is stopped, when it is handled by the scaler
+	Remaining     int64     `json:"remaining,omitempty"`
+	Pinned        bool      `json:"pinned"`
+	Sessions      int       `json:"sessions"`
+	StartedAt     time.Time `json:"startedAt,omitempty"`
+	StoppedAt     time.Time `json:"stoppedAt,omitempty"`
+	LastRequestAt time.Time `json:"lastRequestAt,omitempty"`
+}
+
+// snapshotServices returns the live state of the requested and of the registered services of the namespace
+func snapshotServices(ctx context.Context, cli *client.Client, namespace string) []serviceSnapshot {
+	servicesMutex.Lock()
+	known := map[string]*Service{}
+	for name, service := range services {
+		if inNamespace(namespace, name) {
+			known[name] = service
+		}
+	}
+	servicesMutex.Unlock()
+	registryMutex.RLock()
+	for name, registration := range registrations {
+		if known[name] == nil && inNamespace(namespace, name) {
+			known[name] = &Service{name: name, timeout: registration.Timeout}
+		}
+	}
+	registryMutex.RUnlock()
+
+	snapshots := []serviceSnapshot{}
+	now := time.Now()
+	for _, service := range known {
+		snapshot := serviceSnapshot{
+			Name:          service.name,
+			Timeout:       service.timeout,
+			Pinned:        service.pinned,
+			Sessions:      service.countSessions(),
+			StartedAt:     service.startedAt,
+			StoppedAt:     service.stoppedAt,
+			LastRequestAt: service.lastRequestAt,
+		}
+		status, err := service.getStatus(ctx, cli)
+		if err != nil {
+			snapshot.Status = UNKNOWN
+			snapshot.Error = err.Error()
+		} else {
+			snapshot.Status = status
+		}
+		snapshot.State = service.machine.State()
+		if status != DOWN && service.isHandled && service.idleDeadline.After(now) {
+			snapshot.Remaining = int64(service.idleDeadline.Sub(now).Seconds())
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	return snapshots
+}
+
+// handleEventsAPI serves GET /api/events, a server-sent events stream of the live state of the services
+func handleEventsAPI(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		ticker := time.NewTicker(eventsInterval)
+		defer ticker.Stop()
+		for {
+			content, err := json.Marshal(snapshotServices(r.Context(), cli, requestNamespace(r)))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: services\ndata: %s\n\n", content)
+			flusher.Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// handleStatusListAPI serves GET /api/status, the live state of the services
+func handleStatusListAPI(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshotServices(r.Context(), cli, requestNamespace(r)))
+	}
+}
+
+// handleDashboard serves GET /dashboard, the admin web UI
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>traefik-ondemand-service</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4em .8em; border-bottom: 1px solid #ddd; }
+tr.selected { background: #eef; }
+.up { color: #080; } .down { color: #888; } .starting, .stopping, .draining { color: #c80; } .unknown, .failed, .stop-failed { color: #c00; }
+button { margin-right: .3em; }
+#history { margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>Services</h1>
+<p id="savings"></p>
+<table>
+<thead><tr><th>Name</th><th>State</th><th>Idle in</th><th>Sessions</th><th>Last request</th><th></th></tr></thead>
+<tbody id="services"></tbody>
+</table>
+<div id="history"></div>
+<script>
+var selected = null;
+
+function ago(time) {
+  if (!time || time.indexOf("0001-") === 0) return "";
+  return new Date(time).toLocaleString();
+}
+
+function duration(seconds) {
+  if (!seconds) return "";
+  return Math.floor(seconds / 60) + "m " + (seconds % 60) + "s";
+}
+
+function call(method, path) {
+  fetch(path, {method: method}).then(function (response) {
+    return response.json();
+  }).then(function (result) {
+    if (result.error) alert(result.error);
+  });
+}
+
+function button(label, action) {
+  var element = document.createElement("button");
+  element.textContent = label;
+  element.onclick = function (event) { event.stopPropagation(); action(); };
+  return element;
+}
+
+function render(services) {
+  var body = document.getElementById("services");
+  body.innerHTML = "";
+  services.forEach(function (service) {
+    var path = "/api/services/" + encodeURIComponent(service.name);
+    var row = document.createElement("tr");
+    if (service.name === selected) row.className = "selected";
+    [service.name, service.state, service.pinned ? "pinned" : duration(service.remaining), service.sessions, ago(service.lastRequestAt)].forEach(function (value, i) {
+      var cell = document.createElement("td");
+      cell.textContent = value;
+      if (i === 1) { cell.className = service.state; cell.title = service.error || ""; }
+      row.appendChild(cell);
+    });
+    var actions = document.createElement("td");
+    actions.appendChild(button("Start", function () { call("POST", path + "/start?timeout=" + (service.timeout || 300)); }));
+    actions.appendChild(button("Stop", function () { call("POST", path + "/stop"); }));
+    actions.appendChild(button(service.pinned ? "Unpin" : "Pin", function () {
+      call(service.pinned ? "DELETE" : "PUT", path + "/pin?timeout=" + (service.timeout || 300));
+    }));
+    row.appendChild(actions);
+    row.onclick = function () { selected = service.name; history(service.name); render(services); };
+    body.appendChild(row);
+  });
+}
+
+function history(name) {
+  fetch("/api/audit?service=" + encodeURIComponent(name)).then(function (response) {
+    return response.json();
+  }).then(function (events) {
+    var container = document.getElementById("history");
+    container.innerHTML = "<h2></h2><ul></ul>";
+    container.querySelector("h2").textContent = "History of " + name;
+    events.reverse().slice(0, 50).forEach(function (event) {
+      var item = document.createElement("li");
+      item.textContent = ago(event.time) + " " + event.action + (event.reason ? " (" + event.reason + ")" : "");
+      container.querySelector("ul").appendChild(item);
+    });
+  });
+}
+
+function savings() {
+  fetch("/api/stats").then(function (response) {
+    return response.json();
+  }).then(function (stats) {
+    var text = "";
+    if (stats.services.length) {
+      text = "Saved " + stats.total.savedHours.toFixed(1) + " hours";
+      if (stats.total.costSaved) text += " (" + stats.total.costSaved.toFixed(2) + " in cost)";
+      text += " since " + ago(stats.total.since);
+    }
+    document.getElementById("savings").textContent = text;
+  });
+}
+
+var events = new EventSource("/api/events");
+events.addEventListener("services", function (event) {
+  render(JSON.parse(event.data));
+  savings();
+  if (selected) history(selected);
+});
+</script>
+</body>
+</html>
+`
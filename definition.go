@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// Definition describes how to create a docker service that does not exist yet
+type Definition struct {
+	Image string   `json:"image"`
+	Env   []string `json:"env,omitempty"`
+	// Ports are published as published:target[/protocol]
+	Ports []string `json:"ports,omitempty"`
+	// Volumes are mounted as source:target[:ro], source being a volume name or an absolute host path
+	Volumes  []string          `json:"volumes,omitempty"`
+	Networks []string          `json:"networks,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// RemoveWhenIdle removes the docker service instead of putting it down when idle
+	RemoveWhenIdle bool `json:"removeWhenIdle,omitempty"`
+}
+
+// definitions holds the definitions of the services that can be created on demand, by name
+var definitions = map[string]*Definition{}
+
+func loadDefinitions(path string) (map[string]*Definition, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]*Definition{}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	for name, definition := range loaded {
+		if definition.Image == "" {
+			return nil, fmt.Errorf("service %s has no image", name)
+		}
+	}
+	return loaded, nil
+}
+
+func parsePort(port string) (swarm.PortConfig, error) {
+	protocol := swarm.PortConfigProtocolTCP
+	if parts := strings.SplitN(port, "/", 2); len(parts) == 2 {
+		port = parts[0]
+		protocol = swarm.PortConfigProtocol(parts[1])
+	}
+	parts := strings.SplitN(port, ":", 2)
+	if len(parts) != 2 {
+		return swarm.PortConfig{}, fmt.Errorf("port %s should be published:target[/protocol]", port)
+	}
+	published, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return swarm.PortConfig{}, fmt.Errorf("port %s should be published:target[/protocol]", port)
+	}
+	target, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return swarm.PortConfig{}, fmt.Errorf("port %s should be published:target[/protocol]", port)
+	}
+	return swarm.PortConfig{
+		Protocol:      protocol,
+		PublishedPort: uint32(published),
+		TargetPort:    uint32(target),
+	}, nil
+}
+
+func parseVolume(volume string) (mount.Mount, error) {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return mount.Mount{}, fmt.Errorf("volume %s should be source:target[:ro]", volume)
+	}
+	volumeMount := mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: parts[0],
+		Target: parts[1],
+	}
+	if strings.HasPrefix(parts[0], "/") {
+		volumeMount.Type = mount.TypeBind
+	}
+	if len(parts) == 3 {
+		if parts[2] != "ro" {
+			return mount.Mount{}, fmt.Errorf("volume %s should be source:target[:ro]", volume)
+		}
+		volumeMount.ReadOnly = true
+	}
+	return volumeMount, nil
+}
+
+// spec builds the docker service spec of the definition with the given number of replicas
+func (definition *Definition) spec(name string, replicas uint64) (swarm.ServiceSpec, error) {
+	spec := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   name,
+			Labels: definition.Labels,
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: swarm.ContainerSpec{
+				Image: definition.Image,
+				Env:   definition.Env,
+			},
+		},
+		Mode: swarm.ServiceMode{
+			Replicated: &swarm.ReplicatedService{
+				Replicas: getPointer(replicas),
+			},
+		},
+		EndpointSpec: &swarm.EndpointSpec{},
+	}
+	for _, port := range definition.Ports {
+		portConfig, err := parsePort(port)
+		if err != nil {
+			return swarm.ServiceSpec{}, err
+		}
+		spec.EndpointSpec.Ports = append(spec.EndpointSpec.Ports, portConfig)
+	}
+	for _, volume := range definition.Volumes {
+		volumeMount, err := parseVolume(volume)
+		if err != nil {
+			return swarm.ServiceSpec{}, err
+		}
+		spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, volumeMount)
+	}
+	for _, network := range definition.Networks {
+		spec.TaskTemplate.Networks = append(spec.TaskTemplate.Networks, swarm.NetworkAttachmentConfig{Target: network})
+	}
+	return spec, nil
+}
+
+// create creates the docker service from its definition, already scaled up
+func (service *Service) create(ctx context.Context, client *client.Client, definition *Definition) error {
+	fmt.Printf("Creating service %s from image %s\n", service.name, definition.Image)
+	spec, err := definition.spec(service.name, oneReplica)
+	if err != nil {
+		return err
+	}
+	if err := service.applyOverrides(&spec, service.labels(&swarm.Service{Spec: spec})); err != nil {
+		return err
+	}
+	if err := service.restoreNetworks(ctx, client, &spec); err != nil {
+		return err
+	}
+	_, err = client.ServiceCreate(ctx, spec, types.ServiceCreateOptions{})
+	return err
+}
+
+// recreate creates the docker service again from its spec kept when it was removed, or from its definition
+func (service *Service) recreate(ctx context.Context, client *client.Client) error {
+	spec := getRemovedSpec(service.name)
+	if spec == nil {
+		return service.create(ctx, client, getDefinition(service.name))
+	}
+	fmt.Printf("Creating service %s again\n", service.name)
+	created := *spec
+	if err := service.applyOverrides(&created, service.labels(&swarm.Service{Spec: created})); err != nil {
+		return err
+	}
+	if err := service.restoreNetworks(ctx, client, &created); err != nil {
+		return err
+	}
+	if _, err := client.ServiceCreate(ctx, created, types.ServiceCreateOptions{}); err != nil {
+		return err
+	}
+	registryMutex.Lock()
+	delete(removedSpecs, service.name)
+	registryMutex.Unlock()
+	return store.Update(func(state *State) {
+		delete(state.Removed, service.name)
+	})
+}
+
+// removeKeepingSpec removes the docker service, keeping its spec scaled up to create it again
+func (service *Service) removeKeepingSpec(ctx context.Context, client *client.Client) error {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return err
+	}
+	spec := dockerService.Spec
+	if spec.Mode.Replicated != nil && spec.Mode.Replicated.Replicas != nil && *spec.Mode.Replicated.Replicas == zeroReplica {
+		spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: getPointer(oneReplica)}
+	}
+	registryMutex.Lock()
+	removedSpecs[service.name] = &spec
+	registryMutex.Unlock()
+	err = store.Update(func(state *State) {
+		state.Removed[service.name] = &spec
+	})
+	if err != nil {
+		return err
+	}
+	return service.remove(ctx, client)
+}
+
+// remove removes the docker service
+func (service *Service) remove(ctx context.Context, client *client.Client) error {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return err
+	}
+	teardown, err := service.prepareTeardown(ctx, client, dockerService)
+	if err != nil {
+		return err
+	}
+	if err := service.prepareStop(ctx, client, dockerService); err != nil {
+		return err
+	}
+	fmt.Printf("Removing service %s\n", service.name)
+	if err := client.ServiceRemove(ctx, dockerService.ID); err != nil {
+		return err
+	}
+	go teardown.run(service, client)
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// portDetails is a port of a service
+type portDetails struct {
+	Protocol      string `json:"protocol"`
+	TargetPort    uint32 `json:"targetPort"`
+	PublishedPort uint32 `json:"publishedPort,omitempty"`
+}
+
+// containerDetails is a running container of a service, with a snapshot of its resource usage
+type containerDetails struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	Health    string    `json:"health,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	// CPUPercent is the percentage of one CPU used since the previous sample of docker
+	CPUPercent       float64 `json:"cpuPercent"`
+	MemoryBytes      uint64  `json:"memoryBytes"`
+	MemoryLimitBytes uint64  `json:"memoryLimitBytes,omitempty"`
+}
+
+// detailsResponse describes a docker service and the state of the scaler about it
+type detailsResponse struct {
+	Name       string             `json:"name"`
+	Image      string             `json:"image"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	Replicas   uint64             `json:"replicas"`
+	Ports      []portDetails      `json:"ports"`
+	Containers []containerDetails `json:"containers"`
+	// The state of the scaler
+	Status         Status     `json:"status"`
+	State          Status     `json:"state"`
+	ContainerState string     `json:"containerState,omitempty"`
+	Strategy       Strategy   `json:"strategy"`
+	Timeout        uint64     `json:"timeout,omitempty"`
+	IdleDeadline   *time.Time `json:"idleDeadline,omitempty"`
+	Pinned         bool       `json:"pinned"`
+	LastRequestAt  *time.Time `json:"lastRequestAt,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	// Flapping reports the recent flaps of the service and the extension of its timeout
+	Flapping *FlapStatus `json:"flapping,omitempty"`
+}
+
+// timePointer returns nil for the zero time, omitted from the responses
+func timePointer(value time.Time) *time.Time {
+	if value.IsZero() {
+		return nil
+	}
+	return &value
+}
+
+// inspectContainer returns the state of a container and a snapshot of its resource usage
+func inspectContainer(ctx context.Context, client *client.Client, containerID string) (containerDetails, error) {
+	dockerClient := containerClient(client, containerID)
+	container, err := dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return containerDetails{}, err
+	}
+	details := containerDetails{ID: containerID, State: container.State.Status}
+	details.StartedAt, _ = time.Parse(time.RFC3339Nano, container.State.StartedAt)
+	if container.State.Health != nil {
+		details.Health = container.State.Health.Status
+	}
+	response, err := dockerClient.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return details, err
+	}
+	defer response.Body.Close()
+	stats := types.StatsJSON{}
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return details, err
+	}
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		details.CPUPercent = cpuDelta / systemDelta * float64(len(stats.CPUStats.CPUUsage.PercpuUsage)) * 100
+	}
+	details.MemoryBytes = stats.MemoryStats.Usage
+	details.MemoryLimitBytes = stats.MemoryStats.Limit
+	return details, nil
+}
+
+// handleDetailsAPI serves GET /api/services/{name}/details
+func handleDetailsAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	if isPattern(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("details are not available for a pattern"))
+		return
+	}
+	service := getService(name)
+	if service == nil {
+		service = &Service{name: name}
+	}
+	status, err := service.getStatus(r.Context(), cli)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ctx, cancel := dockerContext(r.Context())
+	defer cancel()
+	dockerService, err := service.getDockerService(ctx, cli)
+	if _, notFound := err.(*NotFoundError); notFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := detailsResponse{
+		Name:           dockerService.Spec.Name,
+		Image:          dockerService.Spec.TaskTemplate.ContainerSpec.Image,
+		CreatedAt:      dockerService.CreatedAt,
+		UpdatedAt:      dockerService.UpdatedAt,
+		Ports:          []portDetails{},
+		Containers:     []containerDetails{},
+		Status:         status,
+		State:          service.machine.State(),
+		ContainerState: service.containerState,
+		Strategy:       service.strategy,
+		Timeout:        service.timeout,
+		Pinned:         service.pinned,
+		LastRequestAt:  timePointer(service.lastRequestAt),
+		LastError:      service.lastError,
+	}
+	if dockerService.Spec.Mode.Replicated != nil && dockerService.Spec.Mode.Replicated.Replicas != nil {
+		response.Replicas = *dockerService.Spec.Mode.Replicated.Replicas
+	}
+	if flapping := service.flapStatus(time.Now()); flapping.Flaps > 0 || flapping.Factor > 1 {
+		response.Flapping = &flapping
+	}
+	if service.isRunning() && !service.pinned {
+		response.IdleDeadline = timePointer(service.idleDeadline)
+	}
+	for _, port := range dockerService.Endpoint.Ports {
+		response.Ports = append(response.Ports, portDetails{Protocol: string(port.Protocol), TargetPort: port.TargetPort, PublishedPort: port.PublishedPort})
+	}
+	containerIDs, err := getRunningContainers(ctx, cli, dockerService)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, containerID := range containerIDs {
+		container, err := inspectContainer(ctx, cli, containerID)
+		if err != nil {
+			// A container can stop between the listing and the inspection
+			fmt.Printf("Error: %+v\n ", err)
+			continue
+		}
+		response.Containers = append(response.Containers, container)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+func init() {
+	metrics.Register("ondemand_docker_operations_in_flight", "gauge", "Number of docker operations running")
+	metrics.Register("ondemand_docker_operations_waiting", "gauge", "Number of docker operations waiting for a slot")
+	metrics.Register("ondemand_docker_pool_saturated_total", "counter", "Number of docker operations that had to wait for a slot")
+}
+
+// DockerPool bounds the number of docker operations (status reads, starts and stops of a service) run at the same time,
+// the other ones waiting for a slot, so that a flood of requests for many services does not saturate the daemon
+type DockerPool struct {
+	slots   chan struct{}
+	waiting int32
+}
+
+// dockerPool bounds the docker operations, nil when they are not bounded
+var dockerPool *DockerPool
+
+func newDockerPool(size int) *DockerPool {
+	if size <= 0 {
+		return nil
+	}
+	return &DockerPool{slots: make(chan struct{}, size)}
+}
+
+// acquire waits for a slot until ctx is done, returning the function releasing it
+func (pool *DockerPool) acquire(ctx context.Context) (func(), error) {
+	if pool == nil {
+		return func() {}, nil
+	}
+	select {
+	case pool.slots <- struct{}{}:
+	default:
+		// The pool is saturated: the operation waits for another one to complete
+		metrics.Add("ondemand_docker_pool_saturated_total", 1)
+		metrics.Set("ondemand_docker_operations_waiting", float64(atomic.AddInt32(&pool.waiting, 1)))
+		select {
+		case pool.slots <- struct{}{}:
+			metrics.Set("ondemand_docker_operations_waiting", float64(atomic.AddInt32(&pool.waiting, -1)))
+		case <-ctx.Done():
+			metrics.Set("ondemand_docker_operations_waiting", float64(atomic.AddInt32(&pool.waiting, -1)))
+			return nil, ctx.Err()
+		}
+	}
+	metrics.Set("ondemand_docker_operations_in_flight", float64(len(pool.slots)))
+	return func() {
+		<-pool.slots
+		metrics.Set("ondemand_docker_operations_in_flight", float64(len(pool.slots)))
+	}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// States of a docker container, as reported by docker inspect
+const (
+	containerCreated    = "created"
+	containerRunning    = "running"
+	containerPaused     = "paused"
+	containerRestarting = "restarting"
+	containerRemoving   = "removing"
+	containerExited     = "exited"
+	containerDead       = "dead"
+)
+
+// getContainerState returns the docker state of the most recent container swarm runs for the service,
+// the state of its task while it has no container yet, and "" when it has no task
+func getContainerState(ctx context.Context, client *client.Client, dockerService *swarm.Service) (string, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("service", dockerService.ID)
+	filterArgs.Add("desired-state", string(swarm.TaskStateRunning))
+	tasks, err := client.TaskList(ctx, types.TaskListOptions{Filters: filterArgs})
+	if err != nil {
+		return "", err
+	}
+	var latest *swarm.Task
+	for i, task := range tasks {
+		if latest == nil || task.Status.Timestamp.After(latest.Status.Timestamp) {
+			latest = &tasks[i]
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	containerID := latest.Status.ContainerStatus.ContainerID
+	if containerID == "" {
+		switch latest.Status.State {
+		case swarm.TaskStateFailed, swarm.TaskStateRejected, swarm.TaskStateComplete, swarm.TaskStateShutdown:
+			return containerExited, nil
+		}
+		return containerCreated, nil
+	}
+	recordContainerNode(latest.ServiceID, containerID, latest.NodeID)
+	container, err := containerClient(client, containerID).ContainerInspect(ctx, containerID)
+	if err != nil {
+		// The container of a task that exited may already be removed
+		return containerExited, nil
+	}
+	return container.State.Status, nil
+}
+
+// isDead returns an error when the container of the service is dead, which swarm cannot recover from:
+// the service is marked as failed and a notification is sent, once
+func (service *Service) isDead() error {
+	if service.containerState != containerDead {
+		return nil
+	}
+	if service.machine.State() != FAILED && service.transition(FAILED, "container is dead") {
+		fmt.Printf("- Service %v container is dead\n", service.name)
+		audit(service.name, "dead", "container is dead", service.requestID)
+		notify("dead", service, "container is dead")
+	}
+	return fmt.Errorf("Service %s container is dead", service.name)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// DRAINING represents an idle service whose in-flight requests the plugin lets complete, no longer routing the new
+// ones to it, before the scaler stops it
+const DRAINING Status = "draining"
+
+// drainLabel is used on the docker service to drain it before its idle stops, for at most its duration (e.g. 30s)
+const drainLabel = "ondemand.drain"
+
+// Results of the drains
+const (
+	// drainDrained is reported by the plugin once the in-flight requests completed
+	drainDrained = "drained"
+	// drainTimeout is when the plugin did not report within the duration of the drainLabel
+	drainTimeout = "timeout"
+	// drainRequested is when the service was requested again while draining, which cancels its stop
+	drainRequested = "requested"
+)
+
+func init() {
+	metrics.Register("ondemand_drains_total", "counter", "Number of drains of a service before its idle stop, by service and result (drained, timeout or requested)")
+}
+
+// DrainStatus tells the plugin whether a service is draining, and until when it has to report its in-flight requests
+// completed
+type DrainStatus struct {
+	Name     string     `json:"name"`
+	State    Status     `json:"state"`
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// drain moves the idle service to DRAINING and waits until the plugin reports its in-flight requests completed, or
+// for at most the duration of its drainLabel, reporting whether it was requested again meanwhile. A service without
+// the label is not drained
+func (service *Service) drain(client *client.Client) bool {
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return false
+	}
+	timeout, err := parseDurationLabel(labels, drainLabel)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	// A service that is not up yet has no in-flight requests
+	if timeout <= 0 || service.shadow || service.machine.State() != UP || !service.transition(DRAINING, "idle") {
+		return false
+	}
+	done := make(chan string, 1)
+	service.drainMutex.Lock()
+	service.drained = done
+	service.drainingSince = time.Now()
+	service.drainDeadline = service.drainingSince.Add(timeout)
+	service.drainMutex.Unlock()
+	fmt.Printf("- Service %v is draining for at most %v\n", service.name, timeout)
+	audit(service.name, "drain", fmt.Sprintf("idle, at most %v", timeout), "")
+
+	result := drainTimeout
+	select {
+	case result = <-done:
+	case <-time.After(timeout):
+	}
+	service.drainMutex.Lock()
+	service.drained = nil
+	service.drainingSince = time.Time{}
+	service.drainDeadline = time.Time{}
+	service.drainMutex.Unlock()
+	metrics.Add("ondemand_drains_total", 1, "service", service.name, "result", result)
+	switch result {
+	case drainRequested:
+		fmt.Printf("- Service %v is requested while draining, its stop is cancelled\n", service.name)
+		return true
+	case drainTimeout:
+		fmt.Printf("- Service %v in-flight requests were not reported completed within %v\n", service.name, timeout)
+	default:
+		fmt.Printf("- Service %v is drained\n", service.name)
+	}
+	return false
+}
+
+// endDrain ends the drain of the service with the result, reporting whether it was draining
+func (service *Service) endDrain(result string) bool {
+	service.drainMutex.Lock()
+	defer service.drainMutex.Unlock()
+	if service.drained == nil {
+		return false
+	}
+	select {
+	case service.drained <- result:
+	default:
+	}
+	service.drained = nil
+	return true
+}
+
+// cancelDrain cancels the stop of the service requested while draining, moving it back to UP
+func (service *Service) cancelDrain() {
+	if service.machine.State() == DRAINING && service.endDrain(drainRequested) {
+		service.transition(UP, "requested")
+	}
+}
+
+// drainStatus returns the drain of the service
+func (service *Service) drainStatus() DrainStatus {
+	status := DrainStatus{Name: service.name, State: service.machine.State()}
+	service.drainMutex.Lock()
+	defer service.drainMutex.Unlock()
+	if service.drained != nil {
+		status.Draining = true
+		status.Since = timePointer(service.drainingSince)
+		status.Deadline = timePointer(service.drainDeadline)
+	}
+	return status
+}
+
+// handleDrainAPI serves GET /api/services/{name}/drain, polled by the plugin to stop routing the new requests to a
+// draining service, and POST, with which it reports the in-flight requests completed for the service to be stopped
+func handleDrainAPI(w http.ResponseWriter, r *http.Request, name string) {
+	service := getService(name)
+	if service == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("service %s has not been requested yet", name))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, service.drainStatus())
+	case http.MethodPost:
+		if !service.endDrain(drainDrained) {
+			writeError(w, http.StatusConflict, fmt.Errorf("service %s is not draining", name))
+			return
+		}
+		writeJSON(w, http.StatusAccepted, service.drainStatus())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// Label used on the docker service to only track its requests and timeouts, without starting nor stopping it
+const shadowLabel = "ondemand.shadow"
+
+// Reasons of the actions recorded in the audit log in dry run and for shadow services
+const (
+	dryRunReason = "dry run"
+	shadowReason = "shadow"
+)
+
+// simulationReason returns why the actions on the service are simulated, or an empty string when they are not
+func (service *Service) simulationReason() string {
+	if service.shadow {
+		return shadowReason
+	}
+	if dryRun.Get() {
+		return dryRunReason
+	}
+	return ""
+}
+
+// simulate replaces an action of the scaler on the service by a log line in dry run and for shadow services,
+// for the policy to be validated without calling the docker API
+func (service *Service) simulate(action string, run func(client *client.Client) error) func(client *client.Client) error {
+	reason := service.simulationReason()
+	if reason == "" {
+		return run
+	}
+	return func(client *client.Client) error {
+		fmt.Printf("%s: would %s service %s\n", strings.ToUpper(reason[:1])+reason[1:], action, service.name)
+		return nil
+	}
+}
+
+// simulatedStatus returns UP in dry run for the services the scaler would have started and not stopped yet,
+// so that their timeout is tracked as if they were up. A shadow service, running all the time, is only up
+// between the simulated start and stop.
+func (service *Service) simulatedStatus(status Status) Status {
+	if service.shadow {
+		if service.isRunning() {
+			return UP
+		}
+		return DOWN
+	}
+	if dryRun.Get() && status != UP && service.isRunning() {
+		return UP
+	}
+	return status
+}
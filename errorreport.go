@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// reportedAuditEvents is the number of the last actions taken on a service attached to its error reports
+const reportedAuditEvents = 10
+
+// Sentry sends error reports to the store endpoint of a Sentry project
+type Sentry struct {
+	endpoint string
+	key      string
+}
+
+// sentry is nil unless --sentry-dsn is set
+var sentry *Sentry
+
+// newSentry parses a DSN of the form https://<key>@<host>/<project>
+func newSentry(dsn string) (*Sentry, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil || parsed.User == nil || parsed.Host == "" {
+		return nil, fmt.Errorf("--sentry-dsn should be https://<key>@<host>/<project>")
+	}
+	project := strings.Trim(parsed.Path, "/")
+	if project == "" {
+		return nil, fmt.Errorf("--sentry-dsn should be https://<key>@<host>/<project>")
+	}
+	return &Sentry{
+		endpoint: fmt.Sprintf("%s://%s/api/%s/store/", parsed.Scheme, parsed.Host, project),
+		key:      parsed.User.Username(),
+	}, nil
+}
+
+// sentryEvent is the event sent to the store endpoint of Sentry
+type sentryEvent struct {
+	EventID   string                 `json:"event_id"`
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Logger    string                 `json:"logger"`
+	Platform  string                 `json:"platform"`
+	Message   string                 `json:"message"`
+	Tags      map[string]string      `json:"tags,omitempty"`
+	Extra     map[string]interface{} `json:"extra,omitempty"`
+}
+
+func (sentry *Sentry) send(event sentryEvent) error {
+	content, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sentry.endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=traefik-ondemand-service/1.0", sentry.key))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s answered %d", sentry.endpoint, response.StatusCode)
+	}
+	return nil
+}
+
+// lastAuditEvents returns the last actions taken on a service
+func lastAuditEvents(service string) []AuditEvent {
+	events := auditLog(service)
+	if len(events) > reportedAuditEvents {
+		events = events[len(events)-reportedAuditEvents:]
+	}
+	return events
+}
+
+// reportError reports an unexpected failure (docker API error, failed start or stop, panic) about a service,
+// nil when it is about none, to Sentry and to the notification webhook, in the background, with the last actions
+// taken on the service
+func reportError(service *Service, err error, stack string) {
+	if service != nil {
+		service.lastError = err.Error()
+	}
+	if sentry == nil && *notifyURL == "" {
+		return
+	}
+	notification := Notification{Time: time.Now(), Event: "error", Message: err.Error()}
+	if service != nil {
+		notification.Service = service.name
+		notification.RequestID = service.requestID
+		notification.Audit = lastAuditEvents(service.name)
+	}
+	go func() {
+		if *notifyURL != "" {
+			if err := postNotification(*notifyURL, notification); err != nil {
+				fmt.Printf("Error: could not report error: %+v\n ", err)
+			}
+		}
+		if sentry != nil {
+			event := sentryEvent{
+				EventID:   randomHex(16),
+				Timestamp: notification.Time.UTC().Format("2006-01-02T15:04:05"),
+				Level:     "error",
+				Logger:    "ondemand",
+				Platform:  "go",
+				Message:   notification.Message,
+				Tags:      map[string]string{},
+				Extra:     map[string]interface{}{"audit": notification.Audit},
+			}
+			if notification.Service != "" {
+				event.Tags["service"] = notification.Service
+			}
+			if notification.RequestID != "" {
+				event.Tags["request_id"] = notification.RequestID
+			}
+			if stack != "" {
+				event.Extra["stack"] = stack
+			}
+			if err := sentry.send(event); err != nil {
+				fmt.Printf("Error: could not report error to sentry: %+v\n ", err)
+			}
+		}
+	}()
+}
+
+// recoverPanics answers 500 to the requests whose handler panics, and reports the panic
+func recoverPanics(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				stack := string(debug.Stack())
+				fmt.Printf("Error: panic serving %s: %v\n%s\n", r.URL.Path, recovered, stack)
+				reportError(nil, fmt.Errorf("panic serving %s: %v", r.URL.Path, recovered), stack)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// reportPanic recovers the panic of a background goroutine (timer, loop, port proxy) and reports it, about service
+// when it is not nil: the goroutine ends instead of the process. It is deferred by the goroutine itself
+func reportPanic(service *Service, task string) {
+	if recovered := recover(); recovered != nil {
+		stack := string(debug.Stack())
+		fmt.Printf("Error: panic in %s: %v\n%s\n", task, recovered, stack)
+		reportError(service, fmt.Errorf("panic in %s: %v", task, recovered), stack)
+	}
+}
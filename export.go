@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// exportVersion is the version of the format of the exports, checked by the imports
+const exportVersion = 1
+
+// Export is a snapshot of the state of the scaler, to restore it on another instance
+type Export struct {
+	Version       int                            `json:"version"`
+	Time          time.Time                      `json:"time"`
+	Services      []ExportedService              `json:"services"`
+	Registrations map[string]*Registration       `json:"registrations"`
+	Usage         map[string][]int64             `json:"usage,omitempty"`
+	Predictions   map[string]*PredictionOverride `json:"predictions,omitempty"`
+	Removed       map[string]*swarm.ServiceSpec  `json:"removed,omitempty"`
+	Networks      map[string][]RemovedNetwork    `json:"networks,omitempty"`
+	Stats         map[string]*ExportedStats      `json:"stats,omitempty"`
+}
+
+// ExportedService is the state of a service tracked by the scaler
+type ExportedService struct {
+	Name          string       `json:"name"`
+	Timeout       uint64       `json:"timeout"`
+	State         Status       `json:"state"`
+	Transitions   []Transition `json:"transitions,omitempty"`
+	Pinned        bool         `json:"pinned,omitempty"`
+	StartedAt     *time.Time   `json:"startedAt,omitempty"`
+	StoppedAt     *time.Time   `json:"stoppedAt,omitempty"`
+	UpAt          *time.Time   `json:"upAt,omitempty"`
+	LastRequestAt *time.Time   `json:"lastRequestAt,omitempty"`
+	IdleDeadline  *time.Time   `json:"idleDeadline,omitempty"`
+	// RuntimeSeconds is how long the service ran during the day of RuntimeDay, before its last stop
+	RuntimeSeconds float64    `json:"runtimeSeconds,omitempty"`
+	RuntimeDay     *time.Time `json:"runtimeDay,omitempty"`
+	CrashBackoff   string     `json:"crashBackoff,omitempty"`
+	BackoffUntil   *time.Time `json:"backoffUntil,omitempty"`
+}
+
+// ExportedStats are the cumulated statistics of a service
+type ExportedStats struct {
+	Since            time.Time `json:"since"`
+	WakeUps          int       `json:"wakeUps"`
+	RunningSeconds   float64   `json:"runningSeconds"`
+	ColdStartSeconds float64   `json:"coldStartSeconds"`
+	ColdStarts       int       `json:"coldStarts"`
+}
+
+// importResponse reports what an import restored
+type importResponse struct {
+	Services      int `json:"services"`
+	Registrations int `json:"registrations"`
+	Stats         int `json:"stats"`
+}
+
+// exportState snapshots the services, registrations, usage and statistics of the namespace
+func exportState(namespace string, now time.Time) *Export {
+	export := &Export{
+		Version:       exportVersion,
+		Time:          now,
+		Services:      []ExportedService{},
+		Registrations: map[string]*Registration{},
+		Usage:         map[string][]int64{},
+		Predictions:   map[string]*PredictionOverride{},
+		Removed:       map[string]*swarm.ServiceSpec{},
+		Networks:      map[string][]RemovedNetwork{},
+		Stats:         map[string]*ExportedStats{},
+	}
+	servicesMutex.Lock()
+	list := []*Service{}
+	for name, service := range services {
+		if inNamespace(namespace, name) {
+			list = append(list, service)
+		}
+	}
+	servicesMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	for _, service := range list {
+		exported := ExportedService{
+			Name:           service.name,
+			Timeout:        service.timeout,
+			State:          service.machine.State(),
+			Transitions:    service.machine.History(),
+			Pinned:         service.pinned,
+			StartedAt:      timePointer(service.startedAt),
+			StoppedAt:      timePointer(service.stoppedAt),
+			UpAt:           timePointer(service.upAt),
+			LastRequestAt:  timePointer(service.lastRequestAt),
+			IdleDeadline:   timePointer(service.idleDeadline),
+			RuntimeSeconds: service.runtime.Seconds(),
+			RuntimeDay:     timePointer(service.runtimeDay),
+			BackoffUntil:   timePointer(service.backoffUntil),
+		}
+		if service.crashBackoff > 0 {
+			exported.CrashBackoff = service.crashBackoff.String()
+		}
+		export.Services = append(export.Services, exported)
+	}
+
+	registryMutex.RLock()
+	for name, registration := range registrations {
+		if inNamespace(namespace, name) {
+			export.Registrations[name] = registration
+		}
+	}
+	for name, spec := range removedSpecs {
+		if inNamespace(namespace, name) {
+			export.Removed[name] = spec
+		}
+	}
+	for name, networks := range removedNetworks {
+		if inNamespace(namespace, name) {
+			export.Networks[name] = networks
+		}
+	}
+	registryMutex.RUnlock()
+
+	usageMutex.Lock()
+	for name, slots := range usage {
+		if inNamespace(namespace, name) {
+			export.Usage[name] = append([]int64{}, slots...)
+		}
+	}
+	for name, override := range predictionOverrides {
+		if inNamespace(namespace, name) {
+			export.Predictions[name] = override
+		}
+	}
+	usageMutex.Unlock()
+
+	statsMutex.Lock()
+	for name, stats := range statistics {
+		if inNamespace(namespace, name) {
+			export.Stats[name] = &ExportedStats{
+				Since:            stats.since,
+				WakeUps:          stats.wakeUps,
+				RunningSeconds:   stats.running.Seconds(),
+				ColdStartSeconds: stats.coldStart.Seconds(),
+				ColdStarts:       stats.coldStarts,
+			}
+		}
+	}
+	statsMutex.Unlock()
+	return export
+}
+
+// validate checks the export can be imported in the namespace
+func (export *Export) validate(namespace string) error {
+	if export.Version != exportVersion {
+		return fmt.Errorf("version %d of the export is not supported, only %d is", export.Version, exportVersion)
+	}
+	names := []string{}
+	for _, service := range export.Services {
+		if service.CrashBackoff != "" {
+			if _, err := time.ParseDuration(service.CrashBackoff); err != nil {
+				return fmt.Errorf("service %s: crashBackoff %s is not a duration", service.Name, service.CrashBackoff)
+			}
+		}
+		names = append(names, service.Name)
+	}
+	for name, registration := range export.Registrations {
+		if registration == nil || registration.Name != name {
+			return fmt.Errorf("registration %s should have the name %s", name, name)
+		}
+		if err := registration.validate(); err != nil {
+			return fmt.Errorf("registration %s: %v", name, err)
+		}
+		names = append(names, name)
+	}
+	for name := range export.Stats {
+		names = append(names, name)
+	}
+	for name := range export.Usage {
+		names = append(names, name)
+	}
+	for name := range export.Predictions {
+		names = append(names, name)
+	}
+	for name := range export.Removed {
+		names = append(names, name)
+	}
+	for name := range export.Networks {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if name == "" || !inNamespace(namespace, name) {
+			return fmt.Errorf("service %q is not in the namespace of the token", name)
+		}
+	}
+	return nil
+}
+
+// importState restores an export, replacing the state of the services it holds and keeping the others. The services
+// it reports up are handled again, to be stopped at their idle deadline
+func importState(ctx context.Context, cli *client.Client, export *Export) (importResponse, error) {
+	response := importResponse{Services: len(export.Services), Registrations: len(export.Registrations), Stats: len(export.Stats)}
+	registryMutex.Lock()
+	for name, registration := range export.Registrations {
+		registrations[name] = registration
+		if registration.Definition != nil {
+			definitions[name] = registration.Definition
+		}
+	}
+	for name, spec := range export.Removed {
+		removedSpecs[name] = spec
+	}
+	for name, networks := range export.Networks {
+		removedNetworks[name] = networks
+	}
+	registryMutex.Unlock()
+
+	usageMutex.Lock()
+	for name, slots := range export.Usage {
+		usage[name] = slots
+	}
+	for name, override := range export.Predictions {
+		predictionOverrides[name] = override
+	}
+	usageMutex.Unlock()
+
+	statsMutex.Lock()
+	for name, stats := range export.Stats {
+		statistics[name] = &serviceStats{
+			since:      stats.Since,
+			wakeUps:    stats.WakeUps,
+			running:    time.Duration(stats.RunningSeconds * float64(time.Second)),
+			coldStart:  time.Duration(stats.ColdStartSeconds * float64(time.Second)),
+			coldStarts: stats.ColdStarts,
+		}
+	}
+	statsMutex.Unlock()
+
+	err := store.Update(func(state *State) {
+		registryMutex.RLock()
+		defer registryMutex.RUnlock()
+		for name, registration := range export.Registrations {
+			state.Registrations[name] = registration
+		}
+		for name, spec := range export.Removed {
+			state.Removed[name] = spec
+		}
+		for name, networks := range export.Networks {
+			state.Networks[name] = networks
+		}
+		for name, slots := range export.Usage {
+			state.Usage[name] = slots
+		}
+		for name, override := range export.Predictions {
+			state.Predictions[name] = override
+		}
+	})
+	if err != nil {
+		return response, err
+	}
+
+	now := time.Now()
+	for _, exported := range export.Services {
+		service := GetOrCreateService(exported.Name, exported.Timeout)
+		service.timeout = exported.Timeout
+		service.pinned = exported.Pinned
+		service.machine.restore(exported.State, exported.Transitions)
+		for _, field := range []struct {
+			value  *time.Time
+			target *time.Time
+		}{
+			{exported.StartedAt, &service.startedAt}, {exported.StoppedAt, &service.stoppedAt},
+			{exported.UpAt, &service.upAt}, {exported.LastRequestAt, &service.lastRequestAt},
+			{exported.RuntimeDay, &service.runtimeDay}, {exported.BackoffUntil, &service.backoffUntil},
+		} {
+			if field.value != nil {
+				*field.target = *field.value
+			}
+		}
+		service.runtime = time.Duration(exported.RuntimeSeconds * float64(time.Second))
+		service.crashBackoff, _ = time.ParseDuration(exported.CrashBackoff)
+		if service.isHandled || (exported.State != UP && exported.State != STARTING) {
+			continue
+		}
+		// The exporting instance was handling the service: it is stopped at the same deadline if it is still up
+		status, err := service.getStatus(ctx, cli)
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			continue
+		}
+		if status != UP && status != STARTING {
+			continue
+		}
+		remaining := uint64(1)
+		if exported.IdleDeadline != nil && exported.IdleDeadline.After(now) {
+			remaining = uint64(exported.IdleDeadline.Sub(now).Seconds()) + 1
+		}
+		fmt.Printf("- Service %v is imported, stopping in %d seconds\n", service.name, remaining)
+		go service.stopAfterTimeout(cli)
+		service.time <- remaining
+	}
+	return response, nil
+}
+
+// handleStateAPI serves GET /api/state/export and POST /api/state/import
+func handleStateAPI(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := requestNamespace(r)
+		switch {
+		case r.URL.Path == "/api/state/export" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, exportState(namespace, time.Now()))
+		case r.URL.Path == "/api/state/import" && r.Method == http.MethodPost:
+			export := &Export{}
+			if err := json.NewDecoder(r.Body).Decode(export); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := export.validate(namespace); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			response, err := importState(r.Context(), cli, export)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit("", "import", fmt.Sprintf("%d services, %d registrations", response.Services, response.Registrations), requestID(r))
+			writeJSON(w, http.StatusOK, response)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ignoredUserAgents and ignoredPaths match the requests that do not wake services up
+var ignoredUserAgents *regexp.Regexp
+var ignoredPaths = map[string]bool{}
+
+func parseIgnoredPaths(paths string) map[string]bool {
+	ignored := map[string]bool{}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			ignored[path] = true
+		}
+	}
+	return ignored
+}
+
+// originalUserAgent returns the user agent of the original request, as passed through by the plugin
+func originalUserAgent(r *http.Request) string {
+	if userAgent := r.Header.Get("X-Forwarded-User-Agent"); userAgent != "" {
+		return userAgent
+	}
+	return r.Header.Get("User-Agent")
+}
+
+// originalPath returns the path of the original request, as passed through by the plugin,
+// or the path of the request itself when it is proxied
+func originalPath(r *http.Request) string {
+	if uri := r.Header.Get("X-Forwarded-Uri"); uri != "" {
+		return strings.SplitN(uri, "?", 2)[0]
+	}
+	return r.URL.Path
+}
+
+// isIgnored reports whether the request comes from a bot or targets a path that should not wake services up
+func isIgnored(r *http.Request) bool {
+	if ignoredUserAgents != nil && ignoredUserAgents.MatchString(originalUserAgent(r)) {
+		return true
+	}
+	return ignoredPaths[originalPath(r)]
+}
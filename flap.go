@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// flapRewake is how soon after its stop a service must be woken up again for the wake-up to count as a flap
+	flapRewake = 5 * time.Minute
+	// flapPeriod is the period over which the flaps of a service are counted
+	flapPeriod = time.Hour
+	// flapThreshold is the number of flaps within flapPeriod from which a service is flapping
+	flapThreshold = 3
+	// flapHold is how long the idle timeout of a flapping service stays extended after its last flap
+	flapHold = time.Hour
+	// maxFlapFactor bounds the extension of the idle timeout, doubled at each flap of a flapping service
+	maxFlapFactor = 8
+)
+
+func init() {
+	metrics.Register("ondemand_flaps_total", "counter", "Number of wake-ups of services stopped less than 5 minutes before")
+}
+
+// FlapStatus reports the recent flaps of a service and the extension of its idle timeout
+type FlapStatus struct {
+	// Flaps is the number of wake-ups within 5 minutes of a stop during the last hour
+	Flaps int `json:"flaps"`
+	// Factor multiplies the idle timeout of the service until ExtendedUntil
+	Factor           int        `json:"factor"`
+	EffectiveTimeout uint64     `json:"effectiveTimeout"`
+	ExtendedUntil    *time.Time `json:"extendedUntil,omitempty"`
+}
+
+// recordFlap counts the start of the service as a flap when it was stopped less than flapRewake before, extending its
+// idle timeout when it flaps repeatedly
+func (service *Service) recordFlap(now time.Time) {
+	if service.stoppedAt.IsZero() || now.Sub(service.stoppedAt) >= flapRewake {
+		return
+	}
+	metrics.Add("ondemand_flaps_total", 1, "service", service.name)
+	recent := []time.Time{now}
+	for _, at := range service.flaps {
+		if now.Sub(at) < flapPeriod {
+			recent = append(recent, at)
+		}
+	}
+	service.flaps = recent
+	if len(service.flaps) < flapThreshold {
+		return
+	}
+	if now.After(service.flapUntil) || service.flapFactor < 2 {
+		service.flapFactor = 2
+	} else if service.flapFactor < maxFlapFactor {
+		service.flapFactor *= 2
+	}
+	service.flapUntil = now.Add(flapHold)
+	detail := fmt.Sprintf("%d wake-ups within %v of a stop in the last %v, idle timeout extended to %ds until %s",
+		len(service.flaps), flapRewake, flapPeriod, service.effectiveTimeout(), service.flapUntil.Format(time.RFC3339))
+	fmt.Printf("- Service %v is flapping: %s\n", service.name, detail)
+	audit(service.name, "flapping", detail, service.requestID)
+}
+
+// effectiveTimeout returns the idle timeout of the service, extended while it is flapping
+func (service *Service) effectiveTimeout() uint64 {
+	if service.flapFactor > 1 && time.Now().Before(service.flapUntil) {
+		return service.timeout * uint64(service.flapFactor)
+	}
+	return service.timeout
+}
+
+// flapStatus returns the recent flaps of the service and the extension of its idle timeout
+func (service *Service) flapStatus(now time.Time) FlapStatus {
+	status := FlapStatus{Factor: 1, EffectiveTimeout: service.effectiveTimeout()}
+	for _, at := range service.flaps {
+		if now.Sub(at) < flapPeriod {
+			status.Flaps++
+		}
+	}
+	if service.flapFactor > 1 && now.Before(service.flapUntil) {
+		status.Factor = service.flapFactor
+		status.ExtendedUntil = timePointer(service.flapUntil)
+	}
+	return status
+}
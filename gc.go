@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// gcInterval is the interval between two collections of the idle services
+const gcInterval = time.Minute
+
+// gcMinIdle is how long a service must be unrequested to be evicted when there are more than --max-services services,
+// for the requests being handled to keep their service
+const gcMinIdle = time.Minute
+
+func init() {
+	metrics.Register("ondemand_services_collected_total", "counter", "Number of services forgotten by the scaler, by reason (idle or max-services)")
+}
+
+// lastUsed returns when the service was last requested, stopped or first known
+func (service *Service) lastUsed() time.Time {
+	last := service.createdAt
+	for _, at := range []time.Time{service.lastRequestAt, service.stoppedAt} {
+		if at.After(last) {
+			last = at
+		}
+	}
+	return last
+}
+
+// isCollectable reports whether the scaler can forget the service: it is down, or was never seen (e.g. a typo'd name),
+// and nothing keeps it up, its state being found again in docker when it is requested again
+func (service *Service) isCollectable() bool {
+	state := service.machine.State()
+	if state != DOWN && state != UNKNOWN {
+		return false
+	}
+	return service.parent == nil && !service.pinned && !service.hasProxyConnections() && !service.hasActiveSessions()
+}
+
+// collectServices forgets the services unused for --gc-after, then the least recently used ones above --max-services
+func collectServices(now time.Time) {
+	servicesMutex.Lock()
+	defer servicesMutex.Unlock()
+	if gcAfter.Get() > 0 {
+		for _, service := range services {
+			if idle := now.Sub(service.lastUsed()); idle >= gcAfter.Get() && service.isCollectable() {
+				forgetService(service, "idle", fmt.Sprintf("unused for %s", idle.Round(time.Second)))
+			}
+		}
+	}
+	boundServices(now)
+}
+
+// boundServices forgets the least recently used services while there are more than --max-services, servicesMutex
+// being held
+func boundServices(now time.Time) {
+	if maxServices.Get() <= 0 || len(services) <= maxServices.Get() {
+		return
+	}
+	candidates := []*Service{}
+	for _, service := range services {
+		if now.Sub(service.lastUsed()) >= gcMinIdle && service.isCollectable() {
+			candidates = append(candidates, service)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed().Before(candidates[j].lastUsed())
+	})
+	for _, service := range candidates {
+		if len(services) <= maxServices.Get() {
+			return
+		}
+		forgetService(service, "max-services", fmt.Sprintf("least recently used of %d services", len(services)))
+	}
+}
+
+// forgetService removes the service from the services of the scaler, servicesMutex being held
+func forgetService(service *Service, reason string, detail string) {
+	delete(services, service.name)
+	forgetRequestRate(service.name)
+	fmt.Printf("- Service %v is forgotten: %s\n", service.name, detail)
+	audit(service.name, "forget", detail, "")
+	metrics.Add("ondemand_services_collected_total", 1, "reason", reason)
+	if *haLease != "" {
+		go forgetHandledService(service.name)
+	}
+}
+
+// runServicesCollection forgets the idle services every gcInterval
+func runServicesCollection() {
+	for now := range time.Tick(gcInterval) {
+		collectServices(now)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// regexpPrefix marks a name as a regular expression (e.g. ~^worker-[0-9]+$)
+const regexpPrefix = "~"
+
+// groupPrefix marks a name as a named group (e.g. group=dev-stack)
+const groupPrefix = "group="
+
+// groupLabel is used on the docker service to give it the comma separated groups it belongs to
+const groupLabel = "ondemand.group"
+
+// isPattern reports whether name is a glob (e.g. worker-*), a regular expression or a named group matching several docker services
+func isPattern(name string) bool {
+	return strings.HasPrefix(name, regexpPrefix) || strings.HasPrefix(name, groupPrefix) || strings.ContainsAny(name, "*?[")
+}
+
+func matchesPattern(pattern string, name string) (bool, error) {
+	if strings.HasPrefix(pattern, regexpPrefix) {
+		return regexp.MatchString(strings.TrimPrefix(pattern, regexpPrefix), name)
+	}
+	return path.Match(pattern, name)
+}
+
+// inGroup reports whether the docker service belongs to the group
+func inGroup(dockerService swarm.Service, group string) bool {
+	for _, name := range strings.Split(dockerService.Spec.Labels[groupLabel], ",") {
+		if strings.TrimSpace(name) == group {
+			return true
+		}
+	}
+	return false
+}
+
+// isMember reports whether the docker service is matched by the pattern name of the service
+func (service *Service) isMember(dockerService swarm.Service) (bool, error) {
+	if strings.HasPrefix(service.name, groupPrefix) {
+		return inGroup(dockerService, strings.TrimPrefix(service.name, groupPrefix)), nil
+	}
+	return matchesPattern(service.name, dockerService.Spec.Name)
+}
+
+// getMembersStatus refreshes the services matched by the pattern name of the service and aggregates their status:
+// UP when all are up, DOWN when any is down so that it gets woken up, and STARTING otherwise
+func (service *Service) getMembersStatus(ctx context.Context, client *client.Client) (Status, error) {
+	dockerServices, err := client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return "", err
+	}
+	members := map[string]*Service{}
+	for _, dockerService := range dockerServices {
+		matches, err := service.isMember(dockerService)
+		if err != nil {
+			return "", fmt.Errorf("%s is not a valid pattern: %v", service.name, err)
+		}
+		if !matches {
+			continue
+		}
+		member := service.members[dockerService.Spec.Name]
+		if member == nil {
+			member = &Service{name: dockerService.Spec.Name, timeout: service.timeout, parent: service}
+		}
+		members[dockerService.Spec.Name] = member
+	}
+	if len(members) == 0 {
+		return "", &NotFoundError{name: service.name}
+	}
+	service.members = members
+
+	up := 0
+	down := false
+	for _, member := range members {
+		status, err := member.getStatus(ctx, client)
+		if err != nil {
+			return "", err
+		}
+		member.status = status
+		if status == UP {
+			up++
+		} else if status == DOWN {
+			down = true
+		}
+	}
+	if up == len(members) {
+		return UP, nil
+	}
+	if down {
+		return DOWN, nil
+	}
+	return STARTING, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// Labels of the docker secret holding the leader lease
+const (
+	leaseHolderLabel  = "ondemand.lease.holder"
+	leaseAddressLabel = "ondemand.lease.address"
+	leaseExpiresLabel = "ondemand.lease.expires"
+)
+
+// leader is 1 when this instance holds the lease, and manages the timers and the background jobs
+var leader int32
+
+func isLeader() bool {
+	return atomic.LoadInt32(&leader) == 1
+}
+
+// instanceID identifies this instance in the lease
+func instanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Lease is a lock on the leadership, held in the labels of a docker secret so that the swarm
+// raft store arbitrates between the instances: concurrent updates of the secret are rejected by its version
+type Lease struct {
+	name     string
+	holder   string
+	address  string
+	duration time.Duration
+	// leaderAddress is the address of the current leader, as an atomic string
+	leaderAddress atomic.Value
+}
+
+func (lease *Lease) labels(now time.Time) map[string]string {
+	return map[string]string{
+		leaseHolderLabel:  lease.holder,
+		leaseAddressLabel: lease.address,
+		leaseExpiresLabel: strconv.FormatInt(now.Add(lease.duration).Unix(), 10),
+	}
+}
+
+// acquire creates, renews or takes over an expired lease, reporting whether this instance holds it
+func (lease *Lease) acquire(ctx context.Context, cli *client.Client) (bool, error) {
+	now := time.Now()
+	secret, _, err := cli.SecretInspectWithRaw(ctx, lease.name)
+	if client.IsErrSecretNotFound(err) {
+		_, err := cli.SecretCreate(ctx, swarm.SecretSpec{
+			Annotations: swarm.Annotations{Name: lease.name, Labels: lease.labels(now)},
+			Data:        []byte(lease.name),
+		})
+		if err != nil {
+			// Another instance created it first
+			return false, nil
+		}
+		lease.leaderAddress.Store(lease.address)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	holder := secret.Spec.Labels[leaseHolderLabel]
+	expires, _ := strconv.ParseInt(secret.Spec.Labels[leaseExpiresLabel], 10, 64)
+	if holder != lease.holder && now.Unix() < expires {
+		lease.leaderAddress.Store(secret.Spec.Labels[leaseAddressLabel])
+		return false, nil
+	}
+	spec := secret.Spec
+	spec.Labels = lease.labels(now)
+	if err := cli.SecretUpdate(ctx, secret.ID, secret.Version, spec); err != nil {
+		// Another instance updated it first
+		return false, nil
+	}
+	lease.leaderAddress.Store(lease.address)
+	return true, nil
+}
+
+// runLeaderElection tries to acquire the lease until this instance is the leader, then renews it while takeOver runs,
+// a slow take over not delaying the renewals. An instance that cannot renew its lease exits, to come back as a standby
+// instead of managing timers twice.
+func (lease *Lease) runLeaderElection(cli *client.Client, takeOver func()) {
+	interval := lease.duration / 3
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		acquired, err := lease.acquire(ctx, cli)
+		cancel()
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		if acquired && !isLeader() {
+			fmt.Printf("Instance %s is the leader\n", lease.holder)
+			atomic.StoreInt32(&leader, 1)
+			go takeOver()
+		} else if !acquired && isLeader() {
+			log.Fatal(fmt.Errorf("Instance %s lost the leadership", lease.holder))
+		}
+		time.Sleep(interval)
+	}
+}
+
+// forwardToLeader serves the requests on the leader, and forwards them to the leader on standby instances
+func (lease *Lease) forwardToLeader(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLeader() {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		address, _ := lease.leaderAddress.Load().(string)
+		target, err := url.Parse(address)
+		if address == "" || err != nil {
+			http.Error(w, "No leader elected yet", http.StatusServiceUnavailable)
+			return
+		}
+		httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	})
+}
+
+// takeOverServices restores the services handled by the previous leader and the timers of those still running,
+// which keep their persisted idle deadline
+func takeOverServices(cli *client.Client) error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for name, timeout := range state.Services {
+		service := GetOrCreateService(name, timeout)
+		status, err := service.getStatus(context.Background(), cli)
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			continue
+		}
+		if (status == UP || status == STARTING) && !service.isHandled {
+			fmt.Printf("- Service %v is taken over\n", service.name)
+			remaining := service.effectiveTimeout()
+			if deadline, ok := state.Deadlines[name]; ok && deadline <= now {
+				remaining = 0
+			} else if ok && uint64(deadline-now) < remaining {
+				remaining = uint64(deadline - now)
+			}
+			go service.stopAfterTimeout(cli)
+			service.time <- remaining
+		}
+	}
+	return nil
+}
+
+// saveHandledService persists a service handled by the leader, for another instance to take it over
+func saveHandledService(name string, timeout uint64) {
+	err := store.Update(func(state *State) {
+		state.Services[name] = timeout
+	})
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+}
+
+// saveIdleDeadline persists the idle deadline of a service handled by the leader
+func saveIdleDeadline(name string, deadline time.Time) {
+	err := store.Update(func(state *State) {
+		state.Deadlines[name] = deadline.Unix()
+	})
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+}
+
+// forgetHandledService removes a service forgotten by the leader from the persisted ones
+func forgetHandledService(name string) {
+	err := store.Update(func(state *State) {
+		delete(state.Services, name)
+		delete(state.Deadlines, name)
+	})
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+}
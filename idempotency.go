@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader identifies a mutating request, whose retries get the response of the first one
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentResponse is the response of the first request with a key, complete once done is closed
+type idempotentResponse struct {
+	request string
+	done    chan struct{}
+	expires time.Time
+
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+// responseRecorder records a response while writing it
+type responseRecorder struct {
+	http.ResponseWriter
+	response *idempotentResponse
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.response.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(content []byte) (int, error) {
+	recorder.response.body.Write(content)
+	return recorder.ResponseWriter.Write(content)
+}
+
+var idempotencyMutex sync.Mutex
+var idempotentResponses = map[string]*idempotentResponse{}
+
+// idempotencyKey returns the key of the request in the cache, scoped to its credentials for the callers not to share keys
+func idempotencyKey(r *http.Request, key string) string {
+	hash := sha256.Sum256([]byte(r.Header.Get("Authorization") + "\n" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+// claimIdempotencyKey returns the response recorded for the key, or a new one to record when the key is unknown or expired
+func claimIdempotencyKey(key string, request string, now time.Time) (*idempotentResponse, bool) {
+	idempotencyMutex.Lock()
+	defer idempotencyMutex.Unlock()
+	for cached, response := range idempotentResponses {
+		if now.After(response.expires) {
+			delete(idempotentResponses, cached)
+		}
+	}
+	if response, ok := idempotentResponses[key]; ok {
+		return response, true
+	}
+	response := &idempotentResponse{request: request, done: make(chan struct{}), expires: now.Add(idempotencyWindow.Get()), status: http.StatusOK}
+	idempotentResponses[key] = response
+	return response, false
+}
+
+// idempotent replays the response of the first mutating request with an Idempotency-Key header to its retries
+// within --idempotency-window, a retry sent while the first request is in progress waiting for its response
+func idempotent(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		request := r.Method + " " + r.URL.RequestURI()
+		cacheKey := idempotencyKey(r, key)
+		response, replay := claimIdempotencyKey(cacheKey, request, time.Now())
+		if !replay {
+			completed := false
+			defer func() {
+				if !completed {
+					// A request that panicked is forgotten, for its retries to be served
+					response.status = http.StatusInternalServerError
+					idempotencyMutex.Lock()
+					delete(idempotentResponses, cacheKey)
+					idempotencyMutex.Unlock()
+				}
+				close(response.done)
+			}()
+			recorder := &responseRecorder{ResponseWriter: w, response: response}
+			handler.ServeHTTP(recorder, r)
+			response.header = w.Header().Clone()
+			completed = true
+			return
+		}
+		if response.request != request {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("%s %s was already used for %s", idempotencyKeyHeader, key, response.request))
+			return
+		}
+		select {
+		case <-response.done:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Printf("- Request %s replayed for %s %s\n", request, idempotencyKeyHeader, key)
+		for name, values := range response.header {
+			if name != requestIDHeader {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(response.status)
+		w.Write(response.body.Bytes())
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// defaultLogsTail is how many lines of logs are returned by default
+const defaultLogsTail = "100"
+
+// flushWriter flushes every write, for the followed logs to reach the client as they are written
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (writer *flushWriter) Write(content []byte) (int, error) {
+	written, err := writer.writer.Write(content)
+	writer.flusher.Flush()
+	return written, err
+}
+
+// handleLogsAPI serves GET /api/services/{name}/logs, the last lines (tail query parameter) of the logs of the service,
+// streamed as they are written until the client goes away with follow=true
+func handleLogsAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	service := getService(name)
+	if service == nil {
+		service = &Service{name: name}
+	}
+	if isPattern(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("logs are not available for a pattern"))
+		return
+	}
+	dockerService, err := service.getDockerService(r.Context(), cli)
+	if _, notFound := err.(*NotFoundError); notFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tail := r.URL.Query().Get("tail")
+	if tail == "" {
+		tail = defaultLogsTail
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+	var output io.Writer = w
+	if follow {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+			return
+		}
+		output = &flushWriter{writer: w, flusher: flusher}
+	}
+	logs, err := cli.ServiceLogs(r.Context(), dockerService.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       tail,
+		Follow:     follow,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer logs.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if follow {
+		w.Header().Set("Cache-Control", "no-cache")
+		// Some proxies buffer the responses, which would hold back the followed logs
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		output.(*flushWriter).flusher.Flush()
+	}
+	if dockerService.Spec.TaskTemplate.ContainerSpec.TTY {
+		io.Copy(output, logs)
+		return
+	}
+	stdcopy.StdCopy(output, output, logs)
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types/swarm"
@@ -39,33 +45,480 @@ type Service struct {
 	timeout   uint64
 	time      chan uint64
 	isHandled bool
+	// dockerName is the name of the docker service the name of the service resolves to (alias, selector...)
+	dockerName string
+	// readyContainer is the last container that passed the readiness probe
+	readyContainer string
+	strategy       Strategy
+	// checkpointed and restored are the containers handled by the checkpoint strategy
+	checkpointed []string
+	restored     []string
+	// members are the services matched by a pattern name, with their last status
+	members map[string]*Service
+	status  Status
+	// parent is the service whose pattern matched this one
+	parent *Service
+	// lastActivity is the last sample of the usage of the containers of the service
+	lastActivity *activitySample
+	// deferredSince is when the stop of the service started being deferred by open connections
+	deferredSince time.Time
+	// sessions are the active sessions reported by the plugin, with their start time
+	sessions        map[string]time.Time
+	sessionsEndedAt time.Time
+	sessionsMutex   sync.Mutex
+	// startedAt and stoppedAt are when the service was last started and stopped by the scaler
+	startedAt time.Time
+	stoppedAt time.Time
+	// upAt is when the service was first seen up after its last start
+	upAt time.Time
+	// runtime is how long the service ran during the day beginning at runtimeDay, before its last stop
+	runtime    time.Duration
+	runtimeDay time.Time
+	// lastRequestAt is when the service was last requested
+	lastRequestAt time.Time
+	// proxyConnections is the number of open connections to the service through port proxies
+	proxyConnections int32
+	// coldStart is the trace of the current start of the service, until it is up
+	coldStart *Span
+	// idleDeadline is when the service is stopped if it is not requested again, unless its stop is deferred
+	idleDeadline time.Time
+	// pinned services are kept up until they are unpinned
+	pinned bool
+	// machine holds the state of the service as managed by the scaler, and its last transitions
+	machine StateMachine
+	// containerState is the docker state of the container of the service, e.g. restarting or dead
+	containerState string
+	// crashBackoff is the delay before the service can be started again after its last crash loop, backoffUntil its end
+	crashBackoff time.Duration
+	backoffUntil time.Time
+	// shadow services are only tracked, the scaler simulating their starts and stops
+	shadow bool
+	// lastError is the last unexpected error about the service
+	lastError string
+	// requestID is the correlation ID of the last request that woke the service up or reset its timeout
+	requestID string
+	// missing is true when the docker service does not exist and can be created from its definition
+	missing bool
+	// coalesced is the last result of handling the state of the service for a request, shared by coalesceMutex
+	coalesced     *coalescedResult
+	coalesceMutex sync.Mutex
+	// createdAt is when the scaler started tracking the service, for it to be forgotten when unused
+	createdAt time.Time
+	// sidecarUsers are the services up whose sidecar this service is, sidecarKeptUntil when it can be stopped after
+	// the last of them stopped
+	sidecarUsers     map[string]bool
+	sidecarKeptUntil time.Time
+	sidecarsMutex    sync.Mutex
+	// warmingSince is when the replacement container of a service with the warm strategy started being warmed
+	warmingSince time.Time
+	// flaps are the recent wake-ups soon after a stop, flapFactor the extension of the timeout until flapUntil
+	flaps      []time.Time
+	flapFactor int
+	flapUntil  time.Time
+	// coldStartTarget is the target duration of the current start of the service, slaBreachedAt the start whose
+	// breach was reported
+	coldStartTarget time.Duration
+	slaBreachedAt   time.Time
+	// timers is the number of loops stopping the service when idle
+	timers int32
+	// externalStartOf is the stop of the service after which it was started outside of the scaler, once reported
+	externalStartOf time.Time
+	// drained receives the result of the drain of the service, until drainDeadline
+	drained       chan string
+	drainingSince time.Time
+	drainDeadline time.Time
+	drainMutex    sync.Mutex
+	// scaledDownSince is when the rate of the wake requests of the autoscaled service dropped below its replicas
+	scaledDownSince time.Time
 }
 
 var services = map[string]*Service{}
+var servicesMutex sync.Mutex
+
+var experimentalCheckpoint = flag.Bool("experimental-checkpoint", false, "Enable the checkpoint strategy (requires an experimental docker daemon with CRIU)")
+var disablePull = runtimeBool("disable-pull", false, "Never pull images when waking services up, even with the ondemand.pull label")
+var definitionsPath = flag.String("definitions", "", "JSON file of the definitions of the services to create when they do not exist")
+var statePath = flag.String("state", "", "JSON file, or redis://host:port[/db][#key] or etcd://host:port[#key] URL, where the state (registered services) is persisted")
+var aliasesPath = flag.String("aliases", "", "JSON file mapping request names or hosts to service names")
+var traefikMetricsURL = flag.String("traefik-metrics", "", "URL of the traefik Prometheus metrics, to defer stopping services with open connections")
+var predictEnabled = flag.Bool("predict", false, "Record the usage of the services and wake them up before they are predicted to be requested")
+var predictLead = flag.Duration("predict-lead", 5*time.Minute, "How long before a predicted request a service is woken up")
+var maxRunning = runtimeInt("max-running", 0, "Maximum number of services started by the scaler running at the same time")
+var minFreeMemory = runtimeString("min-free-memory", "", "Memory (e.g. 512MB) that must remain available on the host to start a service")
+var maxLoad = runtimeFloat("max-load", 0, "Load average per CPU above which services are not started")
+var onExhausted = runtimeString("on-exhausted", REJECT, "What to do when resources are exhausted: reject, queue or evict (by priority)")
+var maxStarting = runtimeInt("max-starting", 0, "Maximum number of services starting at the same time, the others waiting in line")
+var ipRate = flag.Float64("ip-rate", 0, "Wake requests per second allowed for each client IP (0 for unlimited)")
+var ipBurst = flag.Int("ip-burst", 10, "Wake requests a client IP can send at once")
+var serviceRate = flag.Float64("service-rate", 0, "Wake requests per second allowed for each service (0 for unlimited)")
+var serviceBurst = flag.Int("service-burst", 50, "Wake requests a service can receive at once")
+var ignoreUserAgents = flag.String("ignore-user-agents", "", "Regular expression of the user agents whose requests do not wake services up (e.g. (?i)bot|crawler)")
+var ignorePaths = flag.String("ignore-paths", "", "Comma separated paths whose requests do not wake services up (e.g. /favicon.ico,/robots.txt)")
+var proxyListen = flag.String("proxy-listen", "", "Address (e.g. :8080) on which requests are proxied to the services serving their host, once woken up")
+var proxyTimeout = flag.Uint64("proxy-timeout", 300, "Timeout in seconds of the services woken up by proxied requests, unless registered with another one")
+var proxyWait = flag.Duration("proxy-wait", time.Minute, "How long a proxied request waits for its service to start")
+var portProxiesPath = flag.String("port-proxies", "", "JSON file of the ports on which connections are forwarded to services, woken up on the first connection")
+var haLease = flag.String("ha-lease", "", "Name of the docker secret used as leader lease, to run several instances of which only the leader manages the services")
+var haAddress = flag.String("ha-address", "", "URL (e.g. http://ondemand-1:10000) at which the other instances forward their requests to this one when it is the leader")
+var haLeaseDuration = flag.Duration("ha-lease-duration", 15*time.Second, "How long the leader lease lasts without being renewed")
+var agentListen = flag.String("agent", "", "Address (e.g. :10001) on which to run as an agent, exposing the container operations of the local docker socket to the controller")
+var agentAddresses = flag.String("agents", "", "Comma separated node=host:port addresses of the agents, node being a swarm node ID or hostname")
+var agentToken = flag.String("agent-token", "", "Token authenticating the controller to the agents, required with --agent")
+var agentCert = flag.String("agent-cert", "", "TLS certificate file of the agent, required for --agent to listen on all interfaces")
+var agentKey = flag.String("agent-key", "", "TLS key file of the agent")
+var agentCA = flag.String("agent-ca", "", "Certificate authority file of the agent certificates, to reach the agents over TLS")
+var otlpEndpoint = flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP endpoint (e.g. http://collector:4318) to which traces of the wake-ups are exported")
+var adminListen = flag.String("admin-listen", "", "Address (e.g. 127.0.0.1:10002) of the admin listener serving the debug endpoints")
+var adminToken = flag.String("admin-token", "", "Bearer token required on the admin listener")
+var adminAPI = flag.Bool("admin-api", false, "Serve the management API and the dashboard on the admin listener only, the public listener serving the wake requests, sessions and status of the services")
+var dockerTimeout = runtimeDuration("docker-timeout", 30*time.Second, "Timeout of the docker calls made to handle a request or to start or stop a service, 0 for none")
+var defaultCostPerHour = runtimeFloat("cost-per-hour", 0, "Cost per hour of a running service without the ondemand.cost.hour label, to estimate the money saved")
+var statsdAddress = flag.String("statsd", "", "Address (e.g. localhost:8125) of a statsd agent to which the metrics are sent")
+var statsdPrefix = flag.String("statsd-prefix", "ondemand.", "Prefix of the metrics sent to statsd")
+var dogstatsd = flag.Bool("dogstatsd", false, "Send the labels of the metrics as DogStatsD tags instead of appending them to the metric names")
+var dryRun = runtimeBool("dry-run", false, "Log and report the services that would be started and stopped, without starting nor stopping them")
+var hmacSecret = flag.String("hmac-secret", os.Getenv("ONDEMAND_HMAC_SECRET"), "Secret shared with the plugin, which must then sign its requests")
+var hmacMaxSkew = runtimeDuration("hmac-max-skew", 30*time.Second, "How old, or early, the timestamp of a signed request can be")
+var allowCIDRs = flag.String("allow-cidrs", "", "Comma separated CIDRs (e.g. 10.0.0.0/8,172.18.0.0/16) from which the requests are accepted, any when empty")
+var tokensPath = flag.String("tokens", "", "JSON file of the API tokens, each one scoped to some services and verbs")
+var oidcIssuer = flag.String("oidc-issuer", "", "URL of the OIDC provider whose JWTs authenticate the operators on the API, the dashboard and the admin listener")
+var oidcAudience = flag.String("oidc-audience", "", "Audience (client ID) the JWTs of the operators should be issued for")
+var oidcScope = flag.String("oidc-scope", fullScope, "Scope (status, start or full) of the operators authenticated by a JWT")
+var corsOrigins = flag.String("cors-origins", "", "Comma separated origins (e.g. https://status.example.com, * for any) allowed to call the API from a browser")
+var corsMethods = flag.String("cors-methods", "GET,POST,PUT,DELETE", "Comma separated methods the allowed origins can use")
+var idempotencyWindow = runtimeDuration("idempotency-window", 10*time.Minute, "How long the response of a request with an Idempotency-Key header is replayed to its retries")
+var defaultProfile = runtimeString("profile", "ondemand", "Response profile (ondemand, sablier, status or one of --profiles) of the wake requests that do not select one")
+var profilesPath = flag.String("profiles", "", "JSON file of additional response profiles by name")
+var coalesceInterval = runtimeDuration("coalesce", time.Second, "Interval during which the wake requests of a started service share the same result, 0 to handle each of them")
+var statusCacheTTL = runtimeDuration("status-cache", time.Second, "How long the status read from docker is reused for a service, 0 to read it for each request")
+var dockerConcurrency = flag.Int("docker-concurrency", 16, "Maximum number of services whose status is read, or which are started or stopped, at the same time, 0 for no limit")
+var mockPath = flag.String("mock", "", "JSON file of the services of the mock provider, used instead of the docker daemon to test a configuration")
+var gcAfter = runtimeDuration("gc-after", 24*time.Hour, "How long a service that is down stays unrequested before the scaler forgets it, 0 to never forget it")
+var maxServices = runtimeInt("max-services", 0, "Maximum number of services tracked by the scaler, the least recently used ones that are down being forgotten, 0 for no limit")
+var sidecarDelay = runtimeDuration("sidecar-delay", 10*time.Minute, "How long the sidecars of a service are kept up after it stops, without the ondemand.sidecars.delay label")
+var traefikProvider = flag.Bool("traefik-provider", false, "Serve the traefik dynamic configuration of the services with the ondemand.traefik.rule label on /api/traefik/config, for the traefik HTTP provider")
+var traefikPlugin = flag.String("traefik-plugin", "traefik-ondemand-plugin", "Name of the plugin in the static configuration of traefik, used by the generated middlewares")
+var traefikServiceURL = flag.String("traefik-service-url", "", "URL at which the generated middlewares reach the scaler, the one traefik polls the configuration from by default")
+var normalizeNames = flag.Bool("normalize-names", false, "Match the requested names case-insensitively, ignoring their leading slashes and underscores, when no service has the exact name")
+var refreshInterval = flag.Duration("refresh-interval", 30*time.Second, "Interval at which the status of the services is read from docker, for the changes made outside of the scaler to be reflected, 0 to never refresh it")
+var shutdownWarning = runtimeDuration("shutdown-warning", 2*time.Minute, "How long before the stop of a service its shutdown notice warns of it, for a countdown banner")
+var notifyURL = flag.String("notify-url", "", "URL to which notifications (e.g. crash loops, errors) are posted as JSON")
+var sentryDSN = flag.String("sentry-dsn", "", "DSN of the Sentry project to which unexpected errors are reported")
+var prepullAt = flag.String("prepull-at", "", "Time of day (HH:MM) at which the images of the managed services are pulled every day")
 
 func main() {
+	if runCommand(os.Args[1:]) || runBench(os.Args[1:]) {
+		return
+	}
+	flag.Parse()
+	if *agentListen != "" {
+		log.Fatal(serveAgent(*agentListen, *agentToken, *agentCert, *agentKey))
+	}
+	if *adminAPI && *adminListen == "" {
+		log.Fatal(fmt.Errorf("--admin-api requires --admin-listen"))
+	}
+	if *adminAPI && *tokensPath != "" && *adminToken == "" && *oidcIssuer == "" {
+		// The admin listener does not check the API tokens, the API would be open there
+		log.Fatal(fmt.Errorf("--admin-api with --tokens requires --admin-token or --oidc-issuer"))
+	}
+	if onExhausted.Get() != REJECT && onExhausted.Get() != QUEUE && onExhausted.Get() != EVICT {
+		log.Fatal(fmt.Errorf("--on-exhausted should be one of %s, %s, %s", REJECT, QUEUE, EVICT))
+	}
+	if *definitionsPath != "" {
+		loaded, err := loadDefinitions(*definitionsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		definitions = loaded
+	}
+	if *aliasesPath != "" {
+		loaded, err := loadAliases(*aliasesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		aliases = loaded
+	}
+	if *tokensPath != "" {
+		loaded, err := loadTokens(*tokensPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiTokens = loaded
+	}
+	if *oidcIssuer != "" {
+		verifier, err := newOIDCVerifier(*oidcIssuer, *oidcAudience, *oidcScope)
+		if err != nil {
+			log.Fatal(err)
+		}
+		oidcVerifier = verifier
+	}
+	corsPolicy = newCORS(*corsOrigins, *corsMethods)
+	dockerPool = newDockerPool(*dockerConcurrency)
+	if *profilesPath != "" {
+		if err := loadProfiles(*profilesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, ok := profiles[defaultProfile.Get()]; !ok {
+		log.Fatal(fmt.Errorf("--profile %s is not a response profile", defaultProfile.Get()))
+	}
+	if *ipRate > 0 {
+		ipRateLimiter = newRateLimiter(*ipRate, *ipBurst)
+	}
+	if *serviceRate > 0 {
+		serviceRateLimiter = newRateLimiter(*serviceRate, *serviceBurst)
+	}
+	if *ignoreUserAgents != "" {
+		pattern, err := regexp.Compile(*ignoreUserAgents)
+		if err != nil {
+			log.Fatal(fmt.Errorf("--ignore-user-agents is not a valid regular expression: %v", err))
+		}
+		ignoredUserAgents = pattern
+	}
+	ignoredPaths = parseIgnoredPaths(*ignorePaths)
+	networks, err := parseCIDRs(*allowCIDRs)
+	if err != nil {
+		log.Fatal(fmt.Errorf("--allow-cidrs is not valid: %v", err))
+	}
+	allowedNetworks = networks
+	if *otlpEndpoint != "" {
+		tracer = newTracer(*otlpEndpoint)
+	}
+	if *sentryDSN != "" {
+		reporter, err := newSentry(*sentryDSN)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sentry = reporter
+	}
+	if *statsdAddress != "" {
+		emitter, err := newStatsd(*statsdAddress, *statsdPrefix, *dogstatsd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		statsd = emitter
+	}
+	backend, err := parseStateBackend(*statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store.backend = backend
+	if _, file := backend.(*fileBackend); backend != nil && !file && *haLease == "" {
+		// Each instance would otherwise run its own timers, reading the shared state only at startup
+		fmt.Println("State shared without --ha-lease: the instances do not share the timers nor the registrations made after they started")
+	}
+	if err := loadRegistrations(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadUsage(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	var cli *client.Client
+	if *mockPath != "" {
+		mock, err := loadMock(*mockPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cli, err = mock.serve(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Mock provider serving %d services.\n", len(mock.services))
+	} else if cli, err = client.NewEnvClient(); err != nil {
+		log.Fatal(fmt.Errorf("%+v", "Could not connect to docker API"))
+	}
+	detectDaemonOS(cli)
+	if *agentAddresses != "" {
+		if agents, err = parseAgents(*agentAddresses, *agentToken, *agentCA, cli.ClientVersion()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var proxies []*PortProxy
+	if *portProxiesPath != "" {
+		if proxies, err = loadPortProxies(*portProxiesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var lease *Lease
+	if *haLease != "" {
+		lease = &Lease{name: *haLease, holder: instanceID(), address: *haAddress, duration: *haLeaseDuration}
+		go lease.runLeaderElection(cli, func() {
+			// The state persisted by the previous leader is restored before taking over its services
+			if err := loadRegistrations(); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+			if err := loadUsage(); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+			if err := takeOverServices(cli); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+			startBackgroundJobs(cli)
+			serveProxies(cli, proxies)
+		})
+	} else {
+		startBackgroundJobs(cli)
+		serveProxies(cli, proxies)
+	}
 	fmt.Println("Server listening on port 10000.")
-	http.HandleFunc("/", handleRequests())
-	log.Fatal(http.ListenAndServe(":10000", nil))
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/api/openapi.json", handleOpenAPI)
+	http.HandleFunc("/api/audit", handleAuditAPI)
+	http.HandleFunc("/api/events", handleEventsAPI(cli))
+	http.HandleFunc("/api/status", handleStatusListAPI(cli))
+	http.HandleFunc("/api/stats", handleStatsAPI(cli))
+	http.HandleFunc("/api/activity", handleActivityAPI)
+	http.HandleFunc("/api/activity/", handleActivityAPI)
+	http.HandleFunc("/api/state/", handleStateAPI(cli))
+	http.HandleFunc("/api/config", handleConfigAPI)
+	http.HandleFunc("/dashboard", handleDashboard)
+	if *traefikProvider {
+		http.HandleFunc("/api/traefik/config", handleTraefikConfigAPI(cli))
+	}
+	http.HandleFunc("/api/services", handleServicesAPI(cli))
+	http.HandleFunc("/api/services/", handleServicesAPI(cli))
+	http.HandleFunc("/", handleRequests(cli))
+	var handler http.Handler = http.DefaultServeMux
+	if lease != nil {
+		handler = lease.forwardToLeader(handler)
+	}
+	if *adminAPI {
+		adminMux.Handle("/api/", allowCORS(corsPolicy, idempotent(handler)))
+		adminMux.Handle("/dashboard", handler)
+		handler = publicEndpoints(handler)
+	}
+	if *adminListen != "" {
+		go func() {
+			log.Fatal(serveAdmin(*adminListen, *adminToken))
+		}()
+	}
+	handler = logRequests(recoverPanics(requireNetworks(allowedNetworks, allowCORS(corsPolicy, requireScopes(idempotent(handler))))))
+	log.Fatal(http.ListenAndServe(":10000", handler))
 }
 
-func handleRequests() func(w http.ResponseWriter, r *http.Request) {
-	cli, err := client.NewEnvClient()
-	if err != nil {
-		log.Fatal(fmt.Errorf("%+v", "Could not connect to docker API"))
+// serveProxies listens for the --proxy requests and the port proxies connections. With --ha-lease, only the leader
+// listens, the traffic it proxies keeping the services it manages up
+func serveProxies(cli *client.Client, proxies []*PortProxy) {
+	if *proxyListen != "" {
+		go func() {
+			fmt.Printf("Proxy listening on %s.\n", *proxyListen)
+			log.Fatal(http.ListenAndServe(*proxyListen, logRequests(recoverPanics(http.HandlerFunc(handleProxy(cli, *proxyTimeout, *proxyWait))))))
+		}()
+	}
+	for _, proxy := range proxies {
+		go func(proxy *PortProxy) {
+			log.Fatal(proxy.serve(cli, *proxyWait))
+		}(proxy)
+	}
+}
+
+func startBackgroundJobs(cli *client.Client) {
+	if statusCacheTTL.Get() > 0 {
+		go watchContainerEvents(cli)
+	}
+	if *experimentalCheckpoint {
+		checkpointSupported = detectCheckpointSupport(cli)
+	}
+	if *prepullAt != "" {
+		clock, err := parseClock(*prepullAt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go prepullImages(cli, clock)
+	}
+	go runSchedules(cli)
+	go runServicesCollection()
+	if *hmacSecret != "" {
+		go runReplaySweeps()
 	}
+	go runAutoscaler(cli)
+	go runActivityMetrics()
+	if *refreshInterval > 0 {
+		go runRefresh(cli)
+	}
+	if *predictEnabled {
+		go runPredictions(cli, *predictLead)
+	}
+}
+
+func handleRequests(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if err := verifySignature(r, signedName(r)); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		profile, err := requestProfile(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 		serviceName, serviceTimeout, err := parseParams(r)
 		if err != nil {
 			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		if rateLimited(w, r, serviceName) {
+			return
+		}
+		if isIgnored(r) {
+			// Ignored requests only get the status, without waking the service up nor resetting its timeout
+			service := GetOrCreateService(serviceName, serviceTimeout)
+			status, err := service.getStatus(r.Context(), cli)
+			logRequest(r, serviceName, "ignored")
+			if err != nil {
+				fmt.Fprintf(w, "%+v", err)
+			} else if status == UP {
+				writeWakeResponse(w, profile, service, "started")
+			} else {
+				writeWakeResponse(w, profile, service, "starting")
+			}
+			return
 		}
+		span := startRemoteSpan(r, "wake request", "service", serviceName, "request_id", requestID(r))
+		defer span.End()
 		service := GetOrCreateService(serviceName, serviceTimeout)
-		status, err := service.HandleServiceState(cli)
+		service.lastRequestAt = time.Now()
+		recordWakeRequest(service.name, service.lastRequestAt)
+		service.requestID = requestID(r)
+		if *predictEnabled {
+			recordUsage(service.name, time.Now())
+		}
+		status, err := service.handleCoalesced(r.Context(), cli)
+		span.SetAttribute("response", status)
+		span.SetError(err)
+		logRequest(r, service.name, status)
+		if position := startQueue.position(service); position > 0 {
+			w.Header().Set("X-Queue-Position", strconv.Itoa(position))
+		}
+		if _, notFound := err.(*NotFoundError); notFound {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if asleep, ok := err.(*AsleepError); ok {
+			writeAsleep(w, asleep)
+			return
+		}
+		if _, unknown := err.(*UnknownStatusError); unknown {
+			fmt.Printf("Error: %+v\n ", err)
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "%s", UNKNOWN)
+			return
+		}
+		if isTimeout(err) {
+			fmt.Printf("Error: %+v\n ", err)
+			w.WriteHeader(http.StatusGatewayTimeout)
+			fmt.Fprintf(w, "%s", timeoutResponse)
+			return
+		}
 		if err != nil {
 			fmt.Printf("Error: %+v\n ", err)
 			fmt.Fprintf(w, "%+v", err)
+			fmt.Fprintf(w, "%+s", status)
+			return
 		}
-		fmt.Fprintf(w, "%+s", status)
+		writeWakeResponse(w, profile, service, status)
 	}
 }
 
@@ -81,12 +534,17 @@ func parseParams(r *http.Request) (string, uint64, error) {
 
 	serviceName, err := getParam(queryParams, "name")
 	if err != nil {
-		return "", 0, nil
+		// Without a name, the service is the one serving the host of the request
+		serviceName = requestHost(r)
 	}
+	serviceName = requestedService(r, serviceName)
 
 	timeoutString, err := getParam(queryParams, "timeout")
 	if err != nil {
-		return "", 0, nil
+		if registration := getRegistration(serviceName); registration != nil && registration.Timeout > 0 {
+			return serviceName, registration.Timeout, nil
+		}
+		return "", 0, err
 	}
 	serviceTimeout, err := strconv.Atoi(timeoutString)
 	if err != nil {
@@ -95,108 +553,409 @@ func parseParams(r *http.Request) (string, uint64, error) {
 	return serviceName, uint64(serviceTimeout), nil
 }
 
+// getService returns an existing service or nil
+func getService(name string) *Service {
+	servicesMutex.Lock()
+	defer servicesMutex.Unlock()
+	return services[name]
+}
+
 // GetOrCreateService return an existing service or create one
 func GetOrCreateService(name string, timeout uint64) *Service {
+	servicesMutex.Lock()
+	defer servicesMutex.Unlock()
 	if services[name] != nil {
 		return services[name]
 	}
-	service := &Service{name, timeout, make(chan uint64), false}
+	service := &Service{
+		name:      name,
+		timeout:   timeout,
+		time:      make(chan uint64, 1),
+		createdAt: time.Now(),
+	}
 
 	services[name] = service
+	boundServices(service.createdAt)
+	// Only the leader persists the services it handles, the standby instances not handling any
+	if *haLease != "" && isLeader() {
+		go saveHandledService(name, timeout)
+	}
 	return service
 }
 
 // HandleServiceState up the service if down or set timeout for downing the service
-func (service *Service) HandleServiceState(cli *client.Client) (string, error) {
-	status, err := service.getStatus(cli)
+func (service *Service) HandleServiceState(ctx context.Context, cli *client.Client) (string, error) {
+	status, err := service.getKnownStatus(ctx, cli)
+	// Requests cancelled by their client are not failures
+	if _, notFound := err.(*NotFoundError); err != nil && !notFound && ctx.Err() == nil {
+		reportError(service, err, "")
+	}
 	if err != nil {
 		return "", err
 	}
 	if status == UP {
 		fmt.Printf("- Service %v is up\n", service.name)
+		service.cancelDrain()
+		if service.isRunning() && !service.upAt.After(service.startedAt) {
+			service.upAt = time.Now()
+			recordColdStart(service.name, service.upAt.Sub(service.startedAt))
+			service.checkColdStartSLA(service.upAt.Sub(service.startedAt), true)
+		}
+		service.coldStart.End()
+		service.coldStart = nil
+		service.resetBackoff()
+		startQueue.release(service, cli)
 		if !service.isHandled {
 			go service.stopAfterTimeout(cli)
 		}
 		select {
-		case service.time <- service.timeout:
+		case service.time <- service.effectiveTimeout():
 		default:
 		}
 		return "started", nil
 	} else if status == STARTING {
 		fmt.Printf("- Service %v is starting\n", service.name)
-		if err != nil {
+		if err := service.isDead(); err != nil {
+			startQueue.release(service, cli)
+			service.breachColdStartSLA(slaFailed, err.Error())
 			return "", err
 		}
-		go service.stopAfterTimeout(cli)
+		if service.isCrashLooping(ctx, cli) {
+			startQueue.release(service, cli)
+			service.breachColdStartSLA(slaFailed, "crash loop")
+			return "", service.isBackingOff()
+		}
+		if service.isRunning() && !service.upAt.After(service.startedAt) {
+			service.checkColdStartSLA(time.Since(service.startedAt), false)
+		}
+		if !service.isHandled {
+			go service.stopAfterTimeout(cli)
+		}
+		select {
+		case service.time <- service.effectiveTimeout():
+		default:
+		}
 		return "starting", nil
 	} else if status == DOWN {
 		fmt.Printf("- Service %v is down\n", service.name)
-		service.start(cli)
-		return "starting", nil
-	} else {
-		fmt.Printf("- Service %v status is unknown\n", service.name)
-		if err != nil {
+		if service.shadow {
+			// Shadow services run all the time: their requests are never held back, nor do they hold back the others
+			service.start(cli)
+			return "started", nil
+		}
+		if err := service.isBackingOff(); err != nil {
+			return "", err
+		}
+		if service.isCoolingDown(cli) {
+			return "starting", nil
+		}
+		if err := service.isAsleep(cli, time.Now()); err != nil {
 			return "", err
 		}
-		return service.HandleServiceState(cli)
+		if err := service.hasExhaustedBudget(cli); err != nil {
+			return "", err
+		}
+		if admitted, response := service.admit(cli); !admitted {
+			return response, nil
+		}
+		if acquired, _ := startQueue.acquire(service); !acquired {
+			return "starting", nil
+		}
+		service.start(cli)
+		return "starting", nil
+	}
+	return "", &UnknownStatusError{name: service.name, attempts: unknownAttempts}
+}
+
+// getStatus returns the status of the service reported by docker, moving its state machine accordingly
+func (service *Service) getStatus(ctx context.Context, client *client.Client) (Status, error) {
+	status, err := service.readStatus(ctx, client)
+	status = service.simulatedStatus(status)
+	if err == nil {
+		service.observe(status)
+	}
+	return status, err
+}
+
+// readStatus returns the status of the service, as read from docker less than --status-cache ago
+func (service *Service) readStatus(ctx context.Context, client *client.Client) (Status, error) {
+	now := time.Now()
+	if status, ok := getCachedStatus(service.name, now); ok {
+		return status, nil
 	}
+	status, err := service.readDockerStatus(ctx, client)
+	// The status of a pattern is the one of its members, which are cached themselves
+	if err == nil && !isPattern(service.name) {
+		cacheStatus(service.name, service.dockerName, status, now)
+	}
+	return status, err
 }
 
-func (service *Service) getStatus(client *client.Client) (Status, error) {
-	ctx := context.Background()
+func (service *Service) readDockerStatus(ctx context.Context, client *client.Client) (Status, error) {
+	ctx, cancel := dockerContext(ctx)
+	defer cancel()
+	if isPattern(service.name) {
+		return service.getMembersStatus(ctx, client)
+	}
+	release, err := dockerPool.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
 	dockerService, err := service.getDockerService(ctx, client)
 
+	if _, notFound := err.(*NotFoundError); notFound && (getDefinition(service.name) != nil || getRemovedSpec(service.name) != nil) {
+		service.missing = true
+		service.dockerName = ""
+		return DOWN, nil
+	}
 	if err != nil {
 		return "", err
 	}
+	service.missing = false
+	service.dockerName = dockerService.Spec.Name
 
-	if *dockerService.Spec.Mode.Replicated.Replicas == zeroReplica {
+	strategy, err := parseStrategy(service.labels(dockerService))
+	if err != nil {
+		return "", err
+	}
+	service.strategy = strategy
+	service.shadow = service.labels(dockerService)[shadowLabel] == "true"
+
+	if dockerService.Spec.Mode.Replicated == nil || dockerService.Spec.Mode.Replicated.Replicas == nil {
+		// Global services run a task on every node and cannot be scaled
+		return "", fmt.Errorf("service %s is not in replicated mode", service.name)
+	}
+	if service.strategy == WARM && service.isWarming() {
+		return DOWN, nil
+	}
+	if service.strategy == CHECKPOINT && len(service.checkpointed) > 0 {
+		return DOWN, nil
+	}
+	// The containers restored from a checkpoint run outside of swarm, the service staying scaled down
+	scaledDown := *dockerService.Spec.Mode.Replicated.Replicas == zeroReplica
+	containerIDs := []string{}
+	if !scaledDown {
+		if containerIDs, err = getRunningContainers(ctx, client, dockerService); err != nil {
+			return "", err
+		}
+	}
+	// The nodes of the removed containers are forgotten, but those of the checkpointed and restored ones
+	evictContainerNodes(dockerService.ID, append(append(append([]string{}, containerIDs...), service.checkpointed...), service.restored...))
+	if scaledDown && (service.strategy != CHECKPOINT || len(service.restored) == 0) {
 		return DOWN, nil
 	}
+	if service.strategy == CHECKPOINT {
+		restored, err := service.getRestoredContainers(ctx, client)
+		if err != nil {
+			return "", err
+		}
+		containerIDs = append(containerIDs, restored...)
+	}
+	if len(containerIDs) == 0 && scaledDown {
+		return DOWN, nil
+	}
+	if len(containerIDs) == 0 {
+		// Created, restarting or exited containers are being started by swarm, dead ones make the service fail
+		state, err := getContainerState(ctx, client, dockerService)
+		if err != nil {
+			return "", err
+		}
+		service.containerState = state
+		return STARTING, nil
+	}
+	paused, err := getPausedContainers(ctx, client, containerIDs)
+	if err != nil {
+		return "", err
+	}
+	if len(paused) == len(containerIDs) {
+		service.containerState = containerPaused
+		return DOWN, nil
+	}
+	service.containerState = containerRunning
+	ready, err := service.isReady(ctx, client, dockerService, containerIDs)
+	if err != nil {
+		return "", err
+	}
+	if !ready {
+		return STARTING, nil
+	}
 	return UP, nil
 }
 
 func (service *Service) start(client *client.Client) {
 	fmt.Printf("Starting service %s\n", service.name)
+	audit(service.name, "start", service.simulationReason(), service.requestID)
 	service.isHandled = true
-	service.setServiceReplicas(client, 1)
+	service.startedAt = time.Now()
+	service.recordFlap(service.startedAt)
+	service.readColdStartTarget(client)
+	recordWakeUp(service.name, service.startedAt)
+	updateRunningServices()
+	service.transition(STARTING, "requested")
+	service.coldStart = startSpan(nil, "cold start", "service", service.name, "strategy", string(service.strategy))
+	service.wakeSidecars(client)
+	span := service.span("wake")
+	err := service.simulate("start", service.wake)(client)
+	invalidateStatus(service.name)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		service.transition(FAILED, err.Error())
+		reportError(service, fmt.Errorf("could not start service %s: %v", service.name, err), "")
+		service.breachColdStartSLA(slaFailed, err.Error())
+	}
+	go service.enforceBudget(client, service.startedAt)
 	go service.stopAfterTimeout(client)
-	service.time <- service.timeout
+	// A pending timeout is enough, the reconcile loop restarting the service with ENFORCE must not block on it
+	select {
+	case service.time <- service.effectiveTimeout():
+	default:
+	}
 }
 
+// deferredStopInterval is the delay between two checks of an idle service whose stop is deferred
+const deferredStopInterval = 10 * time.Second
+
 func (service *Service) stopAfterTimeout(client *client.Client) {
+	// The refresh loop adopts the service again if its timer panics, as a service started outside of the scaler
+	defer reportPanic(service, "the timer of service "+service.name)
+	handledSince := time.Now()
 	service.isHandled = true
+	atomic.AddInt32(&service.timers, 1)
+	defer atomic.AddInt32(&service.timers, -1)
+	service.lastActivity = nil
+	service.isActive(client)
 	for {
 		select {
 		case timeout, ok := <-service.time:
 			if ok {
+				service.setIdleDeadline(time.Duration(timeout) * time.Second)
 				time.Sleep(time.Duration(timeout) * time.Second)
 			} else {
 				fmt.Println("That should not happen, but we never know ;)")
 			}
 		default:
-			fmt.Printf("Stopping service %s\n", service.name)
-			service.setServiceReplicas(client, 0)
+			if service.stoppedAt.After(handledSince) {
+				// The service was stopped meanwhile (by its budget, an eviction or the API)
+				return
+			}
+			if service.isActive(client) {
+				timeout := time.Duration(service.effectiveTimeout()) * time.Second
+				service.setIdleDeadline(timeout)
+				time.Sleep(timeout)
+				continue
+			}
+			if service.pinned || service.isWithinMinUptime(client) || service.hasOpenConnections(client) || service.hasProxyConnections() || service.hasActiveSessions() || service.isUsedAsSidecar() || service.inSchedule(client) {
+				time.Sleep(deferredStopInterval)
+				continue
+			}
+			if service.drain(client) {
+				continue
+			}
+			if service.stoppedAt.After(handledSince) {
+				// The service was stopped while draining
+				return
+			}
+			service.shutdown(client, "idle")
 			return
 		}
 	}
 }
 
+// setIdleDeadline sets when the service is stopped if it is not requested again, persisting it with --ha-lease
+// for the next leader to take the timer over
+func (service *Service) setIdleDeadline(timeout time.Duration) {
+	service.idleDeadline = time.Now().Add(timeout)
+	if *haLease != "" {
+		go saveIdleDeadline(service.name, service.idleDeadline)
+	}
+}
+
+// shutdown stops the service and records its running time
+func (service *Service) shutdown(client *client.Client, reason string) {
+	service.shutdownWith(client, reason, service.stop)
+}
+
+// shutdownWith puts the service down with stop instead of its strategy
+func (service *Service) shutdownWith(client *client.Client, reason string, stop func(client *client.Client) error) {
+	fmt.Printf("Stopping service %s\n", service.name)
+	if simulation := service.simulationReason(); simulation != "" {
+		audit(service.name, "stop", reason+", "+simulation, "")
+	} else {
+		audit(service.name, "stop", reason, "")
+	}
+	service.transition(STOPPING, reason)
+	service.coldStart.End()
+	service.coldStart = nil
+	// The requests following the stop should not be answered started
+	service.coalesceMutex.Lock()
+	service.coalesced = nil
+	service.coalesceMutex.Unlock()
+	span := startSpan(nil, "stop", "service", service.name, "strategy", string(service.strategy))
+	err := service.simulate("stop", stop)(client)
+	invalidateStatus(service.name)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		service.transition(STOPFAILED, err.Error())
+		reportError(service, fmt.Errorf("could not stop service %s: %v", service.name, err), "")
+		service.scheduleStopRetry(client, reason, stop, err)
+	} else {
+		clearStopRetry(service.name)
+		service.transition(DOWN, reason)
+		service.releaseSidecars(client)
+	}
+	now := time.Now()
+	service.recordRuntime(now)
+	if service.isRunning() {
+		recordRunning(service.name, now.Sub(service.startedAt))
+	}
+	service.stoppedAt = now
+	updateRunningServices()
+}
+
 func (service *Service) setServiceReplicas(client *client.Client, replicas uint64) error {
-	ctx := context.Background()
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
 	dockerService, err := service.getDockerService(ctx, client)
 	if err != nil {
 		return err
 	}
+	if replicas == zeroReplica {
+		if err := service.prepareStop(ctx, client, dockerService); err != nil {
+			return err
+		}
+	} else {
+		if err := service.applyOverrides(&dockerService.Spec, service.labels(dockerService)); err != nil {
+			return err
+		}
+		if service.labels(dockerService)[pullLabel] == "true" && !disablePull.Get() {
+			// A failed pull should not prevent waking the service up with its current image
+			span := service.span("pull")
+			err := pullLatest(ctx, client, &dockerService.Spec)
+			span.SetError(err)
+			span.End()
+			if err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+		}
+	}
+	span := service.span("scale", "replicas", strconv.FormatUint(replicas, 10))
+	defer span.End()
 	dockerService.Spec.Mode.Replicated = &swarm.ReplicatedService{
 		Replicas: getPointer(replicas),
 	}
-	client.ServiceUpdate(ctx, dockerService.ID, dockerService.Meta.Version, dockerService.Spec, types.ServiceUpdateOptions{})
-	return nil
+	_, err = client.ServiceUpdate(ctx, dockerService.ID, dockerService.Meta.Version, dockerService.Spec, types.ServiceUpdateOptions{})
+	return err
 
 }
 
 func (service *Service) getDockerService(ctx context.Context, client *client.Client) (*swarm.Service, error) {
+	ctx, cancel := dockerContext(ctx)
+	defer cancel()
 	filterOPt := opts.NewFilterOpt()
 	listOpts := types.ServiceListOptions{
 		Filters: filterOPt.Value(),
@@ -207,7 +966,15 @@ func (service *Service) getDockerService(ctx context.Context, client *client.Cli
 		return nil, err
 	}
 
+	if *normalizeNames {
+		warnNormalizedConflicts(services)
+	}
 	dockerService, err := findService(services, service.name)
+	if _, notFound := err.(*NotFoundError); notFound {
+		if containerService := findContainerService(ctx, client, services, service.name); containerService != nil {
+			return containerService, nil
+		}
+	}
 
 	if err != nil {
 		return nil, err
@@ -216,13 +983,66 @@ func (service *Service) getDockerService(ctx context.Context, client *client.Cli
 	return dockerService, nil
 }
 
+// findService finds a docker service by name, by one of the names of its ondemand.name label, by its name in its stack,
+// by its compose project and service (e.g. myproject/web), by its normalized name with --normalize-names or by a label
+// selector (e.g. com.docker.compose.service=whoami)
 func findService(services []swarm.Service, name string) (*swarm.Service, error) {
+	if selector := parseSelector(name); selector != nil {
+		matches := []swarm.Service{}
+		for _, service := range services {
+			if matchesSelector(service, selector) {
+				matches = append(matches, service)
+			}
+		}
+		if len(matches) > 1 {
+			return &swarm.Service{}, fmt.Errorf("%s matches %d services", name, len(matches))
+		}
+		if len(matches) == 1 {
+			return &matches[0], nil
+		}
+		return &swarm.Service{}, &NotFoundError{name: name}
+	}
 	for _, service := range services {
 		if name == service.Spec.Name {
 			return &service, nil
 		}
 	}
-	return &swarm.Service{}, fmt.Errorf("Could not find service %s", name)
+	for _, service := range services {
+		if hasAlias(service, name) {
+			return &service, nil
+		}
+	}
+	if stackService, err := findStackService(services, name); err != nil || stackService != nil {
+		return stackService, err
+	}
+	if projectService, err := findProjectMember(services, name); err != nil || projectService != nil {
+		return projectService, err
+	}
+	if *normalizeNames {
+		if normalizedService, err := findNormalizedService(services, name); err != nil || normalizedService != nil {
+			return normalizedService, err
+		}
+	}
+	return &swarm.Service{}, scopeSuggestions(&NotFoundError{name: name, suggestions: suggestNames(services, name), project: findProjectService(services, name)})
+}
+
+// NotFoundError is returned when there is no docker service with the requested name
+type NotFoundError struct {
+	name string
+	// suggestions are the names of the services close to the requested name
+	suggestions []string
+	// project is the name of the service deployed under the requested name by a stack or a compose project
+	project string
+}
+
+func (err *NotFoundError) Error() string {
+	if err.project != "" {
+		return fmt.Sprintf("Could not find service %s, it is deployed as %s", err.name, err.project)
+	}
+	if len(err.suggestions) > 0 {
+		return fmt.Sprintf("Could not find service %s, did you mean %s?", err.name, strings.Join(err.suggestions, ", "))
+	}
+	return fmt.Sprintf("Could not find service %s", err.name)
 }
 
 func getPointer(x uint64) *uint64 {
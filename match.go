@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// Label used on the docker service to give it additional comma separated names
+const nameLabel = "ondemand.name"
+
+// projectSeparator separates the compose project, or the stack, of a service from its name in it (e.g. myproject/web)
+const projectSeparator = "/"
+
+// parseSelector parses a label selector (key=value[,key=value...]), returning nil when name is not a selector
+func parseSelector(name string) map[string]string {
+	if !strings.Contains(name, "=") {
+		return nil
+	}
+	selector := map[string]string{}
+	for _, requirement := range strings.Split(name, ",") {
+		parts := strings.SplitN(requirement, "=", 2)
+		if len(parts) != 2 {
+			return nil
+		}
+		selector[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return selector
+}
+
+func matchesSelector(service swarm.Service, selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := service.Spec.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAlias(service swarm.Service, name string) bool {
+	aliases, ok := service.Spec.Labels[nameLabel]
+	if !ok {
+		return false
+	}
+	for _, alias := range strings.Split(aliases, ",") {
+		if strings.TrimSpace(alias) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// findStackService returns the service deployed as name by a stack, named <namespace>_<name> and labelled with its
+// namespace, nil when there is none and an error when several stacks deploy it
+func findStackService(services []swarm.Service, name string) (*swarm.Service, error) {
+	matches := []swarm.Service{}
+	for _, service := range services {
+		namespace, ok := service.Spec.Labels[stackNamespaceLabel]
+		if ok && service.Spec.Name == namespace+"_"+name {
+			matches = append(matches, service)
+		}
+	}
+	if len(matches) > 1 {
+		names := []string{}
+		for _, match := range matches {
+			names = append(names, match.Spec.Name)
+		}
+		return nil, fmt.Errorf("%s is deployed by several stacks: %s", name, strings.Join(names, ", "))
+	}
+	if len(matches) == 1 {
+		return &matches[0], nil
+	}
+	return nil, nil
+}
+
+// findProjectMember returns the service named project/name, the name service of the compose project, from the compose
+// labels, or of the stack. The generated names of compose (e.g. myproject-web-1 or myproject_web_1) change between
+// its versions, unlike these labels
+func findProjectMember(services []swarm.Service, name string) (*swarm.Service, error) {
+	parts := strings.SplitN(name, projectSeparator, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, nil
+	}
+	project, member := parts[0], parts[1]
+	matches := []swarm.Service{}
+	for _, service := range services {
+		labels := service.Spec.Labels
+		if (labels[composeProjectLabel] == project && labels[composeServiceLabel] == member) ||
+			(labels[stackNamespaceLabel] == project && service.Spec.Name == project+"_"+member) {
+			matches = append(matches, service)
+		}
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("%s matches %d services", name, len(matches))
+	}
+	if len(matches) == 1 {
+		return &matches[0], nil
+	}
+	return nil, nil
+}
+
+// warnedConflicts are the normalized names shared by several services that were already logged
+var warnedConflicts = map[string]bool{}
+var warnedConflictsMutex sync.Mutex
+
+// normalizeName returns the name as compared with --normalize-names: in lower case, without leading slashes nor
+// underscores
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimLeft(name, "/_"))
+}
+
+// serviceNames returns the name of the service and the names of its ondemand.name label
+func serviceNames(service swarm.Service) []string {
+	names := []string{service.Spec.Name}
+	for _, alias := range strings.Split(service.Spec.Labels[nameLabel], ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			names = append(names, alias)
+		}
+	}
+	return names
+}
+
+// findNormalizedService returns the service with a name equal to name once both are normalized, nil when there is none
+// and an error when several services have it
+func findNormalizedService(services []swarm.Service, name string) (*swarm.Service, error) {
+	normalized := normalizeName(name)
+	matches := []swarm.Service{}
+	for _, service := range services {
+		for _, serviceName := range serviceNames(service) {
+			if normalizeName(serviceName) == normalized {
+				matches = append(matches, service)
+				break
+			}
+		}
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("%s matches %d services once normalized", name, len(matches))
+	}
+	if len(matches) == 1 {
+		return &matches[0], nil
+	}
+	return nil, nil
+}
+
+// warnNormalizedConflicts logs, once, the names that several services share once normalized, for which the requests
+// get an error
+func warnNormalizedConflicts(services []swarm.Service) {
+	owners := map[string][]string{}
+	for _, service := range services {
+		seen := map[string]bool{}
+		for _, name := range serviceNames(service) {
+			if normalized := normalizeName(name); !seen[normalized] {
+				seen[normalized] = true
+				owners[normalized] = append(owners[normalized], service.Spec.Name)
+			}
+		}
+	}
+	warnedConflictsMutex.Lock()
+	defer warnedConflictsMutex.Unlock()
+	for normalized, names := range owners {
+		if len(names) < 2 || warnedConflicts[normalized] {
+			continue
+		}
+		warnedConflicts[normalized] = true
+		sort.Strings(names)
+		fmt.Printf("- Services %s have the same normalized name %s, which is ambiguous\n", strings.Join(names, ", "), normalized)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metrics holds the metrics exposed on /metrics in the Prometheus text format
+type Metrics struct {
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	kind   string
+	help   string
+	values map[string]float64
+}
+
+var metrics = &Metrics{families: map[string]*metricFamily{}}
+
+// Register declares a metric, kind being counter or gauge
+func (metrics *Metrics) Register(name string, kind string, help string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.families[name] = &metricFamily{kind: kind, help: help, values: map[string]float64{}}
+}
+
+// Add adds value to a metric, labels being name/value pairs, and sends it to statsd as a count
+func (metrics *Metrics) Add(name string, value float64, labels ...string) {
+	statsd.send(name, value, "c", labels)
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.families[name].values[formatLabels(labels)] += value
+}
+
+// Set sets the value of a metric, labels being name/value pairs, and sends it to statsd as a gauge
+func (metrics *Metrics) Set(name string, value float64, labels ...string) {
+	statsd.send(name, value, "g", labels)
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.families[name].values[formatLabels(labels)] = value
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Write writes every metric in the Prometheus text format
+func (metrics *Metrics) Write(w io.Writer) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	names := []string{}
+	for name := range metrics.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := metrics.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, family.kind)
+		labels := []string{}
+		for label := range family.values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(w, "%s%s %g\n", name, label, family.values[label])
+		}
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(w)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// mockRestartDelay is the delay before a failed task is replaced, the default restart delay of swarm
+const mockRestartDelay = 5 * time.Second
+
+// mockHistory is the number of past tasks kept for each service, as the task history of swarm
+const mockHistory = 5
+
+// MockService configures a service of the mock provider
+type MockService struct {
+	Labels   map[string]string `json:"labels,omitempty"`
+	Replicas uint64            `json:"replicas,omitempty"`
+	// Startup is how long the containers take to start once the service is scaled up (e.g. 2s)
+	Startup string `json:"startup,omitempty"`
+	// FailureRate is the probability (0 to 1) that a container exits while starting, swarm replacing it
+	FailureRate float64 `json:"failureRate,omitempty"`
+	// Healthy is how long the started containers report a starting healthcheck before being healthy, the task
+	// only running once they are, no healthcheck when empty
+	Healthy string `json:"healthy,omitempty"`
+	// Unhealthy is how long the containers stay healthy before becoming unhealthy and being killed, never when empty
+	Unhealthy string `json:"unhealthy,omitempty"`
+
+	startup, healthy, unhealthy time.Duration
+}
+
+// mockTask is a task of a mock service, whose state follows from its creation time and its configuration
+type mockTask struct {
+	task      swarm.Task
+	startedAt time.Time
+	runningAt time.Time
+	failsAt   time.Time
+	paused    bool
+	// checkpoint is the ID of the checkpoint of the container, if any
+	checkpoint string
+}
+
+type mockService struct {
+	service swarm.Service
+	config  *MockService
+	slots   []*mockTask
+	history []swarm.Task
+}
+
+// MockDocker implements the docker API calls the scaler makes to read the status of the services and to scale them,
+// simulating the start delays, failures and health transitions of their containers
+type MockDocker struct {
+	mutex    sync.Mutex
+	services map[string]*mockService
+	tasks    int
+	calls    map[string]int
+}
+
+var mockVersionRegexp = regexp.MustCompile(`^/v[0-9.]+`)
+
+// loadMock returns a mock provider serving the services of a JSON file
+func loadMock(path string) (*MockDocker, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]*MockService{}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	for name, config := range loaded {
+		for _, duration := range []struct {
+			value  string
+			parsed *time.Duration
+		}{{config.Startup, &config.startup}, {config.Healthy, &config.healthy}, {config.Unhealthy, &config.unhealthy}} {
+			if duration.value == "" {
+				continue
+			}
+			if *duration.parsed, err = time.ParseDuration(duration.value); err != nil || *duration.parsed < 0 {
+				return nil, fmt.Errorf("service %s: %s is not a valid duration", name, duration.value)
+			}
+		}
+		if config.FailureRate < 0 || config.FailureRate > 1 {
+			return nil, fmt.Errorf("service %s: failureRate should be between 0 and 1", name)
+		}
+	}
+	return newMockDocker(loaded), nil
+}
+
+func newMockDocker(configs map[string]*MockService) *MockDocker {
+	docker := &MockDocker{services: map[string]*mockService{}, calls: map[string]int{}}
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for i, name := range names {
+		config := configs[name]
+		service := &mockService{config: config}
+		service.service.ID = fmt.Sprintf("mock%d", i)
+		service.service.Spec.Name = name
+		service.service.Spec.Labels = config.Labels
+		service.service.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: getPointer(0)}
+		docker.services[service.service.ID] = service
+		docker.scale(service, config.Replicas, now)
+		// The services initially up are already started and healthy
+		for _, task := range service.slots {
+			task.startedAt, task.runningAt, task.failsAt = now, now, time.Time{}
+			if config.unhealthy > 0 {
+				task.failsAt = now.Add(config.unhealthy)
+			}
+		}
+	}
+	return docker
+}
+
+// serve serves the mock provider on a loopback port, returning a docker client calling it
+func (docker *MockDocker) serve() (*client.Client, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, docker)
+	return client.NewClient("tcp://"+listener.Addr().String(), client.DefaultVersion, nil, nil)
+}
+
+// newTask creates the task of a slot of the service, drawing whether its container fails
+func (docker *MockDocker) newTask(service *mockService, slot int, now time.Time) *mockTask {
+	docker.tasks++
+	config := service.config
+	task := &mockTask{startedAt: now.Add(config.startup)}
+	task.runningAt = task.startedAt.Add(config.healthy)
+	if rand.Float64() < config.FailureRate {
+		task.failsAt = task.startedAt
+	} else if config.unhealthy > 0 {
+		task.failsAt = task.runningAt.Add(config.unhealthy)
+	}
+	task.task = swarm.Task{
+		ID:           fmt.Sprintf("%s.%d.%d", service.service.Spec.Name, slot+1, docker.tasks),
+		ServiceID:    service.service.ID,
+		Slot:         slot + 1,
+		DesiredState: swarm.TaskStateRunning,
+	}
+	id := sha256.Sum256([]byte(task.task.ID))
+	task.task.Status.ContainerStatus.ContainerID = hex.EncodeToString(id[:])
+	task.task.Status.Timestamp = now
+	return task
+}
+
+// scale sets the number of tasks of the service, shutting down those of the removed slots
+func (docker *MockDocker) scale(service *mockService, replicas uint64, now time.Time) {
+	service.service.Spec.Mode.Replicated.Replicas = getPointer(replicas)
+	for uint64(len(service.slots)) > replicas {
+		last := len(service.slots) - 1
+		docker.retire(service, service.slots[last], now)
+		service.slots = service.slots[:last]
+	}
+	for uint64(len(service.slots)) < replicas {
+		service.slots = append(service.slots, docker.newTask(service, len(service.slots), now))
+	}
+}
+
+// retire moves a task to the history of the service, shut down unless it failed
+func (docker *MockDocker) retire(service *mockService, task *mockTask, now time.Time) {
+	retired := task.view(now)
+	retired.DesiredState = swarm.TaskStateShutdown
+	if retired.Status.State != swarm.TaskStateFailed {
+		retired.Status.State = swarm.TaskStateShutdown
+		retired.Status.Timestamp = now
+	}
+	service.history = append(service.history, retired)
+	if len(service.history) > mockHistory {
+		service.history = service.history[len(service.history)-mockHistory:]
+	}
+}
+
+// advance replaces the tasks that failed more than the restart delay ago, as swarm does
+func (docker *MockDocker) advance(service *mockService, now time.Time) {
+	for slot, task := range service.slots {
+		for !task.failsAt.IsZero() && !now.Before(task.failsAt.Add(mockRestartDelay)) {
+			restartAt := task.failsAt.Add(mockRestartDelay)
+			docker.retire(service, task, restartAt)
+			task = docker.newTask(service, slot, restartAt)
+			service.slots[slot] = task
+		}
+	}
+}
+
+// view returns the task as reported by docker at now
+func (task *mockTask) view(now time.Time) swarm.Task {
+	view := task.task
+	switch {
+	case now.Before(task.startedAt):
+		view.Status.State = swarm.TaskStateStarting
+		view.Status.ContainerStatus.ContainerID = ""
+	case !task.failsAt.IsZero() && !now.Before(task.failsAt):
+		view.Status.State = swarm.TaskStateFailed
+		view.Status.Timestamp = task.failsAt
+		view.Status.Err = "task: non-zero exit (1)"
+		if task.failsAt.After(task.startedAt) {
+			view.Status.Err = "task: non-zero exit (137): dockerexec: unhealthy container"
+		}
+	case now.Before(task.runningAt):
+		// Swarm keeps the tasks whose container has a healthcheck starting until it is healthy
+		view.Status.State = swarm.TaskStateStarting
+		view.Status.Timestamp = task.startedAt
+	default:
+		view.Status.State = swarm.TaskStateRunning
+		view.Status.Timestamp = task.runningAt
+	}
+	return view
+}
+
+// container returns the inspection of the container of the task, false when it is not started or exited
+func (task *mockTask) container(service *mockService, now time.Time) (types.ContainerJSON, bool) {
+	config := service.config
+	if now.Before(task.startedAt) || (!task.failsAt.IsZero() && !now.Before(task.failsAt)) {
+		return types.ContainerJSON{}, false
+	}
+	state := &types.ContainerState{
+		Status:    containerRunning,
+		Running:   true,
+		StartedAt: task.startedAt.Format(time.RFC3339Nano),
+	}
+	if task.paused {
+		state.Status, state.Paused = containerPaused, true
+	}
+	if config.healthy > 0 {
+		state.Health = &types.Health{Status: "healthy"}
+		if now.Before(task.runningAt) {
+			state.Health.Status = "starting"
+		}
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: task.task.Status.ContainerStatus.ContainerID, State: state},
+		Config:            &container.Config{Labels: map[string]string{serviceIDLabel: service.service.ID}},
+	}, true
+}
+
+func (docker *MockDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := mockVersionRegexp.ReplaceAllString(r.URL.Path, "")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if r.Method == http.MethodGet && path == "/events" {
+		// No events are sent, the status cache expiring by itself
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return
+	}
+	docker.mutex.Lock()
+	defer docker.mutex.Unlock()
+	docker.calls[r.Method+" /"+segments[0]]++
+	now := time.Now()
+	switch {
+	case r.Method == http.MethodGet && path == "/info":
+		json.NewEncoder(w).Encode(types.Info{OSType: "linux", Name: "mock"})
+	case r.Method == http.MethodGet && path == "/services":
+		list := []swarm.Service{}
+		for _, service := range docker.services {
+			list = append(list, service.service)
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPost && len(segments) == 3 && segments[0] == "services" && segments[2] == "update":
+		service, ok := docker.services[segments[1]]
+		spec := swarm.ServiceSpec{}
+		if !ok {
+			docker.fail(w, http.StatusNotFound, "service "+segments[1]+" not found")
+			return
+		}
+		if json.NewDecoder(r.Body).Decode(&spec) != nil || spec.Mode.Replicated == nil || spec.Mode.Replicated.Replicas == nil {
+			docker.fail(w, http.StatusBadRequest, "invalid update of service "+segments[1])
+			return
+		}
+		docker.advance(service, now)
+		if spec.TaskTemplate.ForceUpdate != service.service.Spec.TaskTemplate.ForceUpdate {
+			// A forced update replaces all the tasks
+			docker.scale(service, 0, now)
+		}
+		service.service.Version.Index++
+		service.service.Spec = spec
+		docker.scale(service, *spec.Mode.Replicated.Replicas, now)
+		json.NewEncoder(w).Encode(types.ServiceUpdateResponse{})
+	case r.Method == http.MethodGet && path == "/tasks":
+		arguments, _ := filters.FromParam(r.URL.Query().Get("filters"))
+		tasks := []swarm.Task{}
+		for _, id := range arguments.Get("service") {
+			service, ok := docker.services[id]
+			if !ok {
+				continue
+			}
+			docker.advance(service, now)
+			for _, task := range service.slots {
+				tasks = append(tasks, task.view(now))
+			}
+			if len(arguments.Get("desired-state")) == 0 {
+				tasks = append(tasks, service.history...)
+			}
+		}
+		json.NewEncoder(w).Encode(tasks)
+	case r.Method == http.MethodGet && len(segments) == 3 && segments[0] == "containers" && segments[2] == "json":
+		for _, service := range docker.services {
+			docker.advance(service, now)
+			for _, task := range service.slots {
+				// Docker finds the containers by a prefix of their ID
+				if !strings.HasPrefix(task.task.Status.ContainerStatus.ContainerID, segments[1]) {
+					continue
+				}
+				if container, ok := task.container(service, now); ok {
+					json.NewEncoder(w).Encode(container)
+					return
+				}
+			}
+		}
+		docker.fail(w, http.StatusNotFound, "No such container: "+segments[1])
+	case r.Method == http.MethodPost && len(segments) == 3 && segments[0] == "containers" && (segments[2] == "pause" || segments[2] == "unpause"):
+		for _, service := range docker.services {
+			docker.advance(service, now)
+			for _, task := range service.slots {
+				if task.task.Status.ContainerStatus.ContainerID != segments[1] {
+					continue
+				}
+				if _, ok := task.container(service, now); !ok {
+					docker.fail(w, http.StatusConflict, "Container "+segments[1]+" is not running")
+					return
+				}
+				task.paused = segments[2] == "pause"
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		docker.fail(w, http.StatusNotFound, "No such container: "+segments[1])
+	case r.Method == http.MethodPost && len(segments) == 3 && segments[0] == "containers" && segments[2] == "checkpoints":
+		options := types.CheckpointCreateOptions{}
+		if json.NewDecoder(r.Body).Decode(&options) != nil || options.CheckpointID == "" {
+			docker.fail(w, http.StatusBadRequest, "invalid checkpoint of container "+segments[1])
+			return
+		}
+		for _, service := range docker.services {
+			docker.advance(service, now)
+			for _, task := range service.slots {
+				if task.task.Status.ContainerStatus.ContainerID != segments[1] {
+					continue
+				}
+				if _, ok := task.container(service, now); !ok {
+					docker.fail(w, http.StatusConflict, "Container "+segments[1]+" is not running")
+					return
+				}
+				task.checkpoint = options.CheckpointID
+				if options.Exit {
+					// The container exits, swarm replacing its task as a failed one unless the service is scaled down
+					task.failsAt = now
+				}
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		docker.fail(w, http.StatusNotFound, "No such container: "+segments[1])
+	case r.Method == http.MethodDelete && len(segments) == 4 && segments[0] == "containers" && segments[2] == "checkpoints":
+		for _, service := range docker.services {
+			for _, task := range service.slots {
+				if task.task.Status.ContainerStatus.ContainerID == segments[1] && task.checkpoint == segments[3] {
+					task.checkpoint = ""
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
+		docker.fail(w, http.StatusNotFound, "No such checkpoint: "+segments[3])
+	default:
+		docker.fail(w, http.StatusNotImplemented, "not implemented by the mock provider: "+r.Method+" "+path)
+	}
+}
+
+func (docker *MockDocker) fail(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// namespaceSeparator separates a namespace from the names of its services, as docker stacks do (e.g. team-a_whoami)
+const namespaceSeparator = "_"
+
+type tokenKey struct{}
+
+// withToken returns the request carrying the token it was authorized with
+func withToken(r *http.Request, token *APIToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenKey{}, token))
+}
+
+// requestToken returns the token the request was authorized with, nil when the API is open
+func requestToken(r *http.Request) *APIToken {
+	token, _ := r.Context().Value(tokenKey{}).(*APIToken)
+	return token
+}
+
+// requestNamespace returns the namespace of the token of the request, empty when it is not bound to one
+func requestNamespace(r *http.Request) string {
+	if token := requestToken(r); token != nil {
+		return token.Namespace
+	}
+	return ""
+}
+
+// qualify returns the name of the service in the namespace, a name already in the namespace being kept as is
+func qualify(namespace string, name string) string {
+	if namespace == "" || name == "" || inNamespace(namespace, name) {
+		return name
+	}
+	return namespace + namespaceSeparator + name
+}
+
+// inNamespace reports whether the service belongs to the namespace, every service belonging to the empty one
+func inNamespace(namespace string, name string) bool {
+	return namespace == "" || strings.HasPrefix(name, namespace+namespaceSeparator)
+}
+
+// requestedService returns the service named in a request, once qualified in the namespace of its token and resolved
+func requestedService(r *http.Request, name string) string {
+	return resolveName(qualify(requestNamespace(r), name))
+}
+
+// relatedService returns a service named by another one (e.g. its dependencies and sidecars), qualified and resolved
+// in the namespace of that service, for a tenant not to start the services of the others
+func relatedService(service string, name string) string {
+	return resolveName(qualify(namespaceOf(service), name))
+}
+
+// namespaceOf returns the namespace of the tokens the service belongs to, empty when it belongs to none
+func namespaceOf(name string) string {
+	for _, token := range apiTokens {
+		if token.Namespace != "" && inNamespace(token.Namespace, name) {
+			return token.Namespace
+		}
+	}
+	return ""
+}
+
+// scopeSuggestions keeps the suggestions and the project of a service not found in its namespace,
+// for a tenant not to learn about the services of the others
+func scopeSuggestions(err *NotFoundError) *NotFoundError {
+	namespace := namespaceOf(err.name)
+	if namespace == "" {
+		return err
+	}
+	suggestions := []string{}
+	for _, suggestion := range err.suggestions {
+		if inNamespace(namespace, suggestion) {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	err.suggestions = suggestions
+	if !inNamespace(namespace, err.project) {
+		err.project = ""
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Notification is posted as JSON to the notification webhook
+type Notification struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Service string    `json:"service"`
+	Message string    `json:"message"`
+	// RequestID is the correlation ID of the request that led to the event
+	RequestID string `json:"requestId,omitempty"`
+	// Audit holds the last actions taken on the service, for errors
+	Audit []AuditEvent `json:"audit,omitempty"`
+}
+
+// notifyTimeout bounds the delivery of a notification
+const notifyTimeout = 10 * time.Second
+
+// notify posts a notification to the --notify-url webhook, in the background
+func notify(event string, service *Service, message string) {
+	if *notifyURL == "" {
+		return
+	}
+	notification := Notification{Time: time.Now(), Event: event, Service: service.name, Message: message, RequestID: service.requestID}
+	go func() {
+		if err := postNotification(*notifyURL, notification); err != nil {
+			fmt.Printf("Error: could not notify %s of service %s: %+v\n ", event, service.name, err)
+		}
+	}()
+}
+
+func postNotification(url string, notification Notification) error {
+	content, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s answered %d", url, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcTimeout bounds the discovery and JWKS requests to the provider
+const oidcTimeout = 10 * time.Second
+
+// jwksRefreshInterval is how often the keys can be fetched again, when a token is signed with an unknown key
+const jwksRefreshInterval = time.Minute
+
+// clockSkew is the leeway given on the expiration and not before times of the tokens
+const clockSkew = time.Minute
+
+// OIDCVerifier validates the JWTs issued by an OIDC provider for an audience, with the keys the provider publishes
+type OIDCVerifier struct {
+	issuer   string
+	audience string
+	scope    string
+
+	mutex     sync.Mutex
+	jwksURI   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// oidcVerifier validates the JWTs of the operators, nil when --oidc-issuer is not set
+var oidcVerifier *OIDCVerifier
+
+func newOIDCVerifier(issuer string, audience string, scope string) (*OIDCVerifier, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("--oidc-audience is required with --oidc-issuer")
+	}
+	if scopeLevels[scope] == 0 {
+		return nil, fmt.Errorf("--oidc-scope should be one of %s, %s, %s", statusScope, startScope, fullScope)
+	}
+	return &OIDCVerifier{issuer: strings.TrimRight(issuer, "/"), audience: audience, scope: scope}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Email     string          `json:"email"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+// hasAudience reports whether the aud claim, a string or an array of strings, contains the audience
+func (claims *jwtClaims) hasAudience(audience string) bool {
+	single := ""
+	if json.Unmarshal(claims.Audience, &single) == nil {
+		return single == audience
+	}
+	list := []string{}
+	if json.Unmarshal(claims.Audience, &list) == nil {
+		for _, value := range list {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, value)
+}
+
+// verify returns the claims of a valid token: signed by a key of the provider, by its issuer, for the audience and not expired
+func (verifier *OIDCVerifier) verify(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	key, err := verifier.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("token algorithm %s is not supported", header.Alg)
+	}
+
+	claims := &jwtClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	now := time.Now()
+	if strings.TrimRight(claims.Issuer, "/") != verifier.issuer {
+		return nil, fmt.Errorf("token issued by %s", claims.Issuer)
+	}
+	if !claims.hasAudience(verifier.audience) {
+		return nil, fmt.Errorf("token is not for %s", verifier.audience)
+	}
+	if claims.ExpiresAt == 0 || now.Add(-clockSkew).After(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+// key returns the key of the provider with the ID, fetching the keys again when it is unknown (e.g. after a rotation)
+func (verifier *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+	if key, ok := verifier.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(verifier.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown token key %s", kid)
+	}
+	verifier.fetchedAt = time.Now()
+	keys, err := verifier.fetchKeys(ctx)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return nil, fmt.Errorf("could not fetch the keys of %s", verifier.issuer)
+	}
+	verifier.keys = keys
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown token key %s", kid)
+}
+
+func getJSON(ctx context.Context, url string, value interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, oidcTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", url, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys discovers the JWKS of the provider, once, and returns its RSA and P-256 signing keys by ID
+func (verifier *OIDCVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if verifier.jwksURI == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := getJSON(ctx, verifier.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("%s does not publish a jwks_uri", verifier.issuer)
+		}
+		verifier.jwksURI = discovery.JWKSURI
+	}
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := getJSON(ctx, verifier.jwksURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch {
+		case jwk.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// operatorToken returns the token of an operator authenticated by a valid JWT, nil otherwise
+func (verifier *OIDCVerifier) operatorToken(r *http.Request) *APIToken {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.Count(bearer, ".") != 2 {
+		return nil
+	}
+	claims, err := verifier.verify(r.Context(), bearer)
+	if err != nil {
+		fmt.Printf("- Token rejected: %v\n", err)
+		return nil
+	}
+	name := claims.Email
+	if name == "" {
+		name = claims.Subject
+	}
+	return &APIToken{Name: name, Scope: verifier.scope}
+}
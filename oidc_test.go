@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeSegment(t *testing.T, value interface{}) string {
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// signToken returns a JWT with the header and claims, signed with the RSA or P-256 key
+func signToken(t *testing.T, header jwtHeader, claims map[string]interface{}, key crypto.Signer) string {
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := newOIDCVerifier("https://issuer.example.com/", "ondemand", startScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The keys were just fetched, so that an unknown key is not fetched from the issuer
+	verifier.keys = map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	verifier.fetchedAt = time.Now()
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		values := map[string]interface{}{
+			"iss":   "https://issuer.example.com",
+			"sub":   "42",
+			"email": "ops@example.com",
+			"aud":   "ondemand",
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+		for key, value := range changes {
+			if value == nil {
+				delete(values, key)
+			} else {
+				values[key] = value
+			}
+		}
+		return values
+	}
+	rs256 := jwtHeader{Alg: "RS256", Kid: "rsa"}
+	valid := signToken(t, rs256, claims(nil), rsaKey)
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + encodeSegment(t, claims(map[string]interface{}{"email": "admin@example.com"})) + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"valid RS256", valid, ""},
+		{"valid ES256", signToken(t, jwtHeader{Alg: "ES256", Kid: "ec"}, claims(nil), ecKey), ""},
+		{"audience in a list", signToken(t, rs256, claims(map[string]interface{}{"aud": []string{"other", "ondemand"}}), rsaKey), ""},
+		{"expired within the clock skew", signToken(t, rs256, claims(map[string]interface{}{"exp": now.Add(-clockSkew / 2).Unix()}), rsaKey), ""},
+		{"malformed", "not.a-token", "malformed token"},
+		{"unsupported algorithm", signToken(t, jwtHeader{Alg: "HS256", Kid: "rsa"}, claims(nil), rsaKey), "token algorithm HS256 is not supported"},
+		{"algorithm of another key type", signToken(t, jwtHeader{Alg: "ES256", Kid: "rsa"}, claims(nil), ecKey), "invalid token signature"},
+		{"unknown key", signToken(t, jwtHeader{Alg: "RS256", Kid: "rotated"}, claims(nil), rsaKey), "unknown token key rotated"},
+		{"signed by another key", signToken(t, jwtHeader{Alg: "ES256", Kid: "ec"}, claims(nil), mustECKey(t)), "invalid token signature"},
+		{"tampered payload", tampered, "invalid token signature"},
+		{"expired", signToken(t, rs256, claims(map[string]interface{}{"exp": now.Add(-2 * clockSkew).Unix()}), rsaKey), "token expired"},
+		{"without expiration", signToken(t, rs256, claims(map[string]interface{}{"exp": nil}), rsaKey), "token expired"},
+		{"not valid yet", signToken(t, rs256, claims(map[string]interface{}{"nbf": now.Add(2 * clockSkew).Unix()}), rsaKey), "token not valid yet"},
+		{"wrong issuer", signToken(t, rs256, claims(map[string]interface{}{"iss": "https://evil.example.com"}), rsaKey), "token issued by https://evil.example.com"},
+		{"wrong audience", signToken(t, rs256, claims(map[string]interface{}{"aud": "other"}), rsaKey), "token is not for ondemand"},
+		{"wrong audience in a list", signToken(t, rs256, claims(map[string]interface{}{"aud": []string{"other"}}), rsaKey), "token is not for ondemand"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := verifier.verify(context.Background(), test.token)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected a valid token, got %v", err)
+				}
+				if verified.Email != "ops@example.com" {
+					t.Errorf("expected the claims of the token, got %+v", verified)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
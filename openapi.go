@@ -0,0 +1,877 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3 specification of the API, the Go client of the apiclient package implementing it
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "traefik-ondemand-service",
+    "description": "Scales docker swarm services up and down on demand",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/": {
+      "get": {
+        "operationId": "wake",
+        "summary": "Wakes the service up if needed and resets its timeout",
+        "parameters": [
+          {"name": "name", "in": "query", "description": "Service name, alias, label selector or pattern, the host of the request when omitted", "schema": {"type": "string"}},
+          {"name": "timeout", "in": "query", "description": "Idle timeout in seconds, the registered one when omitted", "schema": {"type": "integer", "minimum": 0}},
+          {"name": "profile", "in": "query", "description": "Response profile (also given by the X-Ondemand-Profile header) whose words replace started, starting and exhausted, --profile when omitted", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/Timestamp"},
+          {"$ref": "#/components/parameters/Signature"}
+        ],
+        "responses": {
+          "200": {
+            "description": "started or exhausted",
+            "content": {"text/plain": {"schema": {"type": "string", "enum": ["started", "exhausted"]}}}
+          },
+          "202": {
+            "description": "starting",
+            "headers": {
+              "X-Queue-Position": {"description": "Position of the service in line to start", "schema": {"type": "integer"}},
+              "Retry-After": {"description": "Expected seconds until the service is started, from its average cold start", "schema": {"type": "integer"}},
+              "Location": {"description": "Status of the service", "schema": {"type": "string"}}
+            },
+            "content": {"text/plain": {"schema": {"type": "string", "enum": ["starting"]}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "429": {"description": "Too many requests", "headers": {"Retry-After": {"schema": {"type": "integer"}}}},
+          "504": {"description": "The docker calls timed out", "content": {"text/plain": {"schema": {"type": "string", "enum": ["timeout"]}}}}
+        }
+      }
+    },
+    "/api/services": {
+      "get": {
+        "operationId": "listRegistrations",
+        "summary": "Lists the registered services",
+        "responses": {"200": {"description": "Registered services", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Registration"}}}}}}
+      },
+      "post": {
+        "operationId": "register",
+        "summary": "Registers a service, replacing its previous registration",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Registration"}}}},
+        "responses": {
+          "201": {"description": "Registered service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Registration"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/batch": {
+      "post": {
+        "operationId": "batch",
+        "summary": "Reports the status of several services, or wakes them up in dependency order",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Batch"}}}},
+        "responses": {
+          "200": {"description": "Results, in dependency order for a start", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/BatchResult"}}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "delete": {
+        "operationId": "deregister",
+        "summary": "Deregisters a service",
+        "responses": {"204": {"description": "Deregistered"}, "404": {"$ref": "#/components/responses/Error"}}
+      }
+    },
+    "/api/services/{name}/status": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getStatus",
+        "summary": "Reports the status of the service without waking it up",
+        "responses": {
+          "200": {"description": "Status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/wait": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "wait",
+        "summary": "Waits until the service is up, without waking it up",
+        "parameters": [{"name": "timeout", "in": "query", "description": "How long to wait, 1m by default and 5m at most", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "The service is up", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Wait"}}}},
+          "202": {
+            "description": "The service is still not up after the timeout",
+            "headers": {
+              "Retry-After": {"description": "Expected seconds until the service is started, from its average cold start", "schema": {"type": "integer"}},
+              "Location": {"description": "Status of the service", "schema": {"type": "string"}}
+            },
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Wait"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/start": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "post": {
+        "operationId": "start",
+        "summary": "Wakes the service up if needed and resets its timeout",
+        "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}, {"name": "timeout", "in": "query", "description": "Idle timeout in seconds, the registered or last one when omitted", "schema": {"type": "integer", "minimum": 0}}],
+        "responses": {
+          "200": {"description": "Started", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}},
+          "202": {
+            "description": "Starting",
+            "headers": {
+              "Retry-After": {"description": "Expected seconds until the service is started, from its average cold start", "schema": {"type": "integer"}},
+              "Location": {"description": "Status of the service", "schema": {"type": "string"}}
+            },
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/stop": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "post": {
+        "operationId": "stop",
+        "summary": "Stops the service without waiting for its timeout",
+        "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}],
+        "responses": {
+          "200": {"description": "Stopped", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/pin": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "put": {
+        "operationId": "pin",
+        "summary": "Keeps the service up until it is unpinned",
+        "parameters": [{"name": "timeout", "in": "query", "description": "Idle timeout in seconds once unpinned", "schema": {"type": "integer", "minimum": 0}}],
+        "responses": {"200": {"description": "Pinned", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}}}
+      },
+      "delete": {
+        "operationId": "unpin",
+        "summary": "Lets the service be stopped when idle again",
+        "responses": {"200": {"description": "Unpinned", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}}}
+      }
+    },
+    "/api/services/{name}/override": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getOverride",
+        "summary": "Returns the environment and command override of the next wake-up of the service",
+        "responses": {"200": {"description": "Override", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Override"}}}}}
+      },
+      "put": {
+        "operationId": "setOverride",
+        "summary": "Sets the environment and command override of the next wake-up of the service",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Override"}}}},
+        "responses": {
+          "200": {"description": "Override", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Override"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "clearOverride",
+        "summary": "Cancels the override of the next wake-up of the service",
+        "responses": {"204": {"description": "Cancelled"}}
+      }
+    },
+    "/api/services/{name}/config": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getServiceConfig",
+        "summary": "Returns the configuration of the service that can be changed at runtime",
+        "responses": {"200": {"description": "Configuration", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceConfig"}}}}}
+      },
+      "patch": {
+        "operationId": "updateServiceConfig",
+        "summary": "Changes the timeout, labels (an empty value removing a label) or pin of the service, persisted in its registration",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceConfig"}}}},
+        "responses": {
+          "200": {"description": "Configuration", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceConfig"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/config": {
+      "get": {
+        "operationId": "getConfig",
+        "summary": "Returns the flags that can be changed at runtime, by name",
+        "responses": {"200": {"description": "Flags", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}}}
+      },
+      "patch": {
+        "operationId": "updateConfig",
+        "summary": "Changes flags at runtime, persisted in the state to take precedence over the command line after a restart",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}},
+        "responses": {
+          "200": {"description": "Flags", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/status": {
+      "get": {
+        "operationId": "listStatus",
+        "summary": "Reports the live state of the requested and registered services",
+        "responses": {"200": {"description": "Services", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ServiceSnapshot"}}}}}}
+      }
+    },
+    "/api/services/{name}/logs": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getLogs",
+        "summary": "Returns the last lines of the logs of the service, and streams the next ones with follow",
+        "parameters": [
+          {"name": "tail", "in": "query", "description": "Number of lines, 100 by default", "schema": {"type": "string"}},
+          {"name": "follow", "in": "query", "description": "Keep streaming the logs as they are written, until the client goes away", "schema": {"type": "boolean"}}
+        ],
+        "responses": {
+          "200": {"description": "Logs", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/events": {
+      "get": {
+        "operationId": "streamEvents",
+        "summary": "Streams the live state of the services as server-sent services events",
+        "responses": {"200": {"description": "Stream of arrays of ServiceSnapshot", "content": {"text/event-stream": {"schema": {"type": "string"}}}}}
+      }
+    },
+    "/api/services/{name}/transitions": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getTransitions",
+        "summary": "Reports the state of the service and its last transitions",
+        "responses": {
+          "200": {"description": "Transitions", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Transitions"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/restart": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "post": {
+        "operationId": "restart",
+        "summary": "Replaces the containers of the service and waits until it is started again",
+        "parameters": [
+          {"$ref": "#/components/parameters/IdempotencyKey"},
+          {"name": "timeout", "in": "query", "description": "Idle timeout in seconds, the registered or last one when omitted", "schema": {"type": "integer", "minimum": 0}},
+          {"name": "wait", "in": "query", "description": "How long to wait for the service to be ready (e.g. 30s), --proxy-wait by default", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Started", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/redeploy": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "post": {
+        "operationId": "redeploy",
+        "summary": "Replaces the containers of the service by new ones from its spec, from the latest image of its tag with pull",
+        "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}, {"name": "pull", "in": "query", "description": "Pull the latest image of the tag first", "schema": {"type": "boolean"}}],
+        "responses": {
+          "200": {
+            "description": "Redeployed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "name": {"type": "string"},
+                    "image": {"type": "string"},
+                    "previousImage": {"type": "string"},
+                    "pulled": {"type": "boolean"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/details": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getDetails",
+        "summary": "Describes the docker service, its running containers and the state of the scaler about it",
+        "responses": {
+          "200": {"description": "Details", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Details"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/budget": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getBudget",
+        "summary": "Reports the runtime budget of the service",
+        "responses": {
+          "200": {"description": "Budget", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Budget"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/predictions": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getPredictions",
+        "summary": "Lists the predicted requests of the service",
+        "responses": {"200": {"description": "Predictions", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Prediction"}}}}}}
+      },
+      "put": {
+        "operationId": "overridePredictions",
+        "summary": "Replaces the predictions computed from the usage of the service",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PredictionOverride"}}}},
+        "responses": {
+          "200": {"description": "Predictions", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Prediction"}}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "resetPredictions",
+        "summary": "Removes the override of the predictions",
+        "responses": {"200": {"description": "Predictions", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Prediction"}}}}}}
+      }
+    },
+    "/api/services/{name}/sessions": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "post": {
+        "operationId": "startSession",
+        "summary": "Starts a session deferring the stop of the service",
+        "parameters": [{"$ref": "#/components/parameters/Timestamp"}, {"$ref": "#/components/parameters/Signature"}],
+        "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Session"}}}},
+        "responses": {
+          "201": {"description": "Session", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Session"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/drain": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getDrain",
+        "summary": "Tells whether the service is draining, the new requests not being routed to it anymore, with the ondemand.drain label",
+        "parameters": [{"$ref": "#/components/parameters/Timestamp"}, {"$ref": "#/components/parameters/Signature"}],
+        "responses": {
+          "200": {"description": "Drain", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Drain"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "reportDrained",
+        "summary": "Reports the in-flight requests of a draining service completed, for it to be stopped",
+        "parameters": [{"$ref": "#/components/parameters/Timestamp"}, {"$ref": "#/components/parameters/Signature"}],
+        "responses": {
+          "202": {"description": "Drain", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Drain"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/shutdown": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "operationId": "getShutdownNotice",
+        "summary": "Tells when the service is stopped, for a countdown banner",
+        "parameters": [{"$ref": "#/components/parameters/Timestamp"}, {"$ref": "#/components/parameters/Signature"}],
+        "responses": {
+          "200": {"description": "Shutdown notice", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ShutdownNotice"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "keepAlive",
+        "summary": "Delays the stop of a service with the ondemand.keepalive label on behalf of an active tab, like a wake request",
+        "parameters": [{"$ref": "#/components/parameters/Timestamp"}, {"$ref": "#/components/parameters/Signature"}],
+        "responses": {
+          "200": {"description": "Shutdown notice", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ShutdownNotice"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/services/{name}/sessions/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}, {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "delete": {
+        "operationId": "endSession",
+        "summary": "Ends a session",
+        "parameters": [{"$ref": "#/components/parameters/Timestamp"}, {"$ref": "#/components/parameters/Signature"}],
+        "responses": {
+          "200": {"description": "Session", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Session"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/audit": {
+      "get": {
+        "operationId": "getAuditLog",
+        "summary": "Lists the actions taken on the services",
+        "parameters": [{"name": "service", "in": "query", "schema": {"type": "string"}}],
+        "responses": {"200": {"description": "Events", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEvent"}}}}}}
+      }
+    },
+    "/api/traefik/config": {
+      "get": {
+        "operationId": "getTraefikConfig",
+        "summary": "Generates the traefik dynamic configuration of the services with the ondemand.traefik.rule label, for the traefik HTTP provider (with --traefik-provider)",
+        "responses": {
+          "200": {"description": "Routers, middlewares and services", "content": {"application/json": {"schema": {"type": "object", "properties": {"http": {"type": "object"}}}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/activity": {
+      "get": {
+        "operationId": "listActivity",
+        "summary": "Reports the rate of the wake requests of the services and their last request, for external autoscalers",
+        "responses": {
+          "200": {
+            "description": "Activity of the services",
+            "content": {"application/json": {"schema": {"type": "object", "properties": {"services": {"type": "array", "items": {"$ref": "#/components/schemas/Activity"}}}}}}
+          }
+        }
+      }
+    },
+    "/api/activity/{name}": {
+      "get": {
+        "operationId": "getActivity",
+        "summary": "Reports the activity of a service, idle when it is unknown to the scaler",
+        "parameters": [{"$ref": "#/components/parameters/Name"}],
+        "responses": {
+          "200": {"description": "Activity of the service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Activity"}}}}
+        }
+      }
+    },
+    "/api/stats": {
+      "get": {
+        "operationId": "getStats",
+        "summary": "Reports the usage of the services started by the scaler and the time saved by stopping them",
+        "parameters": [{"name": "format", "in": "query", "schema": {"type": "string", "enum": ["json", "csv"], "default": "json"}}],
+        "responses": {
+          "200": {
+            "description": "Usage report",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "services": {"type": "array", "items": {"$ref": "#/components/schemas/Stats"}},
+                    "total": {"$ref": "#/components/schemas/Stats"}
+                  }
+                }
+              },
+              "text/csv": {"schema": {"type": "string"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+,
+    "/api/state/export": {
+      "get": {
+        "operationId": "exportState",
+        "summary": "Snapshots the state of the scaler (services, deadlines, pins, registrations, usage and statistics) to import it on another instance",
+        "responses": {
+          "200": {"description": "Export", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Export"}}}}
+        }
+      }
+    },
+    "/api/state/import": {
+      "post": {
+        "operationId": "importState",
+        "summary": "Restores an export, replacing the state of the services it holds and handling again those it reports up",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Export"}}}},
+        "responses": {
+          "200": {
+            "description": "What was imported",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "services": {"type": "integer"},
+                    "registrations": {"type": "integer"},
+                    "stats": {"type": "integer"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "security": [{}, {"Bearer": []}],
+  "components": {
+    "securitySchemes": {
+      "Bearer": {"type": "http", "scheme": "bearer", "description": "API token, required with --tokens, or JWT of an operator with --oidc-issuer"}
+    },
+    "parameters": {
+      "Name": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+      "IdempotencyKey": {"name": "Idempotency-Key", "in": "header", "description": "Key of the request, whose retries get its response", "schema": {"type": "string"}},
+      "Timestamp": {"name": "X-Ondemand-Timestamp", "in": "header", "description": "Time of the signed request in unix seconds, required with --hmac-secret", "schema": {"type": "integer"}},
+      "Signature": {"name": "X-Ondemand-Signature", "in": "header", "description": "Hex encoded HMAC-SHA256 of the method, path, service name and timestamp, required with --hmac-secret", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "string"},
+          "suggestions": {"type": "array", "description": "Names of the services close to a service that could not be found", "items": {"type": "string"}},
+          "project": {"type": "string", "description": "Name under which a stack or a compose project deployed a service that could not be found"}
+        }
+      },
+      "Definition": {
+        "type": "object",
+        "required": ["image"],
+        "properties": {
+          "image": {"type": "string"},
+          "env": {"type": "array", "items": {"type": "string"}},
+          "ports": {"type": "array", "items": {"type": "string"}},
+          "volumes": {"type": "array", "items": {"type": "string"}},
+          "networks": {"type": "array", "items": {"type": "string"}},
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "removeWhenIdle": {"type": "boolean"}
+        }
+      },
+      "Registration": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string"},
+          "timeout": {"type": "integer", "minimum": 0},
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "definition": {"$ref": "#/components/schemas/Definition"},
+          "pinned": {"type": "boolean"}
+        }
+      },
+      "Override": {
+        "type": "object",
+        "properties": {
+          "env": {"type": "array", "description": "KEY=value variables added to the environment of the service or replacing its variables", "items": {"type": "string"}},
+          "command": {"type": "array", "description": "Command and arguments replacing those of the service", "items": {"type": "string"}}
+        }
+      },
+      "ServiceConfig": {
+        "type": "object",
+        "properties": {
+          "timeout": {"type": "integer", "minimum": 0},
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "pinned": {"type": "boolean"}
+        }
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "status": {"type": "string", "enum": ["up", "down", "starting", "unknown"]},
+          "state": {"$ref": "#/components/schemas/State"},
+          "queuePosition": {"type": "integer"},
+          "containerState": {"type": "string", "enum": ["created", "running", "paused", "restarting", "removing", "exited", "dead"]},
+          "retryAt": {"type": "string", "format": "date-time", "description": "When a crash looping service can be started again"},
+          "stopRetryAt": {"type": "string", "format": "date-time", "description": "When the failed stop of the service is attempted again"},
+          "stopAttempts": {"type": "integer", "description": "Number of failed stops of the service"},
+          "members": {"type": "object", "description": "Status of the services of a group or pattern", "additionalProperties": {"type": "string", "enum": ["up", "down", "starting", "unknown"]}}
+        }
+      },
+      "Batch": {
+        "type": "object",
+        "required": ["names"],
+        "properties": {
+          "names": {"type": "array", "items": {"type": "string"}},
+          "start": {"type": "boolean"},
+          "timeout": {"type": "integer", "minimum": 0}
+        }
+      },
+      "BatchResult": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "status": {"type": "string", "enum": ["up", "down", "starting", "unknown"]},
+          "response": {"type": "string", "enum": ["started", "starting", "exhausted"]},
+          "error": {"type": "string"}
+        }
+      },
+      "State": {"type": "string", "enum": ["up", "down", "starting", "stopping", "failed", "stop-failed", "draining", "unknown"]},
+      "Export": {
+        "type": "object",
+        "required": ["version"],
+        "properties": {
+          "version": {"type": "integer", "enum": [1]},
+          "time": {"type": "string", "format": "date-time"},
+          "services": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["name"],
+              "properties": {
+                "name": {"type": "string"},
+                "timeout": {"type": "integer", "minimum": 0},
+                "state": {"$ref": "#/components/schemas/State"},
+                "transitions": {"type": "array", "items": {"type": "object"}},
+                "pinned": {"type": "boolean"},
+                "startedAt": {"type": "string", "format": "date-time"},
+                "stoppedAt": {"type": "string", "format": "date-time"},
+                "upAt": {"type": "string", "format": "date-time"},
+                "lastRequestAt": {"type": "string", "format": "date-time"},
+                "idleDeadline": {"type": "string", "format": "date-time"},
+                "runtimeSeconds": {"type": "number"},
+                "runtimeDay": {"type": "string", "format": "date-time"},
+                "crashBackoff": {"type": "string"},
+                "backoffUntil": {"type": "string", "format": "date-time"}
+              }
+            }
+          },
+          "registrations": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/Registration"}},
+          "usage": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "integer"}}},
+          "predictions": {"type": "object", "additionalProperties": {"type": "object"}},
+          "removed": {"type": "object", "description": "Docker specs of the services removed by the remove strategy", "additionalProperties": {"type": "object"}},
+          "networks": {"type": "object", "description": "Networks removed with the services, created again when they are woken up", "additionalProperties": {"type": "array", "items": {"type": "object"}}},
+          "stats": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "object",
+              "properties": {
+                "since": {"type": "string", "format": "date-time"},
+                "wakeUps": {"type": "integer"},
+                "runningSeconds": {"type": "number"},
+                "coldStartSeconds": {"type": "number"},
+                "coldStarts": {"type": "integer"}
+              }
+            }
+          }
+        }
+      },
+      "Transitions": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "state": {"$ref": "#/components/schemas/State"},
+          "transitions": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "from": {"$ref": "#/components/schemas/State"},
+                "to": {"$ref": "#/components/schemas/State"},
+                "time": {"type": "string", "format": "date-time"},
+                "reason": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Control": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "response": {"type": "string", "enum": ["started", "starting", "exhausted", "stopped"]},
+          "pinned": {"type": "boolean"}
+        }
+      },
+      "Wait": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "status": {"type": "string", "enum": ["up", "starting", "down"]},
+          "waitedSeconds": {"type": "number", "description": "How long the request was held"}
+        }
+      },
+      "ServiceSnapshot": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "status": {"type": "string", "enum": ["up", "down", "starting", "unknown"]},
+          "state": {"$ref": "#/components/schemas/State"},
+          "error": {"type": "string"},
+          "timeout": {"type": "integer"},
+          "remaining": {"type": "integer", "description": "Idle time in seconds before the service is stopped"},
+          "pinned": {"type": "boolean"},
+          "sessions": {"type": "integer"},
+          "startedAt": {"type": "string", "format": "date-time"},
+          "stoppedAt": {"type": "string", "format": "date-time"},
+          "lastRequestAt": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Budget": {
+        "type": "object",
+        "properties": {
+          "maxRuntime": {"type": "string"},
+          "dailyBudget": {"type": "string"},
+          "runtimeToday": {"type": "string"},
+          "remaining": {"type": "string"},
+          "running": {"type": "boolean"}
+        }
+      },
+      "Prediction": {
+        "type": "object",
+        "properties": {
+          "weekday": {"type": "string"},
+          "time": {"type": "string"},
+          "weeks": {"type": "integer"}
+        }
+      },
+      "PredictionOverride": {
+        "type": "object",
+        "properties": {
+          "disabled": {"type": "boolean"},
+          "predictions": {"type": "array", "items": {"$ref": "#/components/schemas/Prediction"}}
+        }
+      },
+      "Session": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "sessions": {"type": "integer"}
+        }
+      },
+      "AuditEvent": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "service": {"type": "string"},
+          "action": {"type": "string"},
+          "reason": {"type": "string"},
+          "requestId": {"type": "string"}
+        }
+      },
+      "Details": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "image": {"type": "string"},
+          "createdAt": {"type": "string", "format": "date-time"},
+          "updatedAt": {"type": "string", "format": "date-time"},
+          "replicas": {"type": "integer"},
+          "ports": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "protocol": {"type": "string"},
+                "targetPort": {"type": "integer"},
+                "publishedPort": {"type": "integer"}
+              }
+            }
+          },
+          "containers": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "id": {"type": "string"},
+                "state": {"type": "string"},
+                "health": {"type": "string"},
+                "startedAt": {"type": "string", "format": "date-time"},
+                "cpuPercent": {"type": "number", "description": "Percentage of one CPU"},
+                "memoryBytes": {"type": "integer"},
+                "memoryLimitBytes": {"type": "integer"}
+              }
+            }
+          },
+          "status": {"type": "string", "enum": ["up", "down", "starting", "unknown"]},
+          "state": {"$ref": "#/components/schemas/State"},
+          "containerState": {"type": "string"},
+          "strategy": {"type": "string"},
+          "timeout": {"type": "integer"},
+          "idleDeadline": {"type": "string", "format": "date-time"},
+          "pinned": {"type": "boolean"},
+          "lastRequestAt": {"type": "string", "format": "date-time"},
+          "lastError": {"type": "string"},
+          "flapping": {
+            "type": "object",
+            "description": "Recent wake-ups within 5 minutes of a stop, and the extension of the timeout of the service",
+            "properties": {
+              "flaps": {"type": "integer"},
+              "factor": {"type": "integer"},
+              "effectiveTimeout": {"type": "integer"},
+              "extendedUntil": {"type": "string", "format": "date-time"}
+            }
+          }
+        }
+      },
+      "Drain": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "state": {"$ref": "#/components/schemas/State"},
+          "draining": {"type": "boolean"},
+          "since": {"type": "string", "format": "date-time"},
+          "deadline": {"type": "string", "format": "date-time", "description": "When the service is stopped if the plugin did not report its in-flight requests completed"}
+        }
+      },
+      "ShutdownNotice": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "state": {"type": "string"},
+          "shutdownAt": {"type": "string", "format": "date-time", "description": "When the service is stopped unless it is requested again"},
+          "shutdownIn": {"type": "integer", "description": "Seconds left until the stop"},
+          "warning": {"type": "boolean", "description": "The stop is less than --shutdown-warning away"},
+          "message": {"type": "string", "description": "Message of the banner, e.g. shutting down in 120s"},
+          "keepAlive": {"type": "boolean", "description": "The active tabs can delay the stop"}
+        }
+      },
+      "Activity": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "requests": {"type": "integer", "description": "Wake requests over the last minute"},
+          "requestsPerSecond": {"type": "number", "description": "Rate of the wake requests over the last minute"},
+          "lastRequestAt": {"type": "string", "format": "date-time"},
+          "active": {"type": "boolean", "description": "The service was requested within its idle timeout"},
+          "state": {"type": "string"}
+        }
+      },
+      "Stats": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "since": {"type": "string", "format": "date-time"},
+          "wakeUps": {"type": "integer"},
+          "runningSeconds": {"type": "number", "description": "Cumulated running time, the current run included"},
+          "averageColdStartSeconds": {"type": "number", "description": "Average duration from the wake-up until the service is up"},
+          "savedHours": {"type": "number", "description": "Time the service did not run, compared to running it all the time since"},
+          "costPerHour": {"type": "number", "description": "Cost of the service per running hour"},
+          "costSaved": {"type": "number", "description": "Cost of the saved hours"},
+          "shadow": {"type": "boolean", "description": "The starts and stops of the service are simulated"}
+        }
+      }
+    }
+  }
+}
+`
+
+// handleOpenAPI serves GET /api/openapi.json
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, openAPISpec)
+}
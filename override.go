@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// Labels used on the docker service to override the environment and the command of its containers when woken up
+const (
+	envLabelPrefix = "ondemand.env."
+	commandLabel   = "ondemand.command"
+	// originalLabel holds the environment and command of the docker service before they were overridden, restored
+	// at the next wake-up
+	originalLabel = "ondemand.override.original"
+)
+
+// Override is an environment and a command applied to the containers of a service when it is woken up
+type Override struct {
+	// Env are KEY=value variables, added to the environment of the service or replacing its variables
+	Env []string `json:"env,omitempty"`
+	// Command replaces the command and the arguments of the service
+	Command []string `json:"command,omitempty"`
+}
+
+// containerCommand is the part of the container spec an override changes
+type containerCommand struct {
+	Env     []string `json:"env,omitempty"`
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// pendingOverrides are the overrides of the next wake-up of the services, set through the API
+var pendingOverridesMutex sync.Mutex
+var pendingOverrides = map[string]*Override{}
+
+func (override *Override) validate() error {
+	for _, variable := range override.Env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%s should be KEY=value", variable)
+		}
+	}
+	return nil
+}
+
+func (override *Override) isEmpty() bool {
+	return override == nil || (len(override.Env) == 0 && len(override.Command) == 0)
+}
+
+// parseOverride returns the override of the ondemand.env.<KEY> and ondemand.command labels, nil without them
+func parseOverride(labels map[string]string) *Override {
+	override := &Override{}
+	for key, value := range labels {
+		if strings.HasPrefix(key, envLabelPrefix) && len(key) > len(envLabelPrefix) {
+			override.Env = append(override.Env, strings.TrimPrefix(key, envLabelPrefix)+"="+value)
+		}
+	}
+	sort.Strings(override.Env)
+	override.Command = strings.Fields(labels[commandLabel])
+	if override.isEmpty() {
+		return nil
+	}
+	return override
+}
+
+// takePendingOverride returns and forgets the override of the next wake-up of the service
+func takePendingOverride(name string) *Override {
+	pendingOverridesMutex.Lock()
+	defer pendingOverridesMutex.Unlock()
+	override := pendingOverrides[name]
+	delete(pendingOverrides, name)
+	return override
+}
+
+// mergeEnv returns the environment with the variables of the override, replacing those with the same key
+func mergeEnv(env []string, variables []string) []string {
+	merged := []string{}
+	replaced := map[string]bool{}
+	for _, variable := range variables {
+		replaced[strings.SplitN(variable, "=", 2)[0]] = true
+	}
+	for _, variable := range env {
+		if !replaced[strings.SplitN(variable, "=", 2)[0]] {
+			merged = append(merged, variable)
+		}
+	}
+	return append(merged, variables...)
+}
+
+// applyOverrides restores the environment and command of the spec overridden by the previous wake-up, then applies the
+// override of the labels and the pending one of the API, recording what they replace
+func (service *Service) applyOverrides(spec *swarm.ServiceSpec, labels map[string]string) error {
+	container := &spec.TaskTemplate.ContainerSpec
+	// The labels may be shared, e.g. with a definition
+	copied := map[string]string{}
+	for key, value := range spec.Labels {
+		copied[key] = value
+	}
+	spec.Labels = copied
+	if original, ok := spec.Labels[originalLabel]; ok {
+		restored := containerCommand{}
+		if err := json.Unmarshal([]byte(original), &restored); err != nil {
+			return fmt.Errorf("%s is not valid: %v", originalLabel, err)
+		}
+		container.Env, container.Command, container.Args = restored.Env, restored.Command, restored.Args
+		delete(spec.Labels, originalLabel)
+	}
+	override := parseOverride(labels)
+	if pending := takePendingOverride(service.name); !pending.isEmpty() {
+		if override == nil {
+			override = &Override{}
+		}
+		override.Env = append(override.Env, pending.Env...)
+		if len(pending.Command) > 0 {
+			override.Command = pending.Command
+		}
+	}
+	if override.isEmpty() {
+		return nil
+	}
+	original, err := json.Marshal(containerCommand{container.Env, container.Command, container.Args})
+	if err != nil {
+		return err
+	}
+	spec.Labels[originalLabel] = string(original)
+	container.Env = mergeEnv(container.Env, override.Env)
+	if len(override.Command) > 0 {
+		container.Command, container.Args = override.Command, nil
+	}
+	fmt.Printf("- Service %v is started with %d overridden variables, command %q\n", service.name, len(override.Env), strings.Join(container.Command, " "))
+	return nil
+}
+
+// handleOverrideAPI serves GET, PUT and DELETE /api/services/{name}/override: the override of the next wake-up
+func handleOverrideAPI(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		pendingOverridesMutex.Lock()
+		override := pendingOverrides[name]
+		pendingOverridesMutex.Unlock()
+		if override == nil {
+			override = &Override{}
+		}
+		writeJSON(w, http.StatusOK, override)
+	case http.MethodPut:
+		override := &Override{}
+		if err := json.NewDecoder(r.Body).Decode(override); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid override: %v", err))
+			return
+		}
+		if err := override.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		pendingOverridesMutex.Lock()
+		pendingOverrides[name] = override
+		pendingOverridesMutex.Unlock()
+		content, _ := json.Marshal(override)
+		audit(name, "override", string(content), requestID(r))
+		writeJSON(w, http.StatusOK, override)
+	case http.MethodDelete:
+		takePendingOverride(name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
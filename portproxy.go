@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// PortProxy listens on a port and forwards the connections to a service, woken up on the first connection
+type PortProxy struct {
+	// Listen is the address the proxy listens on (e.g. :5432)
+	Listen string `json:"listen"`
+	// Protocol is tcp (default) or udp
+	Protocol string `json:"protocol,omitempty"`
+	Service  string `json:"service"`
+	// Backend is the address of the service the connections are forwarded to (e.g. postgres:5432)
+	Backend string `json:"backend"`
+	// Timeout is how long, in seconds, the service is kept up after its last connection is closed
+	Timeout uint64 `json:"timeout,omitempty"`
+}
+
+// backendDialInterval is the delay between two connection attempts to a backend that is not reachable yet
+const backendDialInterval = 500 * time.Millisecond
+
+func loadPortProxies(path string) ([]*PortProxy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := []*PortProxy{}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	for _, proxy := range loaded {
+		if proxy.Listen == "" || proxy.Service == "" || proxy.Backend == "" {
+			return nil, fmt.Errorf("port proxies need a listen address, a service and a backend")
+		}
+		if proxy.Protocol == "" {
+			proxy.Protocol = "tcp"
+		}
+		if proxy.Protocol != "tcp" && proxy.Protocol != "udp" {
+			return nil, fmt.Errorf("protocol %s of port proxy %s should be tcp or udp", proxy.Protocol, proxy.Listen)
+		}
+		if proxy.Timeout == 0 {
+			proxy.Timeout = *proxyTimeout
+		}
+	}
+	return loaded, nil
+}
+
+// dialBackend connects to the backend, retrying until it is reachable or wait is elapsed
+func dialBackend(network string, address string, wait time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		conn, err := net.DialTimeout(network, address, probeTimeout)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(backendDialInterval)
+	}
+}
+
+// serve forwards the connections, or the datagrams, received on the port to the service
+func (proxy *PortProxy) serve(cli *client.Client, wait time.Duration) error {
+	if proxy.Protocol == "udp" {
+		return proxy.serveUDP(cli, wait)
+	}
+	return proxy.serveTCP(cli, wait)
+}
+
+// serveTCP accepts the connections on the port and forwards them to the service
+func (proxy *PortProxy) serveTCP(cli *client.Client, wait time.Duration) error {
+	listener, err := net.Listen("tcp", proxy.Listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Proxying %s/tcp to service %s (%s).\n", proxy.Listen, proxy.Service, proxy.Backend)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go proxy.forwardTCP(cli, conn, wait)
+	}
+}
+
+// forwardTCP holds the connection until the service is started and its backend reachable, then splices it
+func (proxy *PortProxy) forwardTCP(cli *client.Client, conn net.Conn, wait time.Duration) {
+	defer reportPanic(nil, "the port proxy "+proxy.Listen)
+	defer conn.Close()
+	service := GetOrCreateService(proxy.Service, proxy.Timeout)
+	service.openProxyConnection()
+	defer service.closeProxyConnection()
+
+	started := time.Now()
+	if err := service.waitUntilStarted(context.Background(), cli, wait); err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	backend, err := dialBackend("tcp", proxy.Backend, wait-time.Since(started))
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backend, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, backend)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// openProxyConnection records a connection to the service through a port proxy
+func (service *Service) openProxyConnection() {
+	service.lastRequestAt = time.Now()
+	recordWakeRequest(service.name, service.lastRequestAt)
+	atomic.AddInt32(&service.proxyConnections, 1)
+}
+
+// closeProxyConnection records the end of a connection, the timeout of the service starting again from now
+func (service *Service) closeProxyConnection() {
+	atomic.AddInt32(&service.proxyConnections, -1)
+	select {
+	case service.time <- service.effectiveTimeout():
+	default:
+	}
+}
+
+// hasProxyConnections reports whether the service has open connections through a port proxy, deferring its stop
+func (service *Service) hasProxyConnections() bool {
+	connections := atomic.LoadInt32(&service.proxyConnections)
+	if connections > 0 {
+		fmt.Printf("- Service %v still has %d proxied connections\n", service.name, connections)
+		return true
+	}
+	return false
+}
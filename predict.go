@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// usageSlot is the granularity of the usage history
+const usageSlot = 15 * time.Minute
+
+// usageRetention is how long the usage history is kept
+const usageRetention = 28 * 24 * time.Hour
+
+// predictionMinWeeks is the number of weeks a time slot must have been used to be predicted as used
+const predictionMinWeeks = 3
+
+// predictionInterval is the delay between two checks of the predictions
+const predictionInterval = time.Minute
+
+// Prediction is a weekly time at which a service is expected to be requested
+type Prediction struct {
+	Weekday string `json:"weekday"`
+	Time    string `json:"time"`
+	// Weeks is the number of recent weeks the service was requested at that time
+	Weeks int `json:"weeks,omitempty"`
+}
+
+// PredictionOverride replaces the predictions of a service computed from its usage
+type PredictionOverride struct {
+	Disabled    bool         `json:"disabled,omitempty"`
+	Predictions []Prediction `json:"predictions,omitempty"`
+}
+
+var usageMutex sync.Mutex
+var usage = map[string][]int64{}
+var predictionOverrides = map[string]*PredictionOverride{}
+
+func weekdayName(weekday time.Weekday) string {
+	return strings.ToLower(weekday.String()[:3])
+}
+
+func (override *PredictionOverride) validate() error {
+	for _, prediction := range override.Predictions {
+		if _, ok := weekdays[prediction.Weekday]; !ok {
+			return fmt.Errorf("%s is not a day (mon, tue...)", prediction.Weekday)
+		}
+		if _, err := parseClock(prediction.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadUsage restores the persisted usage history and prediction overrides
+func loadUsage() error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	usage = state.Usage
+	predictionOverrides = state.Predictions
+	return nil
+}
+
+// recordUsage records that the service was requested at the given time
+func recordUsage(name string, now time.Time) {
+	slot := now.Truncate(usageSlot).Unix()
+	usageMutex.Lock()
+	slots := usage[name]
+	if len(slots) > 0 && slots[len(slots)-1] == slot {
+		usageMutex.Unlock()
+		return
+	}
+	oldest := now.Add(-usageRetention).Unix()
+	recent := []int64{}
+	for _, previous := range slots {
+		if previous >= oldest {
+			recent = append(recent, previous)
+		}
+	}
+	recent = append(recent, slot)
+	usage[name] = recent
+	usageMutex.Unlock()
+
+	err := store.Update(func(state *State) {
+		state.Usage[name] = recent
+	})
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+}
+
+// predict returns the weekly times at which the service starts being used, according to its usage history or its override
+func predict(name string) []Prediction {
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	if override := predictionOverrides[name]; override != nil {
+		if override.Disabled {
+			return []Prediction{}
+		}
+		return override.Predictions
+	}
+
+	slotsPerWeek := int(7 * 24 * time.Hour / usageSlot)
+	weeks := make([]int, slotsPerWeek)
+	for _, slot := range usage[name] {
+		at := time.Unix(slot, 0)
+		clock := time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+		weeks[int(at.Weekday())*slotsPerWeek/7+int(clock/usageSlot)]++
+	}
+	predictions := []Prediction{}
+	for slot := range weeks {
+		previous := (slot + slotsPerWeek - 1) % slotsPerWeek
+		if weeks[slot] >= predictionMinWeeks && weeks[previous] < predictionMinWeeks {
+			clock := time.Duration(slot%(slotsPerWeek/7)) * usageSlot
+			predictions = append(predictions, Prediction{
+				Weekday: weekdayName(time.Weekday(slot / (slotsPerWeek / 7))),
+				Time:    fmt.Sprintf("%02d:%02d", int(clock.Hours()), int(clock.Minutes())%60),
+				Weeks:   weeks[slot],
+			})
+		}
+	}
+	return predictions
+}
+
+// nextPrediction returns the next time after now of a prediction
+func nextPrediction(now time.Time, prediction Prediction) (time.Time, error) {
+	clock, err := parseClock(prediction.Time)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := nextOccurrence(now, clock)
+	for weekdayName(next.Weekday()) != prediction.Weekday {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+func predictedServices() []string {
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	names := []string{}
+	for name := range usage {
+		names = append(names, name)
+	}
+	for name := range predictionOverrides {
+		if _, ok := usage[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runPredictions wakes the services up shortly before they are predicted to be requested
+func runPredictions(client *client.Client, lead time.Duration) {
+	for {
+		now := time.Now()
+		for _, name := range predictedServices() {
+			for _, prediction := range predict(name) {
+				next, err := nextPrediction(now, prediction)
+				if err != nil || next.Sub(now) > lead {
+					continue
+				}
+				timeout := defaultScheduleTimeout
+				if registration := getRegistration(name); registration != nil && registration.Timeout > 0 {
+					timeout = registration.Timeout
+				}
+				service := GetOrCreateService(name, timeout)
+				fmt.Printf("- Service %v is predicted to be requested at %s\n", name, next.Format(time.RFC3339))
+				if _, err := service.HandleServiceState(context.Background(), client); err != nil {
+					fmt.Printf("Error: %+v\n ", err)
+				}
+				break
+			}
+		}
+		time.Sleep(predictionInterval)
+	}
+}
+
+type predictionsResponse struct {
+	Predictions []Prediction        `json:"predictions"`
+	Override    *PredictionOverride `json:"override,omitempty"`
+}
+
+func getPredictions(name string) predictionsResponse {
+	predictions := predict(name)
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	return predictionsResponse{predictions, predictionOverrides[name]}
+}
+
+// overridePredictions replaces the predictions of a service, or restores them when override is nil
+func overridePredictions(name string, override *PredictionOverride) error {
+	usageMutex.Lock()
+	if override == nil {
+		delete(predictionOverrides, name)
+	} else {
+		predictionOverrides[name] = override
+	}
+	usageMutex.Unlock()
+	return store.Update(func(state *State) {
+		if override == nil {
+			delete(state.Predictions, name)
+		} else {
+			state.Predictions[name] = override
+		}
+	})
+}
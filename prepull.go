@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+func init() {
+	metrics.Register("ondemand_image_pulls_total", "counter", "Number of image pulls")
+	metrics.Register("ondemand_image_pull_failures_total", "counter", "Number of failed image pulls")
+	metrics.Register("ondemand_image_pull_duration_seconds_total", "counter", "Cumulated duration of image pulls")
+	metrics.Register("ondemand_image_pull_last_duration_seconds", "gauge", "Duration of the last pull of an image")
+}
+
+// pullImage pulls an image and records the pull duration
+func pullImage(ctx context.Context, client *client.Client, reference string) error {
+	fmt.Printf("Pulling image %s\n", reference)
+	begin := time.Now()
+	err := func() error {
+		reader, err := client.ImagePull(ctx, reference, types.ImagePullOptions{})
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		// The pull errors are reported in the stream rather than by ImagePull
+		return jsonmessage.DisplayJSONMessagesStream(reader, ioutil.Discard, 0, false, nil)
+	}()
+	duration := time.Since(begin).Seconds()
+	metrics.Add("ondemand_image_pulls_total", 1, "image", reference)
+	if err != nil {
+		metrics.Add("ondemand_image_pull_failures_total", 1, "image", reference)
+		return err
+	}
+	metrics.Add("ondemand_image_pull_duration_seconds_total", duration, "image", reference)
+	metrics.Set("ondemand_image_pull_last_duration_seconds", duration, "image", reference)
+	return nil
+}
+
+// parseClock parses a HH:MM time of day
+func parseClock(clock string) (time.Duration, error) {
+	at, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%s should be a time of day (HH:MM)", clock)
+	}
+	return time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute, nil
+}
+
+// nextOccurrence returns the next time after now at the given time of day
+func nextOccurrence(now time.Time, clock time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(clock)
+	if !next.After(now) {
+		next = midnight.AddDate(0, 0, 1).Add(clock)
+	}
+	return next
+}
+
+// managedImages returns the images of the requested and defined services
+func managedImages(ctx context.Context, client *client.Client) []string {
+	images := map[string]bool{}
+	registryMutex.RLock()
+	for _, definition := range definitions {
+		images[definition.Image] = true
+	}
+	registryMutex.RUnlock()
+	targets := []*Service{}
+	servicesMutex.Lock()
+	for _, service := range services {
+		targets = append(targets, service)
+		for _, member := range service.members {
+			targets = append(targets, member)
+		}
+	}
+	servicesMutex.Unlock()
+	for _, service := range targets {
+		dockerService, err := service.getDockerService(ctx, client)
+		if err != nil {
+			continue
+		}
+		images[dockerService.Spec.TaskTemplate.ContainerSpec.Image] = true
+	}
+	references := []string{}
+	for image := range images {
+		references = append(references, image)
+	}
+	return references
+}
+
+// pullClients returns the clients of the daemons the images are pulled on: the local one and those of the agents
+func pullClients(cli *client.Client) []*client.Client {
+	clients := []*client.Client{cli}
+	// An agent can be listed by both its node ID and its hostname
+	seen := map[*client.Client]bool{cli: true}
+	for _, agent := range agents {
+		if !seen[agent] {
+			seen[agent] = true
+			clients = append(clients, agent)
+		}
+	}
+	return clients
+}
+
+// prepullImages pulls the images of the managed services every day at the given time of day, on the local daemon
+// and on the nodes of the agents, so that waking them up never waits for a registry pull
+func prepullImages(client *client.Client, clock time.Duration) {
+	for {
+		next := nextOccurrence(time.Now(), clock)
+		fmt.Printf("Next image pre-pull at %s\n", next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+		ctx := context.Background()
+		images := managedImages(ctx, client)
+		if dryRun.Get() {
+			for _, image := range images {
+				fmt.Printf("Dry run: would pull image %s\n", image)
+			}
+			continue
+		}
+		for _, pullClient := range pullClients(client) {
+			for _, image := range images {
+				if err := pullImage(ctx, pullClient, image); err != nil {
+					fmt.Printf("Error: %+v\n ", err)
+				}
+			}
+		}
+	}
+}
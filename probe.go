@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Labels used on the docker service to configure its readiness probe
+const (
+	tcpProbeLabel        = "ondemand.probe.tcp"
+	httpProbeLabel       = "ondemand.probe.http"
+	httpProbeStatusLabel = "ondemand.probe.http.status"
+	execProbeLabel       = "ondemand.probe.exec"
+	logProbeLabel        = "ondemand.probe.log"
+)
+
+const probeTimeout = 2 * time.Second
+
+// ReadinessProbe holds the checks that must pass before a service is reported as started
+type ReadinessProbe struct {
+	// TCP is an address (host:port) that must accept connections
+	TCP string
+	// HTTP is an URL that must answer with HTTPStatus
+	HTTP       string
+	HTTPStatus int
+	// Exec is a command that must exit with 0 inside the service container
+	Exec string
+	// Log is a pattern that must appear in the service container logs
+	Log *regexp.Regexp
+}
+
+func parseReadinessProbe(labels map[string]string) (*ReadinessProbe, error) {
+	probe := &ReadinessProbe{
+		TCP:        labels[tcpProbeLabel],
+		HTTP:       labels[httpProbeLabel],
+		HTTPStatus: http.StatusOK,
+		Exec:       labels[execProbeLabel],
+	}
+	if status, ok := labels[httpProbeStatusLabel]; ok {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be an integer", httpProbeStatusLabel)
+		}
+		probe.HTTPStatus = code
+	}
+	if pattern, ok := labels[logProbeLabel]; ok {
+		log, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid regular expression: %v", logProbeLabel, err)
+		}
+		probe.Log = log
+	}
+	if probe.TCP == "" && probe.HTTP == "" && probe.Exec == "" && probe.Log == nil {
+		return nil, nil
+	}
+	return probe, nil
+}
+
+// Check runs every configured check against the service, containerID being one of its running containers
+func (probe *ReadinessProbe) Check(ctx context.Context, client *client.Client, containerID string) error {
+	if probe.TCP != "" {
+		if err := checkTCP(probe.TCP); err != nil {
+			return err
+		}
+	}
+	if probe.HTTP != "" {
+		if err := checkHTTP(ctx, probe.HTTP, probe.HTTPStatus); err != nil {
+			return err
+		}
+	}
+	if probe.Exec != "" {
+		if err := checkExec(ctx, client, containerID, probe.Exec); err != nil {
+			return err
+		}
+	}
+	if probe.Log != nil {
+		if err := checkLogs(ctx, client, containerID, probe.Log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkTCP(address string) error {
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func checkHTTP(ctx context.Context, url string, expectedStatus int) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != expectedStatus {
+		return fmt.Errorf("%s answered %d, expected %d", url, response.StatusCode, expectedStatus)
+	}
+	return nil
+}
+
+func checkExec(ctx context.Context, client *client.Client, containerID string, command string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	client = containerClient(client, containerID)
+	exec, err := client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd: []string{"sh", "-c", command},
+	})
+	if err != nil {
+		return err
+	}
+	if err := client.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true}); err != nil {
+		return err
+	}
+	for {
+		inspect, err := client.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return err
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return fmt.Errorf("%q exited with %d", command, inspect.ExitCode)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func checkLogs(ctx context.Context, client *client.Client, containerID string, pattern *regexp.Regexp) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	client = containerClient(client, containerID)
+	container, err := client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	logs, err := client.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	var output io.Reader = logs
+	if !container.Config.Tty {
+		reader, writer := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(writer, writer, logs)
+			writer.CloseWithError(err)
+		}()
+		output = reader
+	}
+	content, err := ioutil.ReadAll(output)
+	if err != nil {
+		return err
+	}
+	if !pattern.Match(content) {
+		return fmt.Errorf("logs do not match %q yet", pattern)
+	}
+	return nil
+}
+
+// getRunningContainers returns the IDs of the containers of the running tasks of a docker service
+func getRunningContainers(ctx context.Context, client *client.Client, dockerService *swarm.Service) ([]string, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("service", dockerService.ID)
+	filterArgs.Add("desired-state", string(swarm.TaskStateRunning))
+	tasks, err := client.TaskList(ctx, types.TaskListOptions{Filters: filterArgs})
+	if err != nil {
+		return nil, err
+	}
+	containerIDs := []string{}
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning {
+			recordContainerNode(task.ServiceID, task.Status.ContainerStatus.ContainerID, task.NodeID)
+			containerIDs = append(containerIDs, task.Status.ContainerStatus.ContainerID)
+		}
+	}
+	return containerIDs, nil
+}
+
+// isReady reports whether the service has a running container and passes its readiness probe
+func (service *Service) isReady(ctx context.Context, client *client.Client, dockerService *swarm.Service, containerIDs []string) (bool, error) {
+	if len(containerIDs) == 0 {
+		return false, nil
+	}
+	if service.readyContainer == containerIDs[0] {
+		return true, nil
+	}
+	probe, err := parseReadinessProbe(service.labels(dockerService))
+	if err != nil {
+		return false, err
+	}
+	if probe == nil && windowsDaemon {
+		// Windows containers can take long to initialize once started, their healthcheck tells when they are ready
+		healthy, err := isHealthy(ctx, client, containerIDs[0])
+		if err != nil || !healthy {
+			fmt.Printf("- Service %v is not healthy yet\n", service.name)
+			return false, nil
+		}
+	}
+	if probe == nil {
+		service.readyContainer = containerIDs[0]
+		return true, nil
+	}
+	span := service.span("probe", "container", containerIDs[0])
+	err = probe.Check(ctx, client, containerIDs[0])
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		fmt.Printf("- Service %v is not ready yet: %v\n", service.name, err)
+		return false, nil
+	}
+	service.readyContainer = containerIDs[0]
+	return true, nil
+}
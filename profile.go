@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// profileHeader selects the response profile of a wake request, as the profile query parameter does
+const profileHeader = "X-Ondemand-Profile"
+
+// ResponseProfile is the vocabulary of the responses to the wake requests a plugin or middleware expects
+type ResponseProfile struct {
+	Started   string `json:"started"`
+	Starting  string `json:"starting"`
+	Exhausted string `json:"exhausted"`
+	// Header is a response header also carrying the response, for the plugins reading it rather than the body
+	Header string `json:"header,omitempty"`
+	// StartingStatus is the status code of the responses for a starting service, 202 by default
+	StartingStatus int `json:"startingStatus,omitempty"`
+}
+
+// profiles are the response profiles by name, the built-in ones and those of --profiles
+var profiles = map[string]*ResponseProfile{
+	"ondemand": {Started: "started", Starting: "starting", Exhausted: exhaustedResponse},
+	"sablier":  {Started: "ready", Starting: "not-ready", Exhausted: "not-ready", Header: "X-Sablier-Session-Status", StartingStatus: http.StatusOK},
+	"status":   {Started: "up", Starting: "starting", Exhausted: exhaustedResponse},
+}
+
+// loadProfiles adds the profiles of a JSON file to the built-in ones, replacing those of the same name
+func loadProfiles(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	loaded := map[string]*ResponseProfile{}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	for name, profile := range loaded {
+		if profile.Started == "" || profile.Starting == "" || profile.Exhausted == "" {
+			return fmt.Errorf("%s: profile %s should define started, starting and exhausted", path, name)
+		}
+		profiles[name] = profile
+	}
+	return nil
+}
+
+// requestProfile returns the profile of the request, the one of --profile when it does not select one
+func requestProfile(r *http.Request) (*ResponseProfile, error) {
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		name = r.Header.Get(profileHeader)
+	}
+	if name == "" {
+		name = defaultProfile.Get()
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown response profile %s", name)
+	}
+	return profile, nil
+}
+
+// word returns the word of the profile for a response of the scaler (started, starting or exhausted)
+func (profile *ResponseProfile) word(response string) string {
+	switch response {
+	case "started":
+		return profile.Started
+	case "starting":
+		return profile.Starting
+	case exhaustedResponse:
+		return profile.Exhausted
+	}
+	return response
+}
+
+// writeWakeResponse answers a wake request in the vocabulary of its profile
+func writeWakeResponse(w http.ResponseWriter, profile *ResponseProfile, service *Service, response string) {
+	word := profile.word(response)
+	if profile.Header != "" {
+		w.Header().Set(profile.Header, word)
+	}
+	if response == "starting" {
+		// Generic clients poll the status until the service is started
+		setAcceptedHeaders(w, service)
+		status := profile.StartingStatus
+		if status == 0 {
+			status = http.StatusAccepted
+		}
+		w.WriteHeader(status)
+	}
+	fmt.Fprint(w, word)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// proxyURLLabel is the URL the requests to the service are proxied to in proxy mode (e.g. http://whoami:80)
+const proxyURLLabel = "ondemand.proxy.url"
+
+// proxyPollInterval is the delay between two checks of a service the proxy is waiting for
+const proxyPollInterval = 500 * time.Millisecond
+
+// waitUntilStarted wakes the service up and waits until it is started, or until wait is elapsed
+func (service *Service) waitUntilStarted(ctx context.Context, cli *client.Client, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	for {
+		status, err := service.HandleServiceState(ctx, cli)
+		if err != nil {
+			return err
+		}
+		if status == "started" {
+			return nil
+		}
+		if status == exhaustedResponse {
+			return fmt.Errorf("Not enough resources to start service %s", service.name)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Service %s did not start within %s", service.name, wait)
+		case <-time.After(proxyPollInterval):
+		}
+	}
+}
+
+// proxyURL returns the URL the requests to the service are proxied to
+func (service *Service) proxyURL(ctx context.Context, cli *client.Client) (*url.URL, error) {
+	labels, err := service.config(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	target, ok := labels[proxyURLLabel]
+	if !ok {
+		return nil, fmt.Errorf("%s is required to proxy requests to service %s", proxyURLLabel, service.name)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("%s should be an URL (e.g. http://whoami:80)", proxyURLLabel)
+	}
+	return parsed, nil
+}
+
+// handleProxy wakes up the service serving the host of the request and proxies the request to it once started
+func handleProxy(cli *client.Client, defaultTimeout uint64, wait time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serviceName := resolveName(requestHost(r))
+		serviceTimeout := defaultTimeout
+		if registration := getRegistration(serviceName); registration != nil && registration.Timeout > 0 {
+			serviceTimeout = registration.Timeout
+		}
+		if rateLimited(w, r, serviceName) {
+			return
+		}
+		service := GetOrCreateService(serviceName, serviceTimeout)
+		if isIgnored(r) {
+			// Ignored requests are only proxied to a service that is already up
+			if status, err := service.getStatus(r.Context(), cli); err != nil || status != UP {
+				http.Error(w, fmt.Sprintf("Service %s is not started", serviceName), http.StatusServiceUnavailable)
+				return
+			}
+		} else {
+			service.lastRequestAt = time.Now()
+			recordWakeRequest(service.name, service.lastRequestAt)
+			service.requestID = requestID(r)
+			if *predictEnabled {
+				recordUsage(service.name, time.Now())
+			}
+			if err := service.waitUntilStarted(r.Context(), cli, wait); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		target, err := service.proxyURL(r.Context(), cli)
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		logRequest(r, service.name, "started")
+		httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// Label used on the docker service to pull its image when it is woken up
+const pullLabel = "ondemand.pull"
+
+// repository returns the repository of an image reference, without tag nor digest
+func repository(reference string) string {
+	reference = strings.SplitN(reference, "@", 2)[0]
+	if colon := strings.LastIndex(reference, ":"); colon > strings.LastIndex(reference, "/") {
+		return reference[:colon]
+	}
+	return reference
+}
+
+// pullLatest pulls the tag of the image of the spec and pins the spec image on the pulled digest
+func pullLatest(ctx context.Context, client *client.Client, spec *swarm.ServiceSpec) error {
+	image := spec.TaskTemplate.ContainerSpec.Image
+	reference := strings.SplitN(image, "@", 2)[0]
+	if err := pullImage(ctx, client, reference); err != nil {
+		return err
+	}
+
+	inspect, _, err := client.ImageInspectWithRaw(ctx, reference)
+	if err != nil {
+		return err
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && parts[0] == repository(reference) {
+			pinned := reference + "@" + parts[1]
+			if pinned != image {
+				fmt.Printf("Updating image %s to %s\n", image, pinned)
+				spec.TaskTemplate.ContainerSpec.Image = pinned
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("could not find the digest of image %s", reference)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// maxStartDuration is how long a start holds its slot at most, if the service is never seen up
+const maxStartDuration = 5 * time.Minute
+
+// StartQueue limits how many services start at the same time, the others waiting in line
+type StartQueue struct {
+	mutex    sync.Mutex
+	starting map[*Service]time.Time
+	waiting  []*Service
+}
+
+var startQueue = &StartQueue{starting: map[*Service]time.Time{}}
+
+// acquire reports whether the service can start now, taking a slot, or its position in line otherwise
+func (queue *StartQueue) acquire(service *Service) (bool, int) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for started, since := range queue.starting {
+		if time.Since(since) > maxStartDuration {
+			delete(queue.starting, started)
+		}
+	}
+	if _, ok := queue.starting[service]; ok {
+		return true, 0
+	}
+	free := maxStarting.Get() <= 0 || len(queue.starting) < maxStarting.Get()
+	if free && (len(queue.waiting) == 0 || queue.waiting[0] == service) {
+		if len(queue.waiting) > 0 {
+			queue.waiting = queue.waiting[1:]
+		}
+		queue.starting[service] = time.Now()
+		return true, 0
+	}
+	for i, waiting := range queue.waiting {
+		if waiting == service {
+			return false, i + 1
+		}
+	}
+	queue.waiting = append(queue.waiting, service)
+	fmt.Printf("- Service %v is #%d in line to start\n", service.name, len(queue.waiting))
+	return false, len(queue.waiting)
+}
+
+// release frees the slot of a service that is up, starting the next service in line
+func (queue *StartQueue) release(service *Service, client *client.Client) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if _, ok := queue.starting[service]; !ok {
+		return
+	}
+	delete(queue.starting, service)
+	if len(queue.waiting) > 0 {
+		next := queue.waiting[0]
+		go func() {
+			if _, err := next.HandleServiceState(context.Background(), client); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+		}()
+	}
+}
+
+// position returns the position of the service in line, 0 when it is not waiting
+func (queue *StartQueue) position(service *Service) int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for i, waiting := range queue.waiting {
+		if waiting == service {
+			return i + 1
+		}
+	}
+	return 0
+}
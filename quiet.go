@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to never start it during time windows, whatever requests it
+const (
+	quietLabel         = "ondemand.quiet"
+	quietTimezoneLabel = "ondemand.quiet.timezone"
+)
+
+// asleepResponse is the response to the wake requests of a service during its quiet hours
+const asleepResponse = "asleep"
+
+// maxQuietSearch bounds the search of the end of the quiet hours, a service quiet all the week never waking up
+const maxQuietSearch = 7 * 24 * time.Hour
+
+func init() {
+	metrics.Register("ondemand_quiet_requests_total", "counter", "Number of wake requests of a service refused during its quiet hours")
+}
+
+// AsleepError is returned when a service is requested during its quiet hours
+type AsleepError struct {
+	name  string
+	until time.Time
+}
+
+func (err *AsleepError) Error() string {
+	if err.until.IsZero() {
+		return fmt.Sprintf("Service %s is asleep", err.name)
+	}
+	return fmt.Sprintf("Service %s is asleep until %s", err.name, err.until.Format(time.RFC3339))
+}
+
+// parseQuietHours parses the quiet hours of the service, nil without them
+func parseQuietHours(labels map[string]string) (*Schedule, error) {
+	return parseWindows(labels, quietLabel, quietTimezoneLabel)
+}
+
+// End returns when now leaves the windows of the schedule, to the minute, zero when it stays in them for a week
+func (schedule *Schedule) End(now time.Time) time.Time {
+	at := now.Truncate(time.Minute)
+	for end := now.Add(maxQuietSearch); at.Before(end); at = at.Add(time.Minute) {
+		if !schedule.Contains(at) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// isAsleep returns an AsleepError when the service is in its quiet hours, during which it is not started
+func (service *Service) isAsleep(client *client.Client, now time.Time) error {
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return nil
+	}
+	quiet, err := parseQuietHours(labels)
+	if err != nil {
+		return err
+	}
+	if quiet == nil || !quiet.Contains(now) {
+		return nil
+	}
+	fmt.Printf("- Service %v is in its quiet hours\n", service.name)
+	metrics.Add("ondemand_quiet_requests_total", 1, "service", service.name)
+	return &AsleepError{name: service.name, until: quiet.End(now)}
+}
+
+// writeAsleep answers a wake request of a service in its quiet hours, to be retried once they end
+func writeAsleep(w http.ResponseWriter, err *AsleepError) {
+	if !err.until.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(err.until).Seconds())+1))
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprint(w, asleepResponse)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiterCleanupInterval is the delay between two removals of the buckets that are full again
+const rateLimiterCleanupInterval = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket rate limiter by key, refilled with rate tokens per second up to burst tokens
+type RateLimiter struct {
+	mutex       sync.Mutex
+	rate        float64
+	burst       float64
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}, lastCleanup: time.Now()}
+}
+
+// allow takes a token for key, returning false and the delay before the next token otherwise
+func (limiter *RateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if limiter == nil {
+		return true, 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if now.Sub(limiter.lastCleanup) > rateLimiterCleanupInterval {
+		for bucketKey, bucket := range limiter.buckets {
+			if limiter.refill(bucket, now) >= limiter.burst {
+				delete(limiter.buckets, bucketKey)
+			}
+		}
+		limiter.lastCleanup = now
+	}
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens = limiter.refill(bucket, now)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+}
+
+func (limiter *RateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+}
+
+// clientIP returns the IP of the client of the original request, as forwarded by the proxy: the rightmost
+// X-Forwarded-For entry is the one added by the proxy, the ones before it being sent by the client
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		entries := strings.Split(forwarded, ",")
+		return strings.TrimSpace(entries[len(entries)-1])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+var ipRateLimiter *RateLimiter
+var serviceRateLimiter *RateLimiter
+
+// rateLimited answers 429 with a Retry-After header and returns true when the client or the service exceeded its rate
+func rateLimited(w http.ResponseWriter, r *http.Request, serviceName string) bool {
+	now := time.Now()
+	allowed, delay := ipRateLimiter.allow(clientIP(r), now)
+	if allowed {
+		allowed, delay = serviceRateLimiter.allow(serviceName, now)
+	}
+	if allowed {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfter(delay))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("rate limited"))
+	return true
+}
+
+// retryAfter formats a delay as a number of seconds, rounded up
+func retryAfter(delay time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(delay.Seconds())))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// externalLabel is used on the docker service to choose what the scaler does when it is started or stopped outside of it
+const externalLabel = "ondemand.external"
+
+// Policies of the changes made outside of the scaler
+const (
+	// ADOPT takes the change over: a stopped service is recorded as stopped, a started one is stopped when idle
+	ADOPT = "adopt"
+	// ENFORCE undoes the change: a stopped service is started again, a started one is stopped again
+	ENFORCE = "enforce"
+	// ALERT records and notifies the change without acting on the service
+	ALERT = "alert"
+)
+
+func parseExternalPolicy(labels map[string]string) (string, error) {
+	policy, ok := labels[externalLabel]
+	if !ok {
+		return ADOPT, nil
+	}
+	if policy != ADOPT && policy != ENFORCE && policy != ALERT {
+		return "", fmt.Errorf("%s should be one of %s, %s, %s", externalLabel, ADOPT, ENFORCE, ALERT)
+	}
+	return policy, nil
+}
+
+// externalPolicy returns the policy of the changes made to the service outside of the scaler, adopt by default
+func (service *Service) externalPolicy(cli *client.Client) string {
+	labels, err := service.config(context.Background(), cli)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return ADOPT
+	}
+	policy, err := parseExternalPolicy(labels)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return ADOPT
+	}
+	return policy
+}
+
+// onExternalStop applies the policy of the service stopped outside of the scaler while it was started by it
+func (service *Service) onExternalStop(cli *client.Client) {
+	policy := service.externalPolicy(cli)
+	message := fmt.Sprintf("stopped outside of the scaler, policy %s", policy)
+	fmt.Printf("- Service %v was %s\n", service.name, message)
+	service.recordExternalStop(time.Now())
+	if policy != ADOPT {
+		notify("external-change", service, message)
+	}
+	if policy == ENFORCE {
+		audit(service.name, "enforce", "started again", "")
+		service.start(cli)
+	}
+}
+
+// onExternalStart applies the policy of the service started outside of the scaler after being stopped by it
+func (service *Service) onExternalStart(cli *client.Client) {
+	if service.externalStartOf.Equal(service.stoppedAt) {
+		// The start was already reported
+		return
+	}
+	service.externalStartOf = service.stoppedAt
+	policy := service.externalPolicy(cli)
+	message := fmt.Sprintf("started outside of the scaler, policy %s", policy)
+	fmt.Printf("- Service %v was %s\n", service.name, message)
+	if policy != ADOPT {
+		audit(service.name, "external", message, "")
+		notify("external-change", service, message)
+	}
+	switch policy {
+	case ADOPT:
+		go service.stopAfterTimeout(cli)
+		select {
+		case service.time <- service.effectiveTimeout():
+		default:
+		}
+	case ENFORCE:
+		service.shutdown(cli, "enforced")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+type redeployResponse struct {
+	Name string `json:"name"`
+	// Image is the image of the new containers, PreviousImage the one of the replaced containers
+	Image         string `json:"image"`
+	PreviousImage string `json:"previousImage"`
+	Pulled        bool   `json:"pulled"`
+}
+
+// handleRedeployAPI serves POST /api/services/{name}/redeploy, which replaces the containers of the service
+// by new ones from its spec (mounts, environment...), from the latest image of its tag with pull=true.
+// A service that is down gets its new containers when it is woken up.
+func handleRedeployAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	if isPattern(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("a pattern cannot be redeployed"))
+		return
+	}
+	if dryRun.Get() {
+		writeError(w, http.StatusConflict, fmt.Errorf("services cannot be redeployed in dry run"))
+		return
+	}
+	pull := r.URL.Query().Get("pull") == "true"
+	if pull && disablePull.Get() {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("pulling images is disabled by --disable-pull"))
+		return
+	}
+	service := getService(name)
+	if service == nil {
+		service = &Service{name: name}
+	}
+	dockerService, err := service.getDockerService(r.Context(), cli)
+	if _, notFound := err.(*NotFoundError); notFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	spec := dockerService.Spec
+	response := redeployResponse{Name: name, PreviousImage: spec.TaskTemplate.ContainerSpec.Image, Pulled: pull}
+	if pull {
+		// Pulling is not bounded by --docker-timeout, only by the request
+		span := service.span("pull")
+		err := pullLatest(r.Context(), cli, &spec)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	response.Image = spec.TaskTemplate.ContainerSpec.Image
+	spec.TaskTemplate.ForceUpdate++
+	ctx, cancel := dockerContext(r.Context())
+	defer cancel()
+	defer invalidateStatus(service.name)
+	if _, err := cli.ServiceUpdate(ctx, dockerService.ID, dockerService.Meta.Version, spec, types.ServiceUpdateOptions{}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	audit(name, "redeploy", response.Image, requestID(r))
+	logRequest(r, name, "redeployed")
+	writeJSON(w, http.StatusOK, response)
+}
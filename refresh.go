@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// refreshConcurrency is the number of services whose status is refreshed at the same time, their docker calls being
+// bounded by --docker-concurrency as well
+const refreshConcurrency = 8
+
+func init() {
+	metrics.Register("ondemand_refresh_duration_seconds", "gauge", "Duration of the last refresh of the status of the services")
+}
+
+// refreshedServices returns the services tracked by the scaler and the registered ones, tracked from now on
+func refreshedServices() []*Service {
+	registryMutex.RLock()
+	registered := map[string]uint64{}
+	for name, registration := range registrations {
+		registered[name] = registration.Timeout
+	}
+	registryMutex.RUnlock()
+	for name, timeout := range registered {
+		GetOrCreateService(name, timeout)
+	}
+	servicesMutex.Lock()
+	list := []*Service{}
+	for _, service := range services {
+		// The members of a pattern are refreshed with it
+		if service.parent == nil {
+			list = append(list, service)
+		}
+	}
+	servicesMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// refreshServices reads the status of the services from docker, in parallel, for the changes made outside of the
+// scaler to be reflected by the API and their timers corrected
+func refreshServices(cli *client.Client) {
+	began := time.Now()
+	semaphore := make(chan struct{}, refreshConcurrency)
+	var wait sync.WaitGroup
+	for _, service := range refreshedServices() {
+		wait.Add(1)
+		semaphore <- struct{}{}
+		go func(service *Service) {
+			defer wait.Done()
+			defer func() { <-semaphore }()
+			defer reportPanic(service, "the refresh of service "+service.name)
+			service.refresh(cli)
+		}(service)
+	}
+	wait.Wait()
+	metrics.Set("ondemand_refresh_duration_seconds", time.Since(began).Seconds())
+}
+
+// refresh reads the status of the service from docker and applies the policy of the changes made outside of the
+// scaler: a service it started that is stopped, or a service it stopped that is started again
+func (service *Service) refresh(cli *client.Client) {
+	if service.shadow || dryRun.Get() {
+		// The status of the simulated services does not follow docker
+		return
+	}
+	previous := service.machine.State()
+	invalidateStatus(service.name)
+	status, err := service.getStatus(context.Background(), cli)
+	if err != nil {
+		if _, notFound := err.(*NotFoundError); !notFound {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		return
+	}
+	switch {
+	case status == DOWN && service.isRunning() && (previous == UP || previous == STARTING):
+		service.onExternalStop(cli)
+	case (status == UP || status == STARTING) && previous != STOPFAILED && service.isHandled && !service.isTimed() && !service.isRunning():
+		service.onExternalStart(cli)
+	}
+}
+
+// recordExternalStop records the stop of the service outside of the scaler, for its timer to end
+func (service *Service) recordExternalStop(now time.Time) {
+	audit(service.name, "stop", "observed", "")
+	service.recordRuntime(now)
+	recordRunning(service.name, now.Sub(service.startedAt))
+	service.stoppedAt = now
+	updateRunningServices()
+}
+
+// isTimed reports whether the service is stopped when idle, its timer running
+func (service *Service) isTimed() bool {
+	return atomic.LoadInt32(&service.timers) > 0
+}
+
+// runRefresh refreshes the status of the services every --refresh-interval
+func runRefresh(cli *client.Client) {
+	for range time.Tick(*refreshInterval) {
+		func() {
+			defer reportPanic(nil, "the refresh")
+			refreshServices(cli)
+		}()
+	}
+}
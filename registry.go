@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// Registration is a service registered at runtime through the API
+type Registration struct {
+	Name string `json:"name"`
+	// Timeout is used when the request does not give one
+	Timeout uint64 `json:"timeout,omitempty"`
+	// Labels configure the service like the ondemand.* labels of the docker service (probes, strategy...), taking precedence over them
+	Labels map[string]string `json:"labels,omitempty"`
+	// Definition is used to create the docker service when it does not exist
+	Definition *Definition `json:"definition,omitempty"`
+	// Pinned services are kept up until they are unpinned, also after a restart
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// registryMutex guards the registrations and the definitions
+var registryMutex sync.RWMutex
+var registrations = map[string]*Registration{}
+
+// removedSpecs holds the specs of the services removed by the remove strategy, to create them again
+var removedSpecs = map[string]*swarm.ServiceSpec{}
+
+func (registration *Registration) validate() error {
+	if registration.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for key := range registration.Labels {
+		if !strings.HasPrefix(key, "ondemand.") {
+			return fmt.Errorf("label %s should start with ondemand.", key)
+		}
+	}
+	// The services started with the service stay in its namespace
+	namespace := namespaceOf(registration.Name)
+	for _, label := range []string{dependsLabel, sidecarsLabel} {
+		for _, name := range strings.Split(registration.Labels[label], ",") {
+			if other := namespaceOf(strings.TrimSpace(name)); namespace != "" && other != "" && other != namespace {
+				return fmt.Errorf("%s cannot name %s, which is in another namespace", label, strings.TrimSpace(name))
+			}
+		}
+	}
+	if registration.Definition != nil && registration.Definition.Image == "" {
+		return fmt.Errorf("definition has no image")
+	}
+	return nil
+}
+
+// register adds or replaces a registration and persists the registrations
+func register(registration *Registration) error {
+	registryMutex.Lock()
+	registrations[registration.Name] = registration
+	if registration.Definition != nil {
+		definitions[registration.Name] = registration.Definition
+	}
+	registryMutex.Unlock()
+	return saveRegistrations()
+}
+
+// deregister removes a registration and persists the registrations, reporting whether it existed.
+// A running service is not forgotten, for its idle timer to still stop it
+func deregister(name string) (bool, error) {
+	registryMutex.Lock()
+	registration, ok := registrations[name]
+	if ok {
+		delete(registrations, name)
+		if registration.Definition != nil && definitions[name] == registration.Definition {
+			delete(definitions, name)
+		}
+	}
+	registryMutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	servicesMutex.Lock()
+	if service, ok := services[name]; ok {
+		if state := service.machine.State(); state == DOWN || state == UNKNOWN {
+			delete(services, name)
+		}
+	}
+	servicesMutex.Unlock()
+	return true, saveRegistrations()
+}
+
+func saveRegistrations() error {
+	return store.Update(func(state *State) {
+		registryMutex.RLock()
+		defer registryMutex.RUnlock()
+		state.Registrations = map[string]*Registration{}
+		for name, registration := range registrations {
+			state.Registrations[name] = registration
+		}
+	})
+}
+
+// loadRegistrations restores the persisted registrations
+func loadRegistrations() error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	registryMutex.Lock()
+	for name, spec := range state.Removed {
+		removedSpecs[name] = spec
+	}
+	for name, networks := range state.Networks {
+		removedNetworks[name] = networks
+	}
+	registryMutex.Unlock()
+	for _, registration := range state.Registrations {
+		registryMutex.Lock()
+		registrations[registration.Name] = registration
+		if registration.Definition != nil {
+			definitions[registration.Name] = registration.Definition
+		}
+		registryMutex.Unlock()
+		if registration.Pinned {
+			GetOrCreateService(registration.Name, registration.Timeout).pinned = true
+		}
+	}
+	return nil
+}
+
+// persistPin records the pin of the service in its registration, when it has one, for it to survive restarts
+func persistPin(name string, pinned bool) error {
+	registration := getRegistration(name)
+	if registration == nil || registration.Pinned == pinned {
+		return nil
+	}
+	copied := *registration
+	copied.Pinned = pinned
+	return register(&copied)
+}
+
+func getRegistration(name string) *Registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return registrations[name]
+}
+
+func getRemovedSpec(name string) *swarm.ServiceSpec {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return removedSpecs[name]
+}
+
+func getDefinition(name string) *Definition {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return definitions[name]
+}
+
+// labels returns the configuration of the service: the labels of its docker service
+// overridden by the labels of its registration, or of the registration of its pattern
+func (service *Service) labels(dockerService *swarm.Service) map[string]string {
+	labels := map[string]string{}
+	for key, value := range dockerService.Spec.Labels {
+		labels[key] = value
+	}
+	registration := getRegistration(service.name)
+	if registration == nil && service.parent != nil {
+		registration = getRegistration(service.parent.name)
+	}
+	if registration != nil {
+		for key, value := range registration.Labels {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// config returns the configuration of the service, which for a pattern is the labels of its registration
+func (service *Service) config(ctx context.Context, client *client.Client) (map[string]string, error) {
+	if isPattern(service.name) {
+		labels := map[string]string{}
+		if registration := getRegistration(service.name); registration != nil {
+			for key, value := range registration.Labels {
+				labels[key] = value
+			}
+		}
+		return labels, nil
+	}
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return service.labels(dockerService), nil
+}
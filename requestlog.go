@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// requestIDHeader carries the correlation ID of a request, generated when the caller does not give one
+const requestIDHeader = "X-Request-ID"
+
+type requestLogKey struct{}
+
+// requestLog is filled by the handlers with what the access log reports about the request
+type requestLog struct {
+	id      string
+	service string
+	state   string
+}
+
+// statusRecorder records the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets the proxied responses be streamed
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logRequests logs each request once served, with its correlation ID, which it propagates to the response
+// and to the forwarded requests
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		entry := &requestLog{id: r.Header.Get(requestIDHeader)}
+		if entry.id == "" {
+			entry.id = randomHex(8)
+			r.Header.Set(requestIDHeader, entry.id)
+		}
+		w.Header().Set(requestIDHeader, entry.id)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry)))
+		fmt.Printf("Request %s %s %d service=%s state=%s duration=%s request_id=%s\n",
+			r.Method, r.URL.Path, recorder.status, entry.service, entry.state, time.Since(started).Round(time.Millisecond), entry.id)
+	})
+}
+
+// logRequest records the service and the resulting state of a request for the access log
+func logRequest(r *http.Request, service string, state string) {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		entry.service = service
+		entry.state = state
+	}
+}
+
+// requestID returns the correlation ID of a request
+func requestID(r *http.Request) string {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		return entry.id
+	}
+	return r.Header.Get(requestIDHeader)
+}
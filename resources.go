@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// What to do when a service should start but resources are exhausted
+const (
+	// REJECT answers that resources are exhausted
+	REJECT = "reject"
+	// QUEUE answers that the service is starting and starts it once resources are available
+	QUEUE = "queue"
+	// EVICT stops the running service with the lowest priority and least recently requested to make room
+	EVICT = "evict"
+)
+
+// exhaustedResponse is the response when a service cannot start because resources are exhausted
+const exhaustedResponse = "exhausted"
+
+// Label used on the docker service to give it a priority, services with a lower priority being evicted first
+const priorityLabel = "ondemand.priority"
+
+// availableMemory returns the memory available on the host in bytes
+func availableMemory() (int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kilobytes, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kilobytes * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("could not find MemAvailable in /proc/meminfo")
+}
+
+// loadPerCPU returns the load average over the last minute divided by the number of CPUs
+func loadPerCPU() (float64, error) {
+	content, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("could not parse /proc/loadavg")
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return load / float64(runtime.NumCPU()), nil
+}
+
+// runningServices returns the services started by the scaler and not stopped since
+func runningServices() []*Service {
+	servicesMutex.Lock()
+	defer servicesMutex.Unlock()
+	running := []*Service{}
+	for _, service := range services {
+		if service.isRunning() {
+			running = append(running, service)
+		}
+	}
+	return running
+}
+
+// exhaustedResources returns why there are not enough resources to start a service, or an empty string
+func exhaustedResources() string {
+	if maxRunning.Get() > 0 {
+		if running := len(runningServices()); running >= maxRunning.Get() {
+			return fmt.Sprintf("%d services are running", running)
+		}
+	}
+	if minFreeMemory.Get() != "" {
+		minimum, err := units.RAMInBytes(minFreeMemory.Get())
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		} else if available, err := availableMemory(); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		} else if available < minimum {
+			return fmt.Sprintf("%s of memory available", units.BytesSize(float64(available)))
+		}
+	}
+	if maxLoad.Get() > 0 {
+		if load, err := loadPerCPU(); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		} else if load > maxLoad.Get() {
+			return fmt.Sprintf("load of %.2f per CPU", load)
+		}
+	}
+	return ""
+}
+
+// priority returns the priority of the service, 0 by default
+func (service *Service) priority(client *client.Client) int {
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return 0
+	}
+	priority, err := strconv.Atoi(labels[priorityLabel])
+	if err != nil {
+		return 0
+	}
+	return priority
+}
+
+// evict stops the running service with the lowest priority, not higher than the priority of the given service,
+// and least recently requested, reporting whether there was any
+func (service *Service) evict(client *client.Client) bool {
+	priority := service.priority(client)
+	var evicted *Service
+	evictedPriority := 0
+	for _, running := range runningServices() {
+		if running == service {
+			continue
+		}
+		runningPriority := running.priority(client)
+		if runningPriority > priority {
+			continue
+		}
+		if evicted == nil || runningPriority < evictedPriority ||
+			(runningPriority == evictedPriority && running.lastRequestAt.Before(evicted.lastRequestAt)) {
+			evicted = running
+			evictedPriority = runningPriority
+		}
+	}
+	if evicted == nil {
+		return false
+	}
+	audit(evicted.name, "evict", fmt.Sprintf("to start %s (priority %d >= %d)", service.name, priority, evictedPriority), service.requestID)
+	evicted.shutdown(client, "evicted to start "+service.name)
+	return true
+}
+
+// admit reports whether the service can start, or the response to give otherwise
+func (service *Service) admit(client *client.Client) (bool, string) {
+	reason := exhaustedResources()
+	if reason == "" {
+		return true, ""
+	}
+	fmt.Printf("- Service %v cannot start, resources are exhausted: %s\n", service.name, reason)
+	switch onExhausted.Get() {
+	case EVICT:
+		if service.evict(client) {
+			return true, ""
+		}
+	case QUEUE:
+		return false, "starting"
+	}
+	return false, exhaustedResponse
+}
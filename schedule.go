@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to keep it up during time windows
+const (
+	scheduleLabel         = "ondemand.schedule"
+	scheduleTimezoneLabel = "ondemand.schedule.timezone"
+	// timeoutLabel is the timeout of the service when it is woken up by its schedule
+	timeoutLabel = "ondemand.timeout"
+)
+
+const defaultScheduleTimeout = uint64(300)
+
+// scheduleInterval is the delay between two checks of the schedules
+const scheduleInterval = time.Minute
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a time window repeated on some days of the week
+type Window struct {
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+// Schedule is a set of windows during which a service is kept up, in a timezone, except during its exceptions
+type Schedule struct {
+	windows    []Window
+	location   *time.Location
+	exceptions []exception
+}
+
+func parseDays(days string) ([7]bool, error) {
+	parsed := [7]bool{}
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return parsed, fmt.Errorf("%s is not a day (mon, tue...)", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return parsed, fmt.Errorf("%s is not a day (mon, tue...)", bounds[1])
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			parsed[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// parseWindow parses a window like "mon-fri 08:00-18:00", days being optional
+func parseWindow(window string) (Window, error) {
+	fields := strings.Fields(window)
+	parsed := Window{days: [7]bool{true, true, true, true, true, true, true}}
+	if len(fields) == 2 {
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return parsed, err
+		}
+		parsed.days = days
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return parsed, fmt.Errorf("%q should be [days] HH:MM-HH:MM", window)
+	}
+	hours := strings.SplitN(fields[0], "-", 2)
+	if len(hours) != 2 {
+		return parsed, fmt.Errorf("%q should be [days] HH:MM-HH:MM", window)
+	}
+	var err error
+	if parsed.start, err = parseClock(hours[0]); err != nil {
+		return parsed, err
+	}
+	if parsed.end, err = parseClock(hours[1]); err != nil {
+		return parsed, err
+	}
+	return parsed, nil
+}
+
+// parseSchedule parses windows separated by semicolons, returning nil when there is no schedule
+func parseSchedule(labels map[string]string) (*Schedule, error) {
+	schedule, err := parseWindows(labels, scheduleLabel, scheduleTimezoneLabel)
+	if err != nil || schedule == nil {
+		return schedule, err
+	}
+	if err := schedule.parseExceptions(labels, time.Now()); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// parseWindows parses the windows of the label in the timezone of timezoneLabel, returning nil without the label
+func parseWindows(labels map[string]string, label string, timezoneLabel string) (*Schedule, error) {
+	value, ok := labels[label]
+	if !ok {
+		return nil, nil
+	}
+	schedule := &Schedule{location: time.Local}
+	if timezone, ok := labels[timezoneLabel]; ok {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid timezone: %v", timezone, err)
+		}
+		schedule.location = location
+	}
+	for _, window := range strings.Split(value, ";") {
+		if strings.TrimSpace(window) == "" {
+			continue
+		}
+		parsed, err := parseWindow(window)
+		if err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, parsed)
+	}
+	return schedule, nil
+}
+
+// Contains reports whether now is in one of the windows of the schedule, and not in one of its exceptions
+func (schedule *Schedule) Contains(now time.Time) bool {
+	return schedule.inWindows(now) && !schedule.isException(now)
+}
+
+// inWindows reports whether now is in one of the windows of the schedule,
+// a window ending before it starts spanning midnight
+func (schedule *Schedule) inWindows(now time.Time) bool {
+	now = now.In(schedule.location)
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	yesterday := (now.Weekday() + 6) % 7
+	for _, window := range schedule.windows {
+		if window.start <= window.end {
+			if window.days[now.Weekday()] && clock >= window.start && clock < window.end {
+				return true
+			}
+		} else if (window.days[now.Weekday()] && clock >= window.start) || (window.days[yesterday] && clock < window.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSchedule reports whether the service is in one of its schedule windows, in which case it is kept up
+func (service *Service) inSchedule(client *client.Client) bool {
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return false
+	}
+	schedule, err := parseSchedule(labels)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	now := time.Now()
+	if schedule == nil || !schedule.inWindows(now) {
+		return false
+	}
+	if schedule.isException(now) {
+		fmt.Printf("- Service %v schedule is skipped by an exception\n", service.name)
+		return false
+	}
+	fmt.Printf("- Service %v is kept up by its schedule\n", service.name)
+	return true
+}
+
+func parseScheduleTimeout(labels map[string]string) uint64 {
+	if timeout, err := strconv.ParseUint(labels[timeoutLabel], 10, 64); err == nil {
+		return timeout
+	}
+	return defaultScheduleTimeout
+}
+
+// scheduledServices returns the names of the docker services and registrations having a schedule, with their timeout
+func scheduledServices(ctx context.Context, client *client.Client) (map[string]uint64, error) {
+	scheduled := map[string]uint64{}
+	dockerServices, err := client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, dockerService := range dockerServices {
+		if _, ok := dockerService.Spec.Labels[scheduleLabel]; ok {
+			scheduled[dockerService.Spec.Name] = parseScheduleTimeout(dockerService.Spec.Labels)
+		}
+	}
+	registryMutex.RLock()
+	for name, registration := range registrations {
+		if _, ok := registration.Labels[scheduleLabel]; ok {
+			scheduled[name] = registration.Timeout
+			if registration.Timeout == 0 {
+				scheduled[name] = parseScheduleTimeout(registration.Labels)
+			}
+		}
+	}
+	registryMutex.RUnlock()
+	return scheduled, nil
+}
+
+// runSchedules wakes the services up when one of their schedule windows begins
+func runSchedules(client *client.Client) {
+	for {
+		wakeScheduledServices(client)
+		time.Sleep(scheduleInterval)
+	}
+}
+
+// wakeScheduledServices wakes the services up that are in one of their schedule windows, a panic only ending the
+// current round
+func wakeScheduledServices(client *client.Client) {
+	defer reportPanic(nil, "the schedules")
+	scheduled, err := scheduledServices(context.Background(), client)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+	for name, timeout := range scheduled {
+		service := GetOrCreateService(name, timeout)
+		if !service.inSchedule(client) {
+			continue
+		}
+		if _, err := service.HandleServiceState(context.Background(), client); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// maxSessionAge is how long a session that was never ended is considered active
+const maxSessionAge = 12 * time.Hour
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// startSession records an active session of the service, generating its ID when empty
+func (service *Service) startSession(id string) (string, error) {
+	if id == "" {
+		generated, err := newSessionID()
+		if err != nil {
+			return "", err
+		}
+		id = generated
+	}
+	service.sessionsMutex.Lock()
+	defer service.sessionsMutex.Unlock()
+	if service.sessions == nil {
+		service.sessions = map[string]time.Time{}
+	}
+	service.sessions[id] = time.Now()
+	fmt.Printf("- Service %v session %s started, %d active\n", service.name, id, len(service.sessions))
+	return id, nil
+}
+
+// endSession removes an active session of the service, reporting whether it existed
+func (service *Service) endSession(id string) bool {
+	service.sessionsMutex.Lock()
+	defer service.sessionsMutex.Unlock()
+	if _, ok := service.sessions[id]; !ok {
+		return false
+	}
+	delete(service.sessions, id)
+	if len(service.sessions) == 0 {
+		service.sessionsEndedAt = time.Now()
+	}
+	fmt.Printf("- Service %v session %s ended, %d active\n", service.name, id, len(service.sessions))
+	return true
+}
+
+func (service *Service) countSessions() int {
+	service.sessionsMutex.Lock()
+	defer service.sessionsMutex.Unlock()
+	return len(service.sessions)
+}
+
+// hasActiveSessions reports whether the service has active sessions, or had some less than a grace period
+// (the service timeout) ago, in which case its stop is deferred
+func (service *Service) hasActiveSessions() bool {
+	service.sessionsMutex.Lock()
+	defer service.sessionsMutex.Unlock()
+	for id, started := range service.sessions {
+		if time.Since(started) > maxSessionAge {
+			fmt.Printf("- Service %v session %s expired\n", service.name, id)
+			delete(service.sessions, id)
+			if len(service.sessions) == 0 {
+				service.sessionsEndedAt = time.Now()
+			}
+		}
+	}
+	if len(service.sessions) > 0 {
+		fmt.Printf("- Service %v still has %d active sessions\n", service.name, len(service.sessions))
+		return true
+	}
+	grace := time.Duration(service.timeout) * time.Second
+	return !service.sessionsEndedAt.IsZero() && time.Since(service.sessionsEndedAt) < grace
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to declare the services (e.g. a database or a cache) started with it, and stopped
+// a delay after it, so that quick successive wake-ups do not pay their cold start each time
+const (
+	sidecarsLabel     = "ondemand.sidecars"
+	sidecarDelayLabel = "ondemand.sidecars.delay"
+)
+
+// sidecars returns the sidecars of the service and how long they are kept up after it stops
+func (service *Service) sidecars(cli *client.Client) ([]string, time.Duration, error) {
+	labels, err := service.config(context.Background(), cli)
+	if err != nil {
+		return nil, 0, err
+	}
+	sidecars := []string{}
+	for _, name := range strings.Split(labels[sidecarsLabel], ",") {
+		if name = strings.TrimSpace(name); name != "" && relatedService(service.name, name) != service.name {
+			sidecars = append(sidecars, relatedService(service.name, name))
+		}
+	}
+	delay := sidecarDelay.Get()
+	if _, ok := labels[sidecarDelayLabel]; ok {
+		if delay, err = parseDurationLabel(labels, sidecarDelayLabel); err != nil {
+			return nil, 0, err
+		}
+	}
+	return sidecars, delay, nil
+}
+
+// wakeSidecars wakes the sidecars of the service up, cancelling their pending stops
+func (service *Service) wakeSidecars(cli *client.Client) {
+	names, delay, err := service.sidecars(cli)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	for _, name := range names {
+		sidecar := GetOrCreateService(name, uint64(delay.Seconds()))
+		sidecar.sidecarsMutex.Lock()
+		if sidecar.sidecarUsers == nil {
+			sidecar.sidecarUsers = map[string]bool{}
+		}
+		sidecar.sidecarUsers[service.name] = true
+		sidecar.sidecarKeptUntil = time.Time{}
+		sidecar.sidecarsMutex.Unlock()
+		sidecar.requestID = service.requestID
+		fmt.Printf("- Service %v is woken up as a sidecar of %s\n", sidecar.name, service.name)
+		go func(sidecar *Service) {
+			if _, err := sidecar.HandleServiceState(context.Background(), cli); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+		}(sidecar)
+	}
+}
+
+// releaseSidecars lets the sidecars of the stopped service be stopped after their delay, unless a service using them is
+// woken up meanwhile
+func (service *Service) releaseSidecars(cli *client.Client) {
+	names, delay, err := service.sidecars(cli)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	for _, name := range names {
+		sidecar := getService(name)
+		if sidecar == nil {
+			continue
+		}
+		sidecar.sidecarsMutex.Lock()
+		if sidecar.sidecarUsers[service.name] {
+			delete(sidecar.sidecarUsers, service.name)
+			if len(sidecar.sidecarUsers) == 0 {
+				sidecar.sidecarKeptUntil = time.Now().Add(delay)
+				fmt.Printf("- Service %v is stopped in %v, unless %s is woken up again\n", sidecar.name, delay, service.name)
+			}
+		}
+		sidecar.sidecarsMutex.Unlock()
+	}
+}
+
+// isUsedAsSidecar reports whether the service is the sidecar of a service that is up, or that stopped less than the
+// delay of its sidecars ago, in which case its stop is deferred
+func (service *Service) isUsedAsSidecar() bool {
+	service.sidecarsMutex.Lock()
+	defer service.sidecarsMutex.Unlock()
+	return len(service.sidecarUsers) > 0 || time.Now().Before(service.sidecarKeptUntil)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of the requests signed by the plugin with the --hmac-secret shared secret
+const (
+	timestampHeader = "X-Ondemand-Timestamp"
+	signatureHeader = "X-Ondemand-Signature"
+)
+
+// replaySweepInterval is the delay between two removals of the expired signatures from the replay cache
+const replaySweepInterval = 10 * time.Second
+
+// sign returns the hex encoded HMAC-SHA256 of the method, path, service name and timestamp (unix seconds) of a request
+func sign(secret string, method string, path string, service string, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + service + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// replayCache remembers the signatures seen until their timestamp is too old to be accepted anyway
+type replayCache struct {
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+var replays = &replayCache{seen: map[string]time.Time{}}
+
+// seenBefore records a signature valid until expires, reporting whether it was already used
+func (cache *replayCache) seenBefore(signature string, expires time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if _, ok := cache.seen[signature]; ok {
+		return true
+	}
+	cache.seen[signature] = expires
+	return false
+}
+
+// sweep removes the signatures that expired before now
+func (cache *replayCache) sweep(now time.Time) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for signature, expires := range cache.seen {
+		if now.After(expires) {
+			delete(cache.seen, signature)
+		}
+	}
+}
+
+// runReplaySweeps removes the expired signatures from the replay cache every replaySweepInterval
+func runReplaySweeps() {
+	for now := range time.Tick(replaySweepInterval) {
+		replays.sweep(now)
+	}
+}
+
+// verifySignature rejects the requests about service that are not signed with --hmac-secret, with their method and path,
+// whose timestamp is off by more than --hmac-max-skew, or whose signature was already used
+func verifySignature(r *http.Request, service string) error {
+	if *hmacSecret == "" {
+		return nil
+	}
+	timestamp := r.Header.Get(timestampHeader)
+	signature := r.Header.Get(signatureHeader)
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("the request is not signed")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s should be a unix timestamp in seconds", timestampHeader)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if skew := time.Since(signedAt); skew > hmacMaxSkew.Get() || skew < -hmacMaxSkew.Get() {
+		return fmt.Errorf("the request was signed too long ago")
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(*hmacSecret, r.Method, r.URL.Path, service, timestamp))) {
+		return fmt.Errorf("the signature of the request is invalid")
+	}
+	if replays.seenBefore(signature, signedAt.Add(hmacMaxSkew.Get())) {
+		return fmt.Errorf("the request was already received")
+	}
+	return nil
+}
+
+// signedName returns the service name signed by the plugin on a wake request: its name parameter, or its host
+func signedName(r *http.Request) string {
+	if name := r.URL.Query().Get("name"); name != "" {
+		return name
+	}
+	return requestHost(r)
+}
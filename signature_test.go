@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	previousSecret, previousReplays := *hmacSecret, replays
+	*hmacSecret = "secret"
+	replays = &replayCache{seen: map[string]time.Time{}}
+	defer func() { *hmacSecret, replays = previousSecret, previousReplays }()
+
+	now := time.Now().Unix()
+	timestamp := func(offset time.Duration) string {
+		return strconv.FormatInt(now+int64(offset.Seconds()), 10)
+	}
+	replayed := sign("secret", http.MethodGet, "/", "web", timestamp(-time.Second))
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		service   string
+		timestamp string
+		signature string
+		err       string
+	}{
+		{"valid", http.MethodGet, "/?name=web", "web", timestamp(0), sign("secret", http.MethodGet, "/", "web", timestamp(0)), ""},
+		{"valid within the skew", http.MethodGet, "/?name=web", "web", timestamp(-20 * time.Second), sign("secret", http.MethodGet, "/", "web", timestamp(-20*time.Second)), ""},
+		{"valid session", http.MethodPost, "/api/services/web/sessions", "web", timestamp(0), sign("secret", http.MethodPost, "/api/services/web/sessions", "web", timestamp(0)), ""},
+		{"first use", http.MethodGet, "/?name=web", "web", timestamp(-time.Second), replayed, ""},
+		{"replayed", http.MethodGet, "/?name=web", "web", timestamp(-time.Second), replayed, "the request was already received"},
+		{"not signed", http.MethodGet, "/?name=web", "web", "", "", "the request is not signed"},
+		{"malformed timestamp", http.MethodGet, "/?name=web", "web", "yesterday", "00", "X-Ondemand-Timestamp should be a unix timestamp in seconds"},
+		{"expired", http.MethodGet, "/?name=web", "web", timestamp(-time.Minute), sign("secret", http.MethodGet, "/", "web", timestamp(-time.Minute)), "the request was signed too long ago"},
+		{"signed in the future", http.MethodGet, "/?name=web", "web", timestamp(time.Minute), sign("secret", http.MethodGet, "/", "web", timestamp(time.Minute)), "the request was signed too long ago"},
+		{"tampered name", http.MethodGet, "/?name=admin", "admin", timestamp(0), sign("secret", http.MethodGet, "/", "web", timestamp(0)), "the signature of the request is invalid"},
+		{"tampered timestamp", http.MethodGet, "/?name=web", "web", timestamp(-2 * time.Second), sign("secret", http.MethodGet, "/", "web", timestamp(0)), "the signature of the request is invalid"},
+		{"tampered method", http.MethodDelete, "/api/services/web/sessions", "web", timestamp(0), sign("secret", http.MethodPost, "/api/services/web/sessions", "web", timestamp(0)), "the signature of the request is invalid"},
+		{"tampered path", http.MethodPost, "/api/services/web/heartbeat", "web", timestamp(0), sign("secret", http.MethodPost, "/api/services/web/sessions", "web", timestamp(0)), "the signature of the request is invalid"},
+		{"signed with another secret", http.MethodGet, "/?name=web", "web", timestamp(0), sign("other", http.MethodGet, "/", "web", timestamp(0)), "the signature of the request is invalid"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.timestamp != "" {
+				request.Header.Set(timestampHeader, test.timestamp)
+			}
+			if test.signature != "" {
+				request.Header.Set(signatureHeader, test.signature)
+			}
+			err := verifySignature(request, test.service)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected a valid signature, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestReplayCacheSweep(t *testing.T) {
+	cache := &replayCache{seen: map[string]time.Time{}}
+	now := time.Now()
+	cache.seenBefore("expired", now.Add(-time.Second))
+	cache.seenBefore("valid", now.Add(time.Second))
+
+	cache.sweep(now)
+	if _, ok := cache.seen["expired"]; ok {
+		t.Errorf("expected the expired signature to be removed")
+	}
+	if !cache.seenBefore("valid", now.Add(time.Second)) {
+		t.Errorf("expected the valid signature to be kept")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// coldStartSLALabel is used on the docker service to set the target duration of its cold starts (e.g. 20s)
+const coldStartSLALabel = "ondemand.sla.coldstart"
+
+// Reasons of the breaches of the cold start target
+const (
+	slaSlow   = "slow"
+	slaFailed = "failed"
+)
+
+func init() {
+	metrics.Register("ondemand_cold_start_sla_breaches_total", "counter", "Number of starts of a service exceeding its target cold start duration, or failing, by service and reason")
+}
+
+// readColdStartTarget reads the target cold start duration of the service when it is started, zero without one
+func (service *Service) readColdStartTarget(client *client.Client) {
+	service.coldStartTarget = 0
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return
+	}
+	target, err := parseDurationLabel(labels, coldStartSLALabel)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	service.coldStartTarget = target
+}
+
+// checkColdStartSLA reports a breach when the current start of the service, still starting or up after elapsed,
+// exceeds its target
+func (service *Service) checkColdStartSLA(elapsed time.Duration, up bool) {
+	if service.coldStartTarget == 0 || elapsed <= service.coldStartTarget {
+		return
+	}
+	if up {
+		service.breachColdStartSLA(slaSlow, fmt.Sprintf("cold start took %v, target %v", elapsed.Round(time.Millisecond), service.coldStartTarget))
+	} else {
+		service.breachColdStartSLA(slaSlow, fmt.Sprintf("still starting after %v, target %v", elapsed.Round(time.Millisecond), service.coldStartTarget))
+	}
+}
+
+// breachColdStartSLA records, logs and notifies a breach of the target of the current start of the service, once
+func (service *Service) breachColdStartSLA(reason string, message string) {
+	if service.coldStartTarget == 0 || service.startedAt.IsZero() || service.slaBreachedAt.Equal(service.startedAt) {
+		return
+	}
+	service.slaBreachedAt = service.startedAt
+	fmt.Printf("- Service %v breached its cold start target: %s\n", service.name, message)
+	metrics.Add("ondemand_cold_start_sla_breaches_total", 1, "service", service.name, "reason", reason)
+	audit(service.name, "sla-breach", message, service.requestID)
+	notify("sla", service, message)
+}
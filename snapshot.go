@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to commit its containers to images before they are stopped, e.g. for the state
+// of throwaway dev environments not to be lost on idle stops
+const (
+	// snapshotLabel enables the snapshots with "true"
+	snapshotLabel = "ondemand.snapshot"
+	// snapshotKeepLabel is the number of snapshots kept, the older ones being removed
+	snapshotKeepLabel = "ondemand.snapshot.keep"
+	// snapshotRepositoryLabel is the repository of the images, ondemand-snapshot/<service> by default
+	snapshotRepositoryLabel = "ondemand.snapshot.repository"
+)
+
+// defaultSnapshotKeep is the number of snapshots kept without the snapshotKeepLabel
+const defaultSnapshotKeep = 3
+
+// snapshotTagFormat tags the images with the time of the snapshot, which sorts them
+const snapshotTagFormat = "20060102-150405"
+
+func init() {
+	metrics.Register("ondemand_snapshots_total", "counter", "Number of containers of a service committed to an image before its stop, by service and result (ok or error)")
+}
+
+// snapshotConfig returns the repository of the snapshots of the service and how many are kept, an empty repository
+// when it is not snapshotted
+func snapshotConfig(name string, labels map[string]string) (string, int, error) {
+	if labels[snapshotLabel] != "true" {
+		return "", 0, nil
+	}
+	keep := defaultSnapshotKeep
+	if value, ok := labels[snapshotKeepLabel]; ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return "", 0, fmt.Errorf("%s should be a number of snapshots", snapshotKeepLabel)
+		}
+		keep = parsed
+	}
+	repository := labels[snapshotRepositoryLabel]
+	if repository == "" {
+		repository = "ondemand-snapshot/" + strings.ToLower(strings.NewReplacer("@", "-", "/", "-").Replace(name))
+	}
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil || !reference.IsNameOnly(named) {
+		return "", 0, fmt.Errorf("%s should be the name of a repository, without tag", snapshotRepositoryLabel)
+	}
+	return repository, keep, nil
+}
+
+// snapshotReference returns the fully qualified reference of a snapshot, which the docker client requires to commit
+func snapshotReference(repository string, tag string) string {
+	named, err := reference.ParseNormalizedNamed(repository + ":" + tag)
+	if err != nil {
+		return repository + ":" + tag
+	}
+	return named.String()
+}
+
+// snapshot commits the running containers of the service to images of its snapshot repository before it is stopped,
+// keeping its last snapshots. A failed snapshot is logged, the service being stopped anyway
+func (service *Service) snapshot(ctx context.Context, client *client.Client) {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	repository, keep, err := snapshotConfig(service.name, service.labels(dockerService))
+	if err != nil || repository == "" {
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		return
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	tag := time.Now().UTC().Format(snapshotTagFormat)
+	for i, containerID := range containerIDs {
+		snapshotTag := tag
+		if len(containerIDs) > 1 {
+			snapshotTag += "-" + strconv.Itoa(i+1)
+		}
+		// Committing a large container is not bounded by --docker-timeout
+		_, err := containerClient(client, containerID).ContainerCommit(context.Background(), containerID, types.ContainerCommitOptions{
+			Reference: snapshotReference(repository, snapshotTag),
+			Comment:   fmt.Sprintf("Snapshot of service %s before its stop", service.name),
+			Pause:     true,
+		})
+		if err != nil {
+			metrics.Add("ondemand_snapshots_total", 1, "service", service.name, "result", "error")
+			fmt.Printf("Error: could not snapshot container %s of service %s: %+v\n ", containerID, service.name, err)
+			continue
+		}
+		metrics.Add("ondemand_snapshots_total", 1, "service", service.name, "result", "ok")
+		fmt.Printf("- Service %v container %s is snapshotted to %s:%s\n", service.name, containerID, repository, snapshotTag)
+		audit(service.name, "snapshot", repository+":"+snapshotTag, "")
+		if err := pruneSnapshots(ctx, containerClient(client, containerID), repository, keep*len(containerIDs)); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+	}
+}
+
+// pruneSnapshots removes the images of the repository but the keep most recent ones
+func pruneSnapshots(ctx context.Context, client *client.Client, repository string, keep int) error {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("reference", repository)
+	images, err := client.ImageList(ctx, types.ImageListOptions{Filters: filterArgs})
+	if err != nil {
+		return err
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Created > images[j].Created })
+	for _, image := range images[min(keep, len(images)):] {
+		if _, err := client.ImageRemove(ctx, image.ID, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
+			return err
+		}
+		fmt.Printf("- Snapshot %s of %s is removed\n", image.ID, repository)
+	}
+	return nil
+}
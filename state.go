@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// STOPPING represents a service being put down by the scaler
+	STOPPING Status = "stopping"
+	// FAILED represents a service the scaler failed to start
+	FAILED Status = "failed"
+)
+
+// maxTransitions is the number of transitions kept by service
+const maxTransitions = 20
+
+// transitions are the allowed transitions of the state machine of a service, observed transitions included:
+// a service can be started or stopped outside of the scaler
+var transitions = map[Status][]Status{
+	UNKNOWN:    {DOWN, STARTING, UP, STOPPING, FAILED},
+	DOWN:       {STARTING, UP, FAILED},
+	STARTING:   {UP, STOPPING, DOWN, FAILED},
+	UP:         {STOPPING, STARTING, DOWN, FAILED, DRAINING},
+	STOPPING:   {DOWN, UP, FAILED, STOPFAILED},
+	FAILED:     {STARTING, UP, DOWN, STOPPING},
+	STOPFAILED: {STOPPING, UP, DOWN},
+	DRAINING:   {STOPPING, UP, DOWN, FAILED},
+}
+
+// Transition is a change of the state of a service
+type Transition struct {
+	From   Status    `json:"from"`
+	To     Status    `json:"to"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// StateMachine holds the state of a service and its last transitions
+type StateMachine struct {
+	mutex   sync.Mutex
+	state   Status
+	history []Transition
+}
+
+// State returns the current state, UNKNOWN until the first transition
+func (machine *StateMachine) State() Status {
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+	if machine.state == "" {
+		return UNKNOWN
+	}
+	return machine.state
+}
+
+// History returns the last transitions, the most recent last
+func (machine *StateMachine) History() []Transition {
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+	return append([]Transition{}, machine.history...)
+}
+
+// restore sets the state and the last transitions, e.g. imported from another instance
+func (machine *StateMachine) restore(state Status, history []Transition) {
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+	machine.state = state
+	if len(history) > maxTransitions {
+		history = history[len(history)-maxTransitions:]
+	}
+	machine.history = append([]Transition{}, history...)
+}
+
+// transition moves the service to a state, reporting whether the transition is allowed
+func (service *Service) transition(to Status, reason string) bool {
+	machine := &service.machine
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+	from := machine.state
+	if from == "" {
+		from = UNKNOWN
+	}
+	if from == to {
+		return true
+	}
+	allowed := false
+	for _, next := range transitions[from] {
+		allowed = allowed || next == to
+	}
+	if !allowed {
+		fmt.Printf("- Service %v cannot go from %s to %s (%s)\n", service.name, from, to, reason)
+		return false
+	}
+	machine.state = to
+	machine.history = append(machine.history, Transition{From: from, To: to, Time: time.Now(), Reason: reason})
+	if len(machine.history) > maxTransitions {
+		machine.history = machine.history[len(machine.history)-maxTransitions:]
+	}
+	return true
+}
+
+// observe moves the service to the status reported by docker, unless the scaler is stopping it, it failed and is
+// still down or dead, or its stop failed or it is draining and it is still running
+func (service *Service) observe(status Status) {
+	switch service.machine.State() {
+	case STOPPING:
+		if status != DOWN {
+			return
+		}
+	case FAILED:
+		if status == DOWN || service.containerState == containerDead {
+			return
+		}
+	case STOPFAILED, DRAINING:
+		if status != DOWN {
+			return
+		}
+	}
+	service.transition(status, "observed")
+}
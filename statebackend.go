@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultStateKey is the key of the state in redis and etcd
+const defaultStateKey = "ondemand/state"
+
+// redisBackend persists the state in a redis key, with its version in another key
+type redisBackend struct {
+	address  string
+	password string
+	db       int
+	key      string
+}
+
+// redisCompareAndSet sets KEYS[1] to ARGV[2] and increments its version KEYS[2] if it is still ARGV[1]
+const redisCompareAndSet = `if (redis.call('GET', KEYS[2]) or '') == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[2])
+  redis.call('INCR', KEYS[2])
+  return 1
+end
+return 0`
+
+func newRedisBackend(parsed *url.URL, key string) (*redisBackend, error) {
+	backend := &redisBackend{address: parsed.Host, key: key}
+	if parsed.Port() == "" {
+		backend.address = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if password, ok := parsed.User.Password(); ok {
+		backend.password = password
+	}
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		number, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("the redis database should be a number (e.g. redis://redis:6379/0)")
+		}
+		backend.db = number
+	}
+	return backend, nil
+}
+
+// redisConn is a connection speaking the redis protocol (RESP)
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (backend *redisBackend) connect(ctx context.Context) (*redisConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", backend.address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	redis := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if backend.password != "" {
+		if _, err := redis.do("AUTH", backend.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if backend.db != 0 {
+		if _, err := redis.do("SELECT", strconv.Itoa(backend.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return redis, nil
+}
+
+// do sends a command and returns its reply: a string, an int64, nil or a slice of replies
+func (redis *redisConn) do(args ...string) (interface{}, error) {
+	var command bytes.Buffer
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := redis.conn.Write(command.Bytes()); err != nil {
+		return nil, err
+	}
+	return redis.readReply()
+}
+
+func (redis *redisConn) readReply() (interface{}, error) {
+	line, err := redis.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		content := make([]byte, length+2)
+		if _, err := io.ReadFull(redis.reader, content); err != nil {
+			return nil, err
+		}
+		return string(content[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, count)
+		for i := range replies {
+			if replies[i], err = redis.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
+
+func (backend *redisBackend) read(ctx context.Context) ([]byte, string, error) {
+	redis, err := backend.connect(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer redis.conn.Close()
+	reply, err := redis.do("MGET", backend.key, backend.key+":version")
+	if err != nil {
+		return nil, "", err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, "", fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	version, _ := values[1].(string)
+	content, ok := values[0].(string)
+	if !ok {
+		return nil, version, nil
+	}
+	return []byte(content), version, nil
+}
+
+func (backend *redisBackend) write(ctx context.Context, content []byte, version string) (bool, error) {
+	redis, err := backend.connect(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer redis.conn.Close()
+	reply, err := redis.do("EVAL", redisCompareAndSet, "2", backend.key, backend.key+":version", version, string(content))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+// etcdBackend persists the state in an etcd key, through the JSON gateway of the etcd v3 API
+type etcdBackend struct {
+	endpoint string
+	key      string
+}
+
+func (backend *etcdBackend) call(ctx context.Context, method string, request interface{}, response interface{}) error {
+	content, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, backend.endpoint+"/v3/kv/"+method, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpResponse, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd answered %d to %s", httpResponse.StatusCode, method)
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+func (backend *etcdBackend) read(ctx context.Context) ([]byte, string, error) {
+	response := struct {
+		Kvs []struct {
+			Value       string `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}{}
+	request := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(backend.key))}
+	if err := backend.call(ctx, "range", request, &response); err != nil {
+		return nil, "", err
+	}
+	if len(response.Kvs) == 0 {
+		// A key that does not exist has the revision 0
+		return nil, "0", nil
+	}
+	content, err := base64.StdEncoding.DecodeString(response.Kvs[0].Value)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, response.Kvs[0].ModRevision, nil
+}
+
+func (backend *etcdBackend) write(ctx context.Context, content []byte, version string) (bool, error) {
+	key := base64.StdEncoding.EncodeToString([]byte(backend.key))
+	request := map[string]interface{}{
+		"compare": []map[string]string{
+			{"key": key, "target": "MOD", "result": "EQUAL", "mod_revision": version},
+		},
+		"success": []map[string]interface{}{
+			{"request_put": map[string]string{"key": key, "value": base64.StdEncoding.EncodeToString(content)}},
+		},
+	}
+	response := struct {
+		Succeeded bool `json:"succeeded"`
+	}{}
+	if err := backend.call(ctx, "txn", request, &response); err != nil {
+		return false, err
+	}
+	return response.Succeeded, nil
+}
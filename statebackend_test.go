@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedisReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		value interface{}
+		err   string
+	}{
+		{"simple string", "+OK\r\n", "OK", ""},
+		{"error", "-ERR wrong number of arguments\r\n", nil, "redis: ERR wrong number of arguments"},
+		{"integer", ":42\r\n", int64(42), ""},
+		{"bulk string", "$5\r\nhello\r\n", "hello", ""},
+		{"bulk string with a line break", "$7\r\nhel\r\nlo\r\n", "hel\r\nlo", ""},
+		{"empty bulk string", "$0\r\n\r\n", "", ""},
+		{"nil bulk string", "$-1\r\n", nil, ""},
+		{"array", "*3\r\n$5\r\nstate\r\n$-1\r\n:7\r\n", []interface{}{"state", nil, int64(7)}, ""},
+		{"nested array", "*2\r\n*1\r\n+a\r\n*0\r\n", []interface{}{[]interface{}{"a"}, []interface{}{}}, ""},
+		{"nil array", "*-1\r\n", nil, ""},
+		{"empty", "\r\n", nil, "empty redis reply"},
+		{"unexpected type", "!3\r\n", nil, `unexpected redis reply "!3"`},
+		{"truncated bulk string", "$10\r\nhello\r\n", nil, "unexpected EOF"},
+		{"truncated array", "*2\r\n:1\r\n", nil, "EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redis := &redisConn{reader: bufio.NewReader(strings.NewReader(test.reply))}
+			value, err := redis.readReply()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, test.value) {
+				t.Errorf("expected %#v, got %#v", test.value, value)
+			}
+		})
+	}
+}
+
+func TestRedisDo(t *testing.T) {
+	// The arguments are sent as bulk strings, which may contain line breaks
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n"
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		command := make([]byte, 64)
+		n, _ := io.ReadAtLeast(server, command, len(expected))
+		if string(command[:n]) != expected {
+			server.Write([]byte("-ERR unexpected command " + strconv.Quote(string(command[:n])) + "\r\n"))
+			return
+		}
+		server.Write([]byte("+OK\r\n"))
+	}()
+	redis := &redisConn{conn: client, reader: bufio.NewReader(client)}
+	reply, err := redis.do("SET", "key", "va\r\nl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "OK" {
+		t.Errorf("expected OK, got %#v", reply)
+	}
+}
+
+// fakeEtcd implements the range and txn calls of the etcd v3 JSON gateway on a single key
+type fakeEtcd struct {
+	mutex    sync.Mutex
+	value    string
+	revision int64
+}
+
+func (etcd *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	etcd.mutex.Lock()
+	defer etcd.mutex.Unlock()
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		response := map[string]interface{}{}
+		if etcd.revision != 0 {
+			response["kvs"] = []map[string]string{{"value": etcd.value, "mod_revision": strconv.FormatInt(etcd.revision, 10)}}
+		}
+		json.NewEncoder(w).Encode(response)
+	case "/v3/kv/txn":
+		request := struct {
+			Compare []struct {
+				Target      string `json:"target"`
+				Result      string `json:"result"`
+				ModRevision string `json:"mod_revision"`
+			} `json:"compare"`
+			Success []struct {
+				RequestPut struct {
+					Value string `json:"value"`
+				} `json:"request_put"`
+			} `json:"success"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Compare) != 1 || len(request.Success) != 1 {
+			http.Error(w, "unexpected txn", http.StatusBadRequest)
+			return
+		}
+		compare := request.Compare[0]
+		if compare.Target != "MOD" || compare.Result != "EQUAL" {
+			http.Error(w, "unexpected compare", http.StatusBadRequest)
+			return
+		}
+		succeeded := compare.ModRevision == strconv.FormatInt(etcd.revision, 10)
+		if succeeded {
+			etcd.value = request.Success[0].RequestPut.Value
+			etcd.revision++
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"succeeded": succeeded})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdCompareAndSwap(t *testing.T) {
+	server := httptest.NewServer(&fakeEtcd{})
+	defer server.Close()
+	backend := &etcdBackend{endpoint: server.URL, key: defaultStateKey}
+	ctx := context.Background()
+
+	content, version, err := backend.read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != nil || version != "0" {
+		t.Fatalf("expected no state at revision 0, got %q at %s", content, version)
+	}
+	if ok, err := backend.write(ctx, []byte(`{"services":{}}`), version); err != nil || !ok {
+		t.Fatalf("expected the first write to succeed, got %v, %v", ok, err)
+	}
+
+	content, version, err = backend.read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"services":{}}` || version != "1" {
+		t.Fatalf("expected the written state at revision 1, got %q at %s", content, version)
+	}
+
+	// Another instance writes first: the write based on the read revision is rejected
+	if ok, err := backend.write(ctx, []byte(`{"services":{"other":10}}`), version); err != nil || !ok {
+		t.Fatalf("expected the concurrent write to succeed, got %v, %v", ok, err)
+	}
+	if ok, err := backend.write(ctx, []byte(`{"services":{"web":10}}`), version); err != nil || ok {
+		t.Fatalf("expected the stale write to be rejected, got %v, %v", ok, err)
+	}
+	content, version, err = backend.read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"services":{"other":10}}` || version != "2" {
+		t.Fatalf("expected the concurrent state at revision 2, got %q at %s", content, version)
+	}
+}
+
+func TestEtcdRequests(t *testing.T) {
+	etcd := &fakeEtcd{}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	backend := &etcdBackend{endpoint: server.URL, key: defaultStateKey}
+
+	if _, err := backend.write(context.Background(), []byte("state"), "0"); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(etcd.value); string(decoded) != "state" {
+		t.Errorf("expected the value to be sent base64 encoded, got %q", etcd.value)
+	}
+
+	failing := &etcdBackend{endpoint: server.URL + "/unknown", key: defaultStateKey}
+	if _, _, err := failing.read(context.Background()); err == nil || err.Error() != "etcd answered 404 to range" {
+		t.Errorf("expected the status of etcd, got %v", err)
+	}
+}
+
+func TestStoreLoadPartialState(t *testing.T) {
+	path := t.TempDir() + "/state.json"
+	if err := ioutil.WriteFile(path, []byte(`{"registrations":{},"services":{"web":60}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{backend: &fileBackend{path: path}}
+	// The maps missing from the persisted state can be written to
+	err := store.Update(func(state *State) {
+		state.Deadlines["web"] = 1700000000
+		state.Networks["web"] = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Services["web"] != 60 || state.Deadlines["web"] != 1700000000 {
+		t.Errorf("expected the service with its idle deadline, got %v and %v", state.Services, state.Deadlines)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Label used on the docker service to configure its cost per hour when running, to estimate the money saved
+const costLabel = "ondemand.cost.hour"
+
+// serviceStats are the cumulated statistics of a service since the scaler first started it
+type serviceStats struct {
+	since     time.Time
+	wakeUps   int
+	running   time.Duration
+	coldStart time.Duration
+	// coldStarts is the number of starts whose duration is in coldStart
+	coldStarts int
+}
+
+func init() {
+	metrics.Register("ondemand_wakeups_total", "counter", "Number of starts of a service by the scaler")
+	metrics.Register("ondemand_cold_starts_total", "counter", "Number of starts of a service that got up")
+	metrics.Register("ondemand_cold_start_duration_seconds_total", "counter", "Cumulated duration of the starts of a service, from the wake-up until it is up")
+	metrics.Register("ondemand_running_services", "gauge", "Number of services started by the scaler and running")
+}
+
+var statsMutex sync.Mutex
+var statistics = map[string]*serviceStats{}
+
+func getStats(name string, now time.Time) *serviceStats {
+	stats, ok := statistics[name]
+	if !ok {
+		stats = &serviceStats{since: now}
+		statistics[name] = stats
+	}
+	return stats
+}
+
+// recordWakeUp counts a start of the service by the scaler
+func recordWakeUp(name string, now time.Time) {
+	metrics.Add("ondemand_wakeups_total", 1, "service", name)
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	getStats(name, now).wakeUps++
+}
+
+// recordColdStart adds the duration of a start of the service, from the wake-up until it is up
+func recordColdStart(name string, duration time.Duration) {
+	metrics.Add("ondemand_cold_starts_total", 1, "service", name)
+	metrics.Add("ondemand_cold_start_duration_seconds_total", duration.Seconds(), "service", name)
+	statsd.Timing("ondemand_cold_start", float64(duration.Milliseconds()), "service", name)
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	stats := getStats(name, time.Now())
+	stats.coldStart += duration
+	stats.coldStarts++
+}
+
+// recordRunning adds the running time of the service between a start and a stop
+func recordRunning(name string, duration time.Duration) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	getStats(name, time.Now()).running += duration
+}
+
+// averageColdStart returns the average duration of the starts of the service, 0 when it was never started
+func averageColdStart(name string) time.Duration {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	stats, ok := statistics[name]
+	if !ok || stats.coldStarts == 0 {
+		return 0
+	}
+	return stats.coldStart / time.Duration(stats.coldStarts)
+}
+
+// updateRunningServices updates the gauge of the services started by the scaler and running
+func updateRunningServices() {
+	metrics.Set("ondemand_running_services", float64(len(runningServices())))
+}
+
+// StatsReport is the usage report of a service, or the total of all of them
+type StatsReport struct {
+	Name    string    `json:"name,omitempty"`
+	Since   time.Time `json:"since"`
+	WakeUps int       `json:"wakeUps"`
+	// RunningSeconds is the cumulated running time, the current run included
+	RunningSeconds float64 `json:"runningSeconds"`
+	// AverageColdStartSeconds is the average duration of the starts, from the wake-up until the service is up
+	AverageColdStartSeconds float64 `json:"averageColdStartSeconds"`
+	// SavedHours is the time the service did not run, compared to running it all the time since
+	SavedHours float64 `json:"savedHours"`
+	// CostPerHour is the cost of the service when running, CostSaved the cost of the saved hours
+	CostPerHour float64 `json:"costPerHour,omitempty"`
+	CostSaved   float64 `json:"costSaved,omitempty"`
+	// Shadow is true for the services whose starts and stops are simulated, for which the savings are an estimate
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// statsResponse is the response of GET /api/stats
+type statsResponse struct {
+	Services []StatsReport `json:"services"`
+	Total    StatsReport   `json:"total"`
+}
+
+// reportStats returns the usage report of every service of the namespace started by the scaler
+func reportStats(now time.Time, namespace string) statsResponse {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	response := statsResponse{Services: []StatsReport{}}
+	var coldStart time.Duration
+	coldStarts := 0
+	for name, stats := range statistics {
+		if !inNamespace(namespace, name) {
+			continue
+		}
+		running := stats.running
+		shadow := false
+		if service := getService(name); service != nil {
+			if service.isRunning() {
+				running += now.Sub(service.startedAt)
+			}
+			shadow = service.shadow
+		}
+		report := StatsReport{
+			Name:           name,
+			Since:          stats.since,
+			WakeUps:        stats.wakeUps,
+			RunningSeconds: running.Seconds(),
+			SavedHours:     (now.Sub(stats.since) - running).Hours(),
+			Shadow:         shadow,
+		}
+		if stats.coldStarts > 0 {
+			report.AverageColdStartSeconds = (stats.coldStart / time.Duration(stats.coldStarts)).Seconds()
+		}
+		response.Services = append(response.Services, report)
+		if response.Total.Since.IsZero() || stats.since.Before(response.Total.Since) {
+			response.Total.Since = stats.since
+		}
+		response.Total.WakeUps += report.WakeUps
+		response.Total.RunningSeconds += report.RunningSeconds
+		response.Total.SavedHours += report.SavedHours
+		coldStart += stats.coldStart
+		coldStarts += stats.coldStarts
+	}
+	if coldStarts > 0 {
+		response.Total.AverageColdStartSeconds = (coldStart / time.Duration(coldStarts)).Seconds()
+	}
+	sort.Slice(response.Services, func(i, j int) bool {
+		return response.Services[i].Name < response.Services[j].Name
+	})
+	return response
+}
+
+// writeStatsCSV writes the reports of the services, one line each
+func writeStatsCSV(w http.ResponseWriter, response statsResponse) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="ondemand-stats.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"name", "since", "wake_ups", "running_seconds", "average_cold_start_seconds", "saved_hours", "cost_per_hour", "cost_saved"})
+	for _, report := range response.Services {
+		writer.Write([]string{
+			report.Name,
+			report.Since.Format(time.RFC3339),
+			strconv.Itoa(report.WakeUps),
+			strconv.FormatFloat(report.RunningSeconds, 'f', 0, 64),
+			strconv.FormatFloat(report.AverageColdStartSeconds, 'f', 1, 64),
+			strconv.FormatFloat(report.SavedHours, 'f', 2, 64),
+			strconv.FormatFloat(report.CostPerHour, 'f', -1, 64),
+			strconv.FormatFloat(report.CostSaved, 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+}
+
+// costPerHour returns the cost per hour of the service, from its label or --cost-per-hour
+func (service *Service) costPerHour(ctx context.Context, client *client.Client) (float64, error) {
+	labels, err := service.config(ctx, client)
+	if err != nil {
+		return defaultCostPerHour.Get(), nil
+	}
+	cost, ok := labels[costLabel]
+	if !ok {
+		return defaultCostPerHour.Get(), nil
+	}
+	costPerHour, err := strconv.ParseFloat(cost, 64)
+	if err != nil || costPerHour < 0 {
+		return 0, fmt.Errorf("%s should be a positive number: %s", costLabel, cost)
+	}
+	return costPerHour, nil
+}
+
+// estimateCosts adds the cost saved by each service to the report and to its total
+func estimateCosts(ctx context.Context, cli *client.Client, response *statsResponse) {
+	for i := range response.Services {
+		report := &response.Services[i]
+		service := getService(report.Name)
+		if service == nil {
+			service = &Service{name: report.Name}
+		}
+		costPerHour, err := service.costPerHour(ctx, cli)
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		report.CostPerHour = costPerHour
+		report.CostSaved = report.SavedHours * costPerHour
+		response.Total.CostSaved += report.CostSaved
+	}
+}
+
+// handleStatsAPI serves GET /api/stats, as CSV with format=csv
+func handleStatsAPI(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+			return
+		}
+		response := reportStats(time.Now(), requestNamespace(r))
+		estimateCosts(r.Context(), cli, &response)
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			writeJSON(w, http.StatusOK, response)
+		case "csv":
+			writeStatsCSV(w, response)
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Errorf("format should be json or csv"))
+		}
+	}
+}
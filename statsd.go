@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Statsd sends the metrics to a statsd or DogStatsD agent over UDP, as they are updated
+type Statsd struct {
+	conn   net.Conn
+	prefix string
+	// tagged sends the labels as DogStatsD tags, instead of appending their values to the metric names
+	tagged bool
+}
+
+// statsd is nil unless --statsd is set
+var statsd *Statsd
+
+func newStatsd(address string, prefix string, tagged bool) (*Statsd, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Statsd{conn: conn, prefix: prefix, tagged: tagged}, nil
+}
+
+// send sends a metric of a statsd type (c, g or ms), labels being name/value pairs; a nil Statsd sends nothing
+func (statsd *Statsd) send(name string, value float64, kind string, labels []string) {
+	if statsd == nil {
+		return
+	}
+	name = statsd.prefix + strings.TrimPrefix(name, "ondemand_")
+	tags := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		if statsd.tagged {
+			tags = append(tags, labels[i]+":"+labels[i+1])
+		} else {
+			name += "." + sanitizeStatsdName(labels[i+1])
+		}
+	}
+	line := fmt.Sprintf("%s:%s|%s", name, strconv.FormatFloat(value, 'f', -1, 64), kind)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	// Metrics are best effort, a missing agent must not fail the scaler
+	statsd.conn.Write([]byte(line))
+}
+
+// sanitizeStatsdName replaces the characters statsd uses as separators in a label value
+func sanitizeStatsdName(value string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ".", "_", "/", "_").Replace(value)
+}
+
+// Timing sends a duration in milliseconds, which Prometheus gets as counters instead
+func (statsd *Statsd) Timing(name string, milliseconds float64, labels ...string) {
+	statsd.send(name, milliseconds, "ms", labels)
+}
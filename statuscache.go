@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// swarmServiceNameAttribute is the attribute of the container events giving the swarm service of the container
+const swarmServiceNameAttribute = "com.docker.swarm.service.name"
+
+// eventsRetryInterval is the delay before subscribing to the docker events again after an error
+const eventsRetryInterval = 5 * time.Second
+
+type cachedStatus struct {
+	status Status
+	at     time.Time
+}
+
+var statusCacheMutex sync.Mutex
+
+// statusCache holds the statuses by docker service name, which the events name, whatever name they were requested by
+var statusCache = map[string]cachedStatus{}
+
+// statusNames holds the docker service names of the cached statuses, by the name they were requested by (alias,
+// selector, compose name...)
+var statusNames = map[string]string{}
+
+// cacheKey returns the docker service name of a requested name, the name itself when it is not cached
+func cacheKey(name string) string {
+	if dockerName, ok := statusNames[name]; ok {
+		return dockerName
+	}
+	return name
+}
+
+// getCachedStatus returns the status of the service read less than --status-cache ago
+func getCachedStatus(name string, now time.Time) (Status, bool) {
+	if statusCacheTTL.Get() <= 0 {
+		return "", false
+	}
+	statusCacheMutex.Lock()
+	defer statusCacheMutex.Unlock()
+	cached, ok := statusCache[cacheKey(name)]
+	if !ok || now.Sub(cached.at) >= statusCacheTTL.Get() {
+		return "", false
+	}
+	return cached.status, true
+}
+
+// cacheStatus caches the status of the service requested by name, read from the docker service dockerName,
+// the name itself when the service has none yet
+func cacheStatus(name string, dockerName string, status Status, now time.Time) {
+	if statusCacheTTL.Get() <= 0 {
+		return
+	}
+	statusCacheMutex.Lock()
+	defer statusCacheMutex.Unlock()
+	for cachedName, cached := range statusCache {
+		if now.Sub(cached.at) >= statusCacheTTL.Get() {
+			delete(statusCache, cachedName)
+		}
+	}
+	for requested, cachedName := range statusNames {
+		if _, ok := statusCache[cachedName]; !ok {
+			delete(statusNames, requested)
+		}
+	}
+	if dockerName == "" {
+		dockerName = name
+	}
+	statusNames[name] = dockerName
+	statusCache[dockerName] = cachedStatus{status, now}
+}
+
+// invalidateStatus forgets the cached status of the service, by its name or the name of its docker service,
+// once it was started or stopped
+func invalidateStatus(name string) {
+	statusCacheMutex.Lock()
+	defer statusCacheMutex.Unlock()
+	delete(statusCache, cacheKey(name))
+}
+
+// watchContainerEvents invalidates the cached status of the services whose containers change on the docker host
+// (started, died, paused, health...), the events of the other nodes not being seen
+func watchContainerEvents(cli *client.Client) {
+	for {
+		arguments := filters.NewArgs()
+		arguments.Add("type", events.ContainerEventType)
+		messages, errs := cli.Events(context.Background(), types.EventsOptions{Filters: arguments})
+	receive:
+		for {
+			select {
+			case message := <-messages:
+				if name := message.Actor.Attributes[swarmServiceNameAttribute]; name != "" {
+					invalidateStatus(name)
+				}
+			case err := <-errs:
+				fmt.Printf("Error: %+v\n ", err)
+				break receive
+			}
+		}
+		time.Sleep(eventsRetryInterval)
+	}
+}
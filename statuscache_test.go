@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusCacheAliases(t *testing.T) {
+	defer func() {
+		statusCache = map[string]cachedStatus{}
+		statusNames = map[string]string{}
+	}()
+	now := time.Now()
+
+	// A service requested by an alias is cached under the name of its docker service
+	cacheStatus("app", "stack_web", UP, now)
+	if status, ok := getCachedStatus("app", now); !ok || status != UP {
+		t.Fatalf("expected the cached status of the alias, got %v, %v", status, ok)
+	}
+	// The events name the docker service
+	invalidateStatus("stack_web")
+	if _, ok := getCachedStatus("app", now); ok {
+		t.Errorf("expected the status of the alias to be invalidated with its docker service")
+	}
+
+	// The scaler invalidates the status by the requested name
+	cacheStatus("app", "stack_web", UP, now)
+	invalidateStatus("app")
+	if _, ok := getCachedStatus("stack_web", now); ok {
+		t.Errorf("expected the status of the docker service to be invalidated with its alias")
+	}
+
+	// A service without docker service yet is cached under its name, and expires
+	cacheStatus("defined", "", DOWN, now)
+	if status, ok := getCachedStatus("defined", now); !ok || status != DOWN {
+		t.Errorf("expected the cached status of the service, got %v, %v", status, ok)
+	}
+	if _, ok := getCachedStatus("defined", now.Add(statusCacheTTL.Get())); ok {
+		t.Errorf("expected the status to expire")
+	}
+	cacheStatus("other", "other", UP, now.Add(statusCacheTTL.Get()))
+	if _, ok := statusNames["defined"]; ok {
+		t.Errorf("expected the name of the expired status to be forgotten")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to configure how its containers are stopped
+const (
+	stopTimeoutLabel = "ondemand.stop.timeout"
+	stopSignalLabel  = "ondemand.stop.signal"
+)
+
+func parseStopOptions(labels map[string]string) (*time.Duration, string, error) {
+	var timeout *time.Duration
+	if value, ok := labels[stopTimeoutLabel]; ok {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s should be a duration (e.g. 30s)", stopTimeoutLabel)
+		}
+		timeout = &duration
+	}
+	return timeout, labels[stopSignalLabel], nil
+}
+
+// prepareStop sends the configured stop signal to the containers of the service
+// and sets the configured grace period on its spec before it is scaled down.
+// The service is scaled down right after the signal, without waiting for the containers to exit:
+// swarm would otherwise replace the exited tasks per their restart policy. Swarm then sends SIGTERM
+// to the containers still running and kills them after the grace period
+func (service *Service) prepareStop(ctx context.Context, client *client.Client, dockerService *swarm.Service) error {
+	timeout, signal, err := parseStopOptions(service.labels(dockerService))
+	if err != nil {
+		return err
+	}
+	if timeout == nil && windowsDaemon && dockerService.Spec.TaskTemplate.ContainerSpec.StopGracePeriod == nil {
+		grace := windowsStopTimeout
+		timeout = &grace
+	}
+	if timeout != nil {
+		dockerService.Spec.TaskTemplate.ContainerSpec.StopGracePeriod = timeout
+	}
+	if signal != "" && windowsDaemon {
+		// Windows containers are only sent a shutdown event when stopped
+		fmt.Printf("- Service %v: %s is ignored by Windows containers\n", service.name, stopSignalLabel)
+		return nil
+	}
+	if signal == "" {
+		return nil
+	}
+
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		return err
+	}
+	for _, containerID := range containerIDs {
+		fmt.Printf("Sending %s to container %s of service %s\n", signal, containerID, service.name)
+		if err := containerClient(client, containerID).ContainerKill(ctx, containerID, signal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
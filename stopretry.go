@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// STOPFAILED represents a service the scaler failed to stop, whose stop is retried
+const STOPFAILED Status = "stop-failed"
+
+// Delays between the attempts to stop a service whose stop failed, doubled at each attempt
+const (
+	minStopRetry = 10 * time.Second
+	maxStopRetry = 5 * time.Minute
+)
+
+func init() {
+	metrics.Register("ondemand_stop_failures_total", "counter", "Number of failed stops of a service, each one retried, by service")
+}
+
+// stopRetry is the next attempt to stop a service whose stop failed
+type stopRetry struct {
+	attempts int
+	delay    time.Duration
+	at       time.Time
+	// failedAt is when the stop first failed, the retries being cancelled when the service is requested since
+	failedAt time.Time
+}
+
+// stopRetries are the services whose stop failed, by name
+var stopRetries = map[string]*stopRetry{}
+var stopRetriesMutex sync.Mutex
+
+// scheduleStopRetry retries the failed stop of the service, with a backoff, until it succeeds or the service is
+// requested again
+func (service *Service) scheduleStopRetry(client *client.Client, reason string, stop func(client *client.Client) error, err error) {
+	metrics.Add("ondemand_stop_failures_total", 1, "service", service.name)
+	stopRetriesMutex.Lock()
+	retry := stopRetries[service.name]
+	if retry == nil {
+		retry = &stopRetry{delay: minStopRetry, failedAt: time.Now()}
+		stopRetries[service.name] = retry
+	} else if retry.delay *= 2; retry.delay > maxStopRetry {
+		retry.delay = maxStopRetry
+	}
+	retry.attempts++
+	retry.at = time.Now().Add(retry.delay)
+	delay, attempts := retry.delay, retry.attempts
+	stopRetriesMutex.Unlock()
+	message := fmt.Sprintf("stop failed %d times: %v, retrying in %v", attempts, err, delay)
+	fmt.Printf("- Service %v %s\n", service.name, message)
+	if attempts == 1 {
+		notify("stop-failed", service, message)
+	}
+	time.AfterFunc(delay, func() {
+		service.retryStop(client, reason, stop)
+	})
+}
+
+// retryStop stops the service again, unless it was stopped meanwhile, or requested since its stop failed in which case
+// it is handled again until it is idle
+func (service *Service) retryStop(client *client.Client, reason string, stop func(client *client.Client) error) {
+	stopRetriesMutex.Lock()
+	retry := stopRetries[service.name]
+	stopRetriesMutex.Unlock()
+	if retry == nil || service.machine.State() != STOPFAILED {
+		clearStopRetry(service.name)
+		return
+	}
+	if service.lastRequestAt.After(retry.failedAt) {
+		clearStopRetry(service.name)
+		fmt.Printf("- Service %v is requested again, its failed stop is not retried\n", service.name)
+		service.transition(UP, "requested")
+		go service.stopAfterTimeout(client)
+		select {
+		case service.time <- service.effectiveTimeout():
+		default:
+		}
+		return
+	}
+	service.shutdownWith(client, reason, stop)
+}
+
+// clearStopRetry forgets the failed stop of the service
+func clearStopRetry(name string) {
+	stopRetriesMutex.Lock()
+	defer stopRetriesMutex.Unlock()
+	delete(stopRetries, name)
+}
+
+// getStopRetry returns the time of the next attempt to stop the service, zero when its stop did not fail
+func getStopRetry(name string) (time.Time, int) {
+	stopRetriesMutex.Lock()
+	defer stopRetriesMutex.Unlock()
+	if retry := stopRetries[name]; retry != nil {
+		return retry.at, retry.attempts
+	}
+	return time.Time{}, 0
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// State is the part of the scaler state that survives restarts
+type State struct {
+	Registrations map[string]*Registration `json:"registrations"`
+	// Usage holds, by service, the beginning of the recent time slots during which it was requested
+	Usage map[string][]int64 `json:"usage,omitempty"`
+	// Predictions holds the overrides of the predicted wake-ups, by service
+	Predictions map[string]*PredictionOverride `json:"predictions,omitempty"`
+	// Services holds the timeouts of the services handled by the leader, by name
+	Services map[string]uint64 `json:"services,omitempty"`
+	// Deadlines holds the idle deadlines of the services handled by the leader, as Unix times, by name
+	Deadlines map[string]int64 `json:"deadlines,omitempty"`
+	// Removed holds the specs of the services removed by the remove strategy, by name
+	Removed map[string]*swarm.ServiceSpec `json:"removed,omitempty"`
+	// Networks holds the networks removed with the services, by service
+	Networks map[string][]RemovedNetwork `json:"networks,omitempty"`
+	// Config holds the runtime flags changed through the config API, by name
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// StateBackend is where the state is persisted, shared by the instances for the redis and etcd backends
+type StateBackend interface {
+	// read returns the persisted state, nil when nothing was persisted yet, and its version
+	read(ctx context.Context) ([]byte, string, error)
+	// write persists the state if it is still at version, reporting whether it was
+	write(ctx context.Context, content []byte, version string) (bool, error)
+}
+
+// Store persists the state in its backend, or nowhere when it has none
+type Store struct {
+	backend StateBackend
+	mutex   sync.Mutex
+}
+
+var store = &Store{}
+
+// storeTimeout bounds each access to the backend
+const storeTimeout = 5 * time.Second
+
+// maxStoreConflicts is how many times an update is retried when another instance updated the state meanwhile
+const maxStoreConflicts = 10
+
+// parseStateBackend returns the backend of a --state value: a file path, redis://host:port[/db][#key]
+// or etcd://host:port[#key]
+func parseStateBackend(state string) (StateBackend, error) {
+	if state == "" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(state)
+	if err != nil || parsed.Scheme == "" {
+		return &fileBackend{path: state}, nil
+	}
+	key := parsed.Fragment
+	if key == "" {
+		key = defaultStateKey
+	}
+	switch parsed.Scheme {
+	case "redis":
+		return newRedisBackend(parsed, key)
+	case "etcd":
+		return &etcdBackend{endpoint: "http://" + parsed.Host, key: key}, nil
+	case "file":
+		return &fileBackend{path: parsed.Path}, nil
+	}
+	return nil, fmt.Errorf("--state should be a file path, a redis:// or an etcd:// URL")
+}
+
+// Load reads the persisted state, which is empty when nothing was persisted yet
+func (store *Store) Load() (*State, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, _, err := store.load()
+	return state, err
+}
+
+// Save persists the state, replacing the persisted one
+func (store *Store) Save(state *State) error {
+	return store.Update(func(persisted *State) {
+		*persisted = *state
+	})
+}
+
+// Update loads the persisted state, applies update to it and persists it,
+// starting over when another instance updated it meanwhile
+func (store *Store) Update(update func(state *State)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for attempt := 0; attempt < maxStoreConflicts; attempt++ {
+		state, version, err := store.load()
+		if err != nil {
+			return err
+		}
+		update(state)
+		saved, err := store.save(state, version)
+		if err != nil || saved {
+			return err
+		}
+	}
+	return fmt.Errorf("could not persist the state: too many concurrent updates")
+}
+
+func (store *Store) load() (*State, string, error) {
+	state := &State{
+		Registrations: map[string]*Registration{},
+		Usage:         map[string][]int64{},
+		Predictions:   map[string]*PredictionOverride{},
+		Services:      map[string]uint64{},
+		Deadlines:     map[string]int64{},
+		Removed:       map[string]*swarm.ServiceSpec{},
+		Networks:      map[string][]RemovedNetwork{},
+		Config:        map[string]string{},
+	}
+	if store.backend == nil {
+		return state, "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	content, version, err := store.backend.read(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if content == nil {
+		return state, version, nil
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, "", err
+	}
+	if state.Registrations == nil {
+		state.Registrations = map[string]*Registration{}
+	}
+	if state.Usage == nil {
+		state.Usage = map[string][]int64{}
+	}
+	if state.Predictions == nil {
+		state.Predictions = map[string]*PredictionOverride{}
+	}
+	if state.Services == nil {
+		state.Services = map[string]uint64{}
+	}
+	if state.Deadlines == nil {
+		state.Deadlines = map[string]int64{}
+	}
+	if state.Removed == nil {
+		state.Removed = map[string]*swarm.ServiceSpec{}
+	}
+	if state.Networks == nil {
+		state.Networks = map[string][]RemovedNetwork{}
+	}
+	if state.Config == nil {
+		state.Config = map[string]string{}
+	}
+	return state, version, nil
+}
+
+func (store *Store) save(state *State, version string) (bool, error) {
+	if store.backend == nil {
+		return true, nil
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return store.backend.write(ctx, content, version)
+}
+
+// fileBackend persists the state in a JSON file, for a single instance or instances sharing a volume
+type fileBackend struct {
+	path string
+}
+
+func (backend *fileBackend) read(ctx context.Context) ([]byte, string, error) {
+	content, err := ioutil.ReadFile(backend.path)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	return content, "", err
+}
+
+// write replaces the file atomically, the file having no version
+func (backend *fileBackend) write(ctx context.Context, content []byte, version string) (bool, error) {
+	temporary := backend.path + ".tmp"
+	if err := ioutil.WriteFile(temporary, content, 0600); err != nil {
+		return false, err
+	}
+	return true, os.Rename(temporary, backend.path)
+}
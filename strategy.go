@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/client"
+)
+
+// Label used on the docker service to configure how it is put down when idle
+const strategyLabel = "ondemand.strategy"
+
+// Strategy is the way a service is put down when idle
+type Strategy string
+
+const (
+	// SCALE scales the service down to zero replica
+	SCALE Strategy = "scale"
+	// STOP is SCALE: swarm stops the service containers when it is scaled down
+	STOP Strategy = "stop"
+	// REMOVE removes the service, and creates it again from its last spec on demand, for services that should leave no trace
+	REMOVE Strategy = "remove"
+	// PAUSE freezes the service containers, keeping their memory, for a near-instant wake-up
+	PAUSE Strategy = "pause"
+	// WARM replaces the service containers by new ones, paused once ready, to be promoted instantly on demand
+	WARM Strategy = "warm"
+	// CHECKPOINT saves the service containers to disk and restores them on demand (experimental)
+	CHECKPOINT Strategy = "checkpoint"
+)
+
+func parseStrategy(labels map[string]string) (Strategy, error) {
+	strategy, ok := labels[strategyLabel]
+	if !ok {
+		return SCALE, nil
+	}
+	switch Strategy(strategy) {
+	case SCALE, STOP:
+		return SCALE, nil
+	case PAUSE, WARM, REMOVE:
+		return Strategy(strategy), nil
+	case CHECKPOINT:
+		if !checkpointSupported {
+			return "", fmt.Errorf("%s strategy is not supported, it requires --experimental-checkpoint and an experimental docker daemon", CHECKPOINT)
+		}
+		return CHECKPOINT, nil
+	default:
+		return "", fmt.Errorf("%s should be one of %s, %s, %s, %s, %s, %s", strategyLabel, SCALE, STOP, PAUSE, WARM, REMOVE, CHECKPOINT)
+	}
+}
+
+// getPausedContainers returns the containers among containerIDs that are paused
+func getPausedContainers(ctx context.Context, client *client.Client, containerIDs []string) ([]string, error) {
+	paused := []string{}
+	for _, containerID := range containerIDs {
+		container, err := containerClient(client, containerID).ContainerInspect(ctx, containerID)
+		if err != nil {
+			return nil, err
+		}
+		if container.State.Paused {
+			paused = append(paused, containerID)
+		}
+	}
+	return paused, nil
+}
+
+func (service *Service) pause(ctx context.Context, client *client.Client) error {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return err
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		return err
+	}
+	for _, containerID := range containerIDs {
+		if err := containerClient(client, containerID).ContainerPause(ctx, containerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpause unpauses the paused containers of the service and reports whether there was any
+func (service *Service) unpause(ctx context.Context, client *client.Client) (bool, error) {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		return false, err
+	}
+	paused, err := getPausedContainers(ctx, client, containerIDs)
+	if err != nil {
+		return false, err
+	}
+	for _, containerID := range paused {
+		if err := containerClient(client, containerID).ContainerUnpause(ctx, containerID); err != nil {
+			return false, err
+		}
+	}
+	return len(paused) > 0, nil
+}
+
+// wake brings the service up according to its strategy
+func (service *Service) wake(client *client.Client) error {
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	if service.members != nil {
+		var err error
+		for _, member := range service.members {
+			if member.status == DOWN {
+				if memberErr := member.wake(client); memberErr != nil {
+					err = memberErr
+				}
+			}
+		}
+		return err
+	}
+	release, err := dockerPool.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if service.missing {
+		span := service.span("create")
+		err := service.recreate(ctx, client)
+		span.SetError(err)
+		span.End()
+		return err
+	}
+	if service.strategy == PAUSE || service.strategy == WARM || service.containerState == containerPaused {
+		span := service.span("unpause")
+		unpaused, err := service.unpause(ctx, client)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			// Scaling up still wakes the service up when its containers cannot be unpaused
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		if !unpaused {
+			return service.setServiceReplicas(client, 1)
+		}
+		return nil
+	}
+	if service.strategy == CHECKPOINT && len(service.checkpointed) > 0 {
+		span := service.span("restore")
+		// Restoring the memory of the containers is not bounded by --docker-timeout
+		err := service.restore(context.Background(), client)
+		span.SetError(err)
+		span.End()
+		return err
+	}
+	return service.setServiceReplicas(client, 1)
+}
+
+// stop puts the service down according to its strategy
+func (service *Service) stop(client *client.Client) error {
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	if service.members != nil {
+		var err error
+		for _, member := range service.members {
+			if memberErr := member.stop(client); memberErr != nil {
+				err = memberErr
+			}
+		}
+		return err
+	}
+	release, err := dockerPool.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if service.strategy != PAUSE {
+		// A paused container keeps its state
+		service.snapshot(ctx, client)
+	}
+	if definition := getDefinition(service.name); definition != nil && definition.RemoveWhenIdle {
+		return service.remove(ctx, client)
+	}
+	if service.strategy == PAUSE {
+		return service.pause(ctx, client)
+	}
+	if service.strategy == WARM {
+		return service.replaceWarm(ctx, client)
+	}
+	if service.strategy == REMOVE {
+		return service.removeKeepingSpec(ctx, client)
+	}
+	if service.strategy == CHECKPOINT {
+		// Dumping the memory of the containers is not bounded by --docker-timeout
+		return service.checkpoint(context.Background(), client)
+	}
+	return service.setServiceReplicas(client, 0)
+}
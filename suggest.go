@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// maxSuggestions is the number of service names suggested when a service cannot be found
+const maxSuggestions = 5
+
+// Labels set by docker on the services of a stack or of a compose project
+const (
+	stackNamespaceLabel = "com.docker.stack.namespace"
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// levenshtein returns the edit distance between two names
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+// suggestNames returns the names of the services close to name, the closest first:
+// names sharing a prefix with it or within a few edits of it
+func suggestNames(services []swarm.Service, name string) []string {
+	name = strings.ToLower(name)
+	distances := map[string]int{}
+	for _, service := range services {
+		candidate := strings.ToLower(service.Spec.Name)
+		distance := levenshtein(name, candidate)
+		if distance <= len(name)/3+1 || strings.HasPrefix(candidate, name) || strings.HasPrefix(name, candidate) {
+			distances[service.Spec.Name] = distance
+		}
+	}
+	suggestions := []string{}
+	for suggestion := range distances {
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if distances[suggestions[i]] != distances[suggestions[j]] {
+			return distances[suggestions[i]] < distances[suggestions[j]]
+		}
+		return suggestions[i] < suggestions[j]
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// findProjectService returns the name of the service deployed as name by a stack or a compose project,
+// e.g. mystack_whoami for whoami, and "" when there is none
+func findProjectService(services []swarm.Service, name string) string {
+	for _, service := range services {
+		for _, label := range []string{stackNamespaceLabel, composeProjectLabel} {
+			project, ok := service.Spec.Labels[label]
+			if ok && service.Spec.Name == project+"_"+name {
+				return service.Spec.Name
+			}
+		}
+	}
+	return ""
+}
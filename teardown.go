@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on a service removed when idle (remove strategy or removeWhenIdle definition) to remove its resources
+// with it, e.g. for ephemeral preview environments
+const (
+	// removeVolumesLabel removes the anonymous volumes of its containers with "true"
+	removeVolumesLabel = "ondemand.remove.volumes"
+	// removeNetworksLabel removes the networks only it uses with "true", created again when it is woken up
+	removeNetworksLabel = "ondemand.remove.networks"
+)
+
+const (
+	// teardownAttempts is the number of times a volume or a network is removed, while the tasks of the removed service
+	// are still shutting down
+	teardownAttempts = 10
+	// teardownInterval is the delay between two attempts
+	teardownInterval = 3 * time.Second
+)
+
+// RemovedNetwork is a network removed with the only service using it, to create it again when it is woken up
+type RemovedNetwork struct {
+	ID      string              `json:"id"`
+	Name    string              `json:"name"`
+	Options types.NetworkCreate `json:"options"`
+}
+
+// removedNetworks holds the networks removed with the services, by service
+var removedNetworks = map[string][]RemovedNetwork{}
+
+// anonymousVolume is a volume of a container, removed from the node of the container
+type anonymousVolume struct {
+	client *client.Client
+	name   string
+}
+
+// teardown is what is removed once the service is removed
+type teardown struct {
+	volumes  []anonymousVolume
+	networks []RemovedNetwork
+}
+
+func getRemovedNetworks(name string) []RemovedNetwork {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return removedNetworks[name]
+}
+
+// anonymousVolumes returns the volumes of the containers of the service that the service does not name, created by
+// docker for each container
+func anonymousVolumes(ctx context.Context, client *client.Client, dockerService *swarm.Service) ([]anonymousVolume, error) {
+	named := map[string]bool{}
+	for _, specMount := range dockerService.Spec.TaskTemplate.ContainerSpec.Mounts {
+		if specMount.Type == mount.TypeVolume && specMount.Source != "" {
+			named[specMount.Source] = true
+		}
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		return nil, err
+	}
+	volumes := []anonymousVolume{}
+	for _, containerID := range containerIDs {
+		nodeClient := containerClient(client, containerID)
+		container, err := nodeClient.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, mountPoint := range container.Mounts {
+			if mountPoint.Type == mount.TypeVolume && mountPoint.Name != "" && !named[mountPoint.Name] {
+				volumes = append(volumes, anonymousVolume{client: nodeClient, name: mountPoint.Name})
+			}
+		}
+	}
+	return volumes, nil
+}
+
+// ownNetworks returns the networks of the service that no other service uses, except the ingress network
+func ownNetworks(ctx context.Context, client *client.Client, dockerService *swarm.Service) ([]RemovedNetwork, error) {
+	if len(dockerService.Spec.TaskTemplate.Networks) == 0 {
+		return nil, nil
+	}
+	dockerServices, err := client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	networks := []RemovedNetwork{}
+	for _, attachment := range dockerService.Spec.TaskTemplate.Networks {
+		network, err := client.NetworkInspect(ctx, attachment.Target)
+		if err != nil {
+			return nil, err
+		}
+		if network.Name == "ingress" || network.Scope != "swarm" {
+			continue
+		}
+		shared := false
+		for _, other := range dockerServices {
+			if other.ID == dockerService.ID {
+				continue
+			}
+			for _, otherAttachment := range other.Spec.TaskTemplate.Networks {
+				shared = shared || otherAttachment.Target == network.ID || otherAttachment.Target == network.Name
+			}
+		}
+		if shared {
+			fmt.Printf("- Service %v network %s is kept, used by other services\n", dockerService.Spec.Name, network.Name)
+			continue
+		}
+		ipam := network.IPAM
+		networks = append(networks, RemovedNetwork{ID: network.ID, Name: network.Name, Options: types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         network.Driver,
+			EnableIPv6:     network.EnableIPv6,
+			IPAM:           &ipam,
+			Internal:       network.Internal,
+			Attachable:     network.Attachable,
+			Options:        network.Options,
+			Labels:         network.Labels,
+		}})
+	}
+	return networks, nil
+}
+
+// prepareTeardown returns the anonymous volumes and own networks of the service to remove with it, per its labels,
+// keeping the networks to create them again
+func (service *Service) prepareTeardown(ctx context.Context, client *client.Client, dockerService *swarm.Service) (*teardown, error) {
+	labels := service.labels(dockerService)
+	teardown := &teardown{}
+	var err error
+	if labels[removeVolumesLabel] == "true" {
+		if teardown.volumes, err = anonymousVolumes(ctx, client, dockerService); err != nil {
+			return nil, err
+		}
+	}
+	if labels[removeNetworksLabel] == "true" {
+		if teardown.networks, err = ownNetworks(ctx, client, dockerService); err != nil {
+			return nil, err
+		}
+	}
+	if len(teardown.networks) == 0 {
+		return teardown, nil
+	}
+	registryMutex.Lock()
+	removedNetworks[service.name] = teardown.networks
+	registryMutex.Unlock()
+	return teardown, store.Update(func(state *State) {
+		state.Networks[service.name] = teardown.networks
+	})
+}
+
+// run removes the volumes and networks of the removed service, retrying while its tasks are shutting down
+func (teardown *teardown) run(service *Service, client *client.Client) {
+	for _, volume := range teardown.volumes {
+		retryTeardown(service, "volume "+volume.name, func(ctx context.Context) error {
+			return volume.client.VolumeRemove(ctx, volume.name, true)
+		})
+	}
+	for _, network := range teardown.networks {
+		id := network.ID
+		retryTeardown(service, "network "+network.Name, func(ctx context.Context) error {
+			return client.NetworkRemove(ctx, id)
+		})
+	}
+}
+
+// retryTeardown removes a resource of the service, teardownAttempts times at most
+func retryTeardown(service *Service, resource string, remove func(ctx context.Context) error) {
+	var err error
+	for attempt := 0; attempt < teardownAttempts; attempt++ {
+		time.Sleep(teardownInterval)
+		ctx, cancel := dockerContext(context.Background())
+		err = remove(ctx)
+		cancel()
+		if err == nil {
+			fmt.Printf("- Service %v %s is removed\n", service.name, resource)
+			audit(service.name, "teardown", resource, "")
+			return
+		}
+	}
+	fmt.Printf("Error: could not remove %s of service %s: %+v\n ", resource, service.name, err)
+}
+
+// restoreNetworks creates again the networks removed with the service, unless they exist, and attaches the spec to
+// them
+func (service *Service) restoreNetworks(ctx context.Context, client *client.Client, spec *swarm.ServiceSpec) error {
+	networks := getRemovedNetworks(service.name)
+	if len(networks) == 0 {
+		return nil
+	}
+	for _, network := range networks {
+		id := ""
+		if existing, err := client.NetworkInspect(ctx, network.Name); err == nil {
+			id = existing.ID
+		} else {
+			fmt.Printf("Creating network %s of service %s again\n", network.Name, service.name)
+			created, err := client.NetworkCreate(ctx, network.Name, network.Options)
+			if err != nil {
+				return err
+			}
+			id = created.ID
+		}
+		for i, attachment := range spec.TaskTemplate.Networks {
+			if attachment.Target == network.ID {
+				spec.TaskTemplate.Networks[i].Target = id
+			}
+		}
+	}
+	registryMutex.Lock()
+	delete(removedNetworks, service.name)
+	registryMutex.Unlock()
+	return store.Update(func(state *State) {
+		delete(state.Networks, service.name)
+	})
+}
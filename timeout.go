@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// timeoutResponse is the response to a request whose docker calls timed out
+const timeoutResponse = "timeout"
+
+// dockerContext bounds the docker calls made with the returned context by --docker-timeout, and by parent
+// (e.g. the context of the request, cancelled when the client goes away)
+func dockerContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if dockerTimeout.Get() <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, dockerTimeout.Get())
+}
+
+// isTimeout reports whether err comes from a docker call that did not complete in time
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Scopes of the API tokens, each one allowing the verbs of the previous ones
+const (
+	// statusScope reads the status, details, logs and history of the services
+	statusScope = "status"
+	// startScope also wakes the services up and opens sessions on them
+	startScope = "start"
+	// fullScope also stops, restarts, pins and (de)registers the services
+	fullScope = "full"
+)
+
+var scopeLevels = map[string]int{statusScope: 1, startScope: 2, fullScope: 3}
+
+// APIToken is a bearer token allowed the verbs of its scope on some services
+type APIToken struct {
+	Token string `json:"token"`
+	// Name identifies the token in the logs (e.g. the team using it)
+	Name string `json:"name,omitempty"`
+	// Services are the names or patterns (e.g. team-a-*) of the services of the token, all of them when empty
+	Services []string `json:"services,omitempty"`
+	Scope    string   `json:"scope"`
+	// Namespace scopes the token to the services named <namespace>_<name>, which its requests name by <name> only,
+	// and out of which its lists show nothing
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// apiTokens are the tokens of the API, which is open to anyone when there are none
+var apiTokens []*APIToken
+
+func loadTokens(path string) ([]*APIToken, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := []*APIToken{}
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	for _, token := range loaded {
+		if token.Token == "" {
+			return nil, fmt.Errorf("%s: token is required", path)
+		}
+		if scopeLevels[token.Scope] == 0 {
+			return nil, fmt.Errorf("%s: scope should be one of %s, %s, %s", path, statusScope, startScope, fullScope)
+		}
+	}
+	return loaded, nil
+}
+
+// covers reports whether the token is allowed to act on the service, an empty service standing for all of them.
+// A token bound to a namespace covers its services only, matched by their names in the namespace
+func (token *APIToken) covers(service string) bool {
+	if !inNamespace(token.Namespace, service) {
+		return false
+	}
+	if len(token.Services) == 0 {
+		return true
+	}
+	if token.Namespace != "" {
+		service = strings.TrimPrefix(service, token.Namespace+namespaceSeparator)
+	}
+	for _, allowed := range token.Services {
+		if allowed == "*" || allowed == service {
+			return true
+		}
+		if service != "" && isPattern(allowed) {
+			if matched, err := matchesPattern(allowed, service); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPublic reports whether the request needs no token
+func isPublic(r *http.Request) bool {
+	return r.URL.Path == "/metrics" || r.URL.Path == "/api/openapi.json"
+}
+
+// isManagement reports whether the request is one of the API or of the dashboard, rather than a wake request
+func isManagement(r *http.Request) bool {
+	return r.URL.Path == "/dashboard" || strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// isNamespaced reports whether the request about all the services only sees or acts on those of the namespace
+// of its token, its handler filtering the lists or qualifying the names in the namespace
+func isNamespaced(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/status", "/api/events", "/api/stats", "/api/activity", "/api/audit", "/api/traefik/config", "/dashboard",
+		"/api/state/export", "/api/state/import", "/api/services":
+		return true
+	}
+	return false
+}
+
+// allows reports whether the token is allowed to act on the service of the request, an empty service standing for all
+// of them. A token bound to a namespace is allowed the requests about all the services that are scoped to its namespace
+func (token *APIToken) allows(r *http.Request, service string) bool {
+	if service == "" && token.Namespace != "" && len(token.Services) == 0 && isNamespaced(r) {
+		return true
+	}
+	return token.covers(service)
+}
+
+// requiredScope returns the service a request acts on, empty for all of them, and the scope it requires
+func requiredScope(r *http.Request) (string, string) {
+	switch {
+	case r.URL.Path == "/api/status" || r.URL.Path == "/api/events" || r.URL.Path == "/api/stats" || r.URL.Path == "/api/activity" ||
+		r.URL.Path == "/api/audit" || r.URL.Path == "/api/traefik/config" || r.URL.Path == "/dashboard":
+		return "", statusScope
+	case strings.HasPrefix(r.URL.Path, "/api/activity/"):
+		return requestedService(r, strings.TrimPrefix(r.URL.Path, "/api/activity/")), statusScope
+	case strings.HasPrefix(r.URL.Path, "/api/state/") || r.URL.Path == "/api/config":
+		return "", fullScope
+	case r.URL.Path == "/api/services" || strings.HasPrefix(r.URL.Path, "/api/services/"):
+		segments := pathSegments(r, "/api/services")
+		if isBatch(r) {
+			// A batch acts on several services, which handleBatchAPI checks the token all covers
+			return "", startScope
+		}
+		if len(segments) == 1 {
+			// The registration of a service
+			return qualify(requestNamespace(r), segments[0]), fullScope
+		}
+		if len(segments) == 0 {
+			return "", fullScope
+		}
+		service := requestedService(r, segments[0])
+		switch {
+		case segments[1] == "sessions" || segments[1] == "start":
+			return service, startScope
+		case (segments[1] == "shutdown" || segments[1] == "drain") && r.Method == http.MethodPost:
+			return service, startScope
+		case r.Method == http.MethodGet:
+			return service, statusScope
+		}
+		return service, fullScope
+	}
+	// Wake requests of the plugin
+	return requestedService(r, signedName(r)), startScope
+}
+
+// findToken returns the token of the request, or of the operator of a valid JWT, nil when it has none or an unknown one
+func findToken(r *http.Request) *APIToken {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, token := range apiTokens {
+		if subtle.ConstantTimeCompare([]byte(token.Token), []byte(bearer)) == 1 {
+			return token
+		}
+	}
+	if oidcVerifier != nil {
+		return oidcVerifier.operatorToken(r)
+	}
+	return nil
+}
+
+func describeService(service string) string {
+	if service == "" {
+		return "all the services"
+	}
+	return service
+}
+
+// requireScopes rejects the requests whose token is missing, or does not allow what they do, when there are tokens.
+// The token is then carried by the request, for its namespace to scope the names and lists of services
+func requireScopes(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Without static tokens, the JWTs of the operators only protect the API and the dashboard
+		if isPublic(r) || (len(apiTokens) == 0 && (oidcVerifier == nil || !isManagement(r))) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		token := findToken(r)
+		if token == nil {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("a valid bearer token is required"))
+			return
+		}
+		r = withToken(r, token)
+		service, scope := requiredScope(r)
+		if scopeLevels[token.Scope] < scopeLevels[scope] || (!isBatch(r) && !token.allows(r, service)) {
+			err := fmt.Errorf("the token is not allowed to %s %s", scope, describeService(service))
+			fmt.Printf("- Request %s %s of token %s rejected: %v\n", r.Method, r.URL.Path, token.Name, err)
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withTokens sets the API tokens for the duration of a test
+func withTokens(t *testing.T, tokens []*APIToken) {
+	previous := apiTokens
+	apiTokens = tokens
+	t.Cleanup(func() { apiTokens = previous })
+}
+
+func TestTokenCovers(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   APIToken
+		service string
+		covers  bool
+	}{
+		{"all the services", APIToken{}, "web", true},
+		{"all the services, for all of them", APIToken{}, "", true},
+		{"listed service", APIToken{Services: []string{"web"}}, "web", true},
+		{"unlisted service", APIToken{Services: []string{"web"}}, "api", false},
+		{"listed services, for all of them", APIToken{Services: []string{"web"}}, "", false},
+		{"wildcard", APIToken{Services: []string{"*"}}, "api", true},
+		{"pattern", APIToken{Services: []string{"team-*"}}, "team-web", true},
+		{"pattern of other services", APIToken{Services: []string{"team-*"}}, "web", false},
+		{"namespace", APIToken{Namespace: "team-a"}, "team-a_web", true},
+		{"other namespace", APIToken{Namespace: "team-a"}, "team-b_web", false},
+		{"outside of the namespace", APIToken{Namespace: "team-a"}, "web", false},
+		{"namespace, for all the services", APIToken{Namespace: "team-a"}, "", false},
+		{"listed service in the namespace", APIToken{Namespace: "team-a", Services: []string{"web"}}, "team-a_web", true},
+		{"unlisted service in the namespace", APIToken{Namespace: "team-a", Services: []string{"web"}}, "team-a_db", false},
+		{"listed service in another namespace", APIToken{Namespace: "team-a", Services: []string{"web"}}, "team-b_web", false},
+		{"pattern in the namespace", APIToken{Namespace: "team-a", Services: []string{"w*"}}, "team-a_web", true},
+		{"wildcard outside of the namespace", APIToken{Namespace: "team-a", Services: []string{"*"}}, "web", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if covers := test.token.covers(test.service); covers != test.covers {
+				t.Errorf("expected covers(%q) to be %v", test.service, test.covers)
+			}
+		})
+	}
+}
+
+func TestQualify(t *testing.T) {
+	withTokens(t, []*APIToken{{Token: "a", Scope: fullScope, Namespace: "team-a"}, {Token: "b", Scope: fullScope, Namespace: "team-b"}})
+
+	tests := []struct {
+		name      string
+		namespace string
+		service   string
+		qualified string
+		related   string
+	}{
+		{"no namespace", "", "web", "web", "web"},
+		{"name in the namespace", "team-a", "web", "team-a_web", "team-a_web"},
+		{"name already qualified", "team-a", "team-a_web", "team-a_web", "team-a_web"},
+		{"name of another namespace", "team-a", "team-b_web", "team-a_team-b_web", "team-a_team-b_web"},
+		{"all the services", "team-a", "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if qualified := qualify(test.namespace, test.service); qualified != test.qualified {
+				t.Errorf("expected %q, got %q", test.qualified, qualified)
+			}
+			// The services named by a service (dependencies, sidecars) are in its namespace
+			owner := qualify(test.namespace, "owner")
+			if related := relatedService(owner, test.service); related != test.related {
+				t.Errorf("expected %q, got %q", test.related, related)
+			}
+		})
+	}
+}
+
+func TestRequireScopes(t *testing.T) {
+	withTokens(t, []*APIToken{
+		{Token: "admin", Name: "admin", Scope: fullScope},
+		{Token: "viewer", Name: "viewer", Scope: statusScope},
+		{Token: "web", Name: "web", Scope: startScope, Services: []string{"web"}},
+		{Token: "team-a", Name: "team-a", Scope: fullScope, Namespace: "team-a"},
+		{Token: "team-a-web", Name: "team-a-web", Scope: fullScope, Namespace: "team-a", Services: []string{"web"}},
+	})
+	handler := requireScopes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		status int
+	}{
+		{"without token", "", http.MethodGet, "/api/status", http.StatusUnauthorized},
+		{"unknown token", "unknown", http.MethodGet, "/api/status", http.StatusUnauthorized},
+		{"public endpoint", "", http.MethodGet, "/metrics", http.StatusNoContent},
+		{"status of all the services", "viewer", http.MethodGet, "/api/status", http.StatusNoContent},
+		{"stop above the scope", "viewer", http.MethodPost, "/api/services/web/stop", http.StatusForbidden},
+		{"wake request", "web", http.MethodGet, "/?name=web", http.StatusNoContent},
+		{"wake request of another service", "web", http.MethodGet, "/?name=api", http.StatusForbidden},
+		{"config", "admin", http.MethodGet, "/api/config", http.StatusNoContent},
+		{"config with a namespaced token", "team-a", http.MethodGet, "/api/config", http.StatusForbidden},
+		{"config change with a namespaced token", "team-a", http.MethodPatch, "/api/config", http.StatusForbidden},
+		{"namespaced list", "team-a", http.MethodGet, "/api/status", http.StatusNoContent},
+		{"list with listed services", "team-a-web", http.MethodGet, "/api/status", http.StatusForbidden},
+		{"service of the namespace", "team-a", http.MethodPost, "/api/services/web/stop", http.StatusNoContent},
+		{"qualified service of the namespace", "team-a", http.MethodPost, "/api/services/team-a_web/stop", http.StatusNoContent},
+		{"unlisted service of the namespace", "team-a-web", http.MethodPost, "/api/services/db/stop", http.StatusForbidden},
+		{"registration in the namespace", "team-a", http.MethodPut, "/api/services/web", http.StatusNoContent},
+		{"batch, checked by its handler", "web", http.MethodPost, "/api/services/batch", http.StatusNoContent},
+		{"batch above the scope", "viewer", http.MethodPost, "/api/services/batch", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("expected %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestBatchCoverage(t *testing.T) {
+	withTokens(t, []*APIToken{
+		{Token: "web", Scope: startScope, Services: []string{"web"}},
+		{Token: "team-a", Scope: fullScope, Namespace: "team-a"},
+		{Token: "team-a-web", Scope: fullScope, Namespace: "team-a", Services: []string{"web"}},
+	})
+	docker := newMockDocker(map[string]*MockService{
+		"web":        {Labels: map[string]string{dependsLabel: "db"}},
+		"db":         {},
+		"team-a_web": {Labels: map[string]string{dependsLabel: "db"}},
+		"team-a_db":  {},
+		"team-b_db":  {},
+	})
+	cli, err := docker.serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+		err    string
+	}{
+		{"status of a covered service", "web", `{"names": ["web"]}`, http.StatusOK, ""},
+		{"status of a service not covered", "web", `{"names": ["web", "db"]}`, http.StatusForbidden, "the token is not allowed to act on db"},
+		{"start of a dependency not covered", "web", `{"names": ["web"], "start": true}`, http.StatusForbidden, "the token is not allowed to act on db"},
+		{"status in the namespace", "team-a", `{"names": ["web", "db"]}`, http.StatusOK, ""},
+		{"start of a dependency of the namespace not covered", "team-a-web", `{"names": ["web"], "start": true}`, http.StatusForbidden, "the token is not allowed to act on team-a_db"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/services/batch", strings.NewReader(test.body))
+			request = withToken(request, findTokenByValue(test.token))
+			recorder := httptest.NewRecorder()
+			handleBatchAPI(recorder, request, cli)
+			if recorder.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+			}
+			if test.err != "" && !strings.Contains(recorder.Body.String(), test.err) {
+				t.Errorf("expected %q, got %s", test.err, recorder.Body.String())
+			}
+		})
+	}
+
+	// The names of a namespaced batch are qualified: another namespace cannot be reached
+	request := httptest.NewRequest(http.MethodPost, "/api/services/batch", strings.NewReader(`{"names": ["team-b_db"]}`))
+	request = withToken(request, findTokenByValue("team-a"))
+	recorder := httptest.NewRecorder()
+	handleBatchAPI(recorder, request, cli)
+	if strings.Contains(recorder.Body.String(), `"name":"team-b_db"`) {
+		t.Errorf("expected the name to be qualified in the namespace, got %s", recorder.Body.String())
+	}
+}
+
+func findTokenByValue(value string) *APIToken {
+	for _, token := range apiTokens {
+		if token.Token == value {
+			return token
+		}
+	}
+	return nil
+}
+
+func TestValidateRegistrationNamespace(t *testing.T) {
+	withTokens(t, []*APIToken{{Token: "a", Scope: fullScope, Namespace: "team-a"}, {Token: "b", Scope: fullScope, Namespace: "team-b"}})
+
+	tests := []struct {
+		name         string
+		registration Registration
+		err          string
+	}{
+		{"dependency in the namespace", Registration{Name: "team-a_web", Labels: map[string]string{dependsLabel: "db"}}, ""},
+		{"qualified dependency in the namespace", Registration{Name: "team-a_web", Labels: map[string]string{dependsLabel: "team-a_db"}}, ""},
+		{"dependency in another namespace", Registration{Name: "team-a_web", Labels: map[string]string{dependsLabel: "db, team-b_db"}}, "ondemand.depends cannot name team-b_db, which is in another namespace"},
+		{"sidecar in another namespace", Registration{Name: "team-a_web", Labels: map[string]string{sidecarsLabel: "team-b_cache"}}, "ondemand.sidecars cannot name team-b_cache, which is in another namespace"},
+		{"global service naming a namespaced one", Registration{Name: "web", Labels: map[string]string{dependsLabel: "team-b_db"}}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.registration.validate()
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected a valid registration, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracesBatchSize and tracesFlushInterval bound how long ended spans wait before being exported
+const tracesBatchSize = 100
+const tracesFlushInterval = 5 * time.Second
+
+// Span is an OpenTelemetry span, exported with the OTLP/HTTP JSON protocol.
+// A nil span is a no-op, so that the code does not depend on tracing being enabled.
+type Span struct {
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+	mutex      sync.Mutex
+}
+
+// Tracer batches the ended spans and exports them to an OTLP endpoint
+type Tracer struct {
+	endpoint string
+	spans    chan *Span
+}
+
+// tracer is nil when tracing is disabled
+var tracer *Tracer
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// startSpan starts a span, a root span when parent is nil
+func startSpan(parent *Span, name string, attributes ...string) *Span {
+	if tracer == nil {
+		return nil
+	}
+	span := &Span{spanID: randomHex(8), name: name, start: time.Now(), attributes: map[string]string{}}
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.attributes[attributes[i]] = attributes[i+1]
+	}
+	return span
+}
+
+// startRemoteSpan starts a span continuing the trace of the W3C traceparent header of the request, if any
+func startRemoteSpan(r *http.Request, name string, attributes ...string) *Span {
+	span := startSpan(nil, name, attributes...)
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if span != nil && len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		span.traceID = parts[1]
+		span.parentID = parts[2]
+	}
+	return span
+}
+
+// SetAttribute adds an attribute to the span
+func (span *Span) SetAttribute(key string, value string) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.attributes[key] = value
+}
+
+// SetError marks the span as failed
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.err = err
+}
+
+// End ends the span and queues it for export
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	if !span.end.IsZero() {
+		span.mutex.Unlock()
+		return
+	}
+	span.end = time.Now()
+	span.mutex.Unlock()
+	select {
+	case tracer.spans <- span:
+	default:
+		// The exporter is not keeping up, the span is dropped rather than slowing down the requests
+	}
+}
+
+// newTracer returns a tracer exporting to an OTLP/HTTP endpoint (e.g. http://collector:4318)
+func newTracer(endpoint string) *Tracer {
+	tracer := &Tracer{endpoint: strings.TrimRight(endpoint, "/") + "/v1/traces", spans: make(chan *Span, 10*tracesBatchSize)}
+	go tracer.run()
+	return tracer
+}
+
+func (tracer *Tracer) run() {
+	batch := []*Span{}
+	ticker := time.NewTicker(tracesFlushInterval)
+	for {
+		select {
+		case span := <-tracer.spans:
+			batch = append(batch, span)
+			if len(batch) < tracesBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := tracer.export(batch); err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+		}
+		batch = []*Span{}
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	converted := []otlpAttribute{}
+	for key, value := range attributes {
+		converted = append(converted, otlpAttribute{key, otlpValue{value}})
+	}
+	return converted
+}
+
+func (tracer *Tracer) export(batch []*Span) error {
+	spans := []otlpSpan{}
+	for _, span := range batch {
+		span.mutex.Lock()
+		converted := otlpSpan{
+			TraceID:           span.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              1,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.attributes),
+		}
+		if span.err != nil {
+			converted.Status = otlpStatus{Code: 2, Message: span.err.Error()}
+		}
+		span.mutex.Unlock()
+		spans = append(spans, converted)
+	}
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": "traefik-ondemand-service"}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "ondemand"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	content, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	response, err := http.Post(tracer.endpoint, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", tracer.endpoint, response.StatusCode)
+	}
+	return nil
+}
+
+// span starts a span of the current cold start of the service, if any
+func (service *Service) span(name string, attributes ...string) *Span {
+	if service.coldStart == nil {
+		return nil
+	}
+	return startSpan(service.coldStart, name, append([]string{"service", service.name}, attributes...)...)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartRemoteSpan(t *testing.T) {
+	previous := tracer
+	tracer = &Tracer{spans: make(chan *Span, 1)}
+	defer func() { tracer = previous }()
+
+	tests := []struct {
+		name        string
+		traceparent string
+		traceID     string
+		parentID    string
+	}{
+		{"continued trace", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"no traceparent", "", "", ""},
+		{"malformed traceparent", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.traceparent != "" {
+				request.Header.Set("traceparent", test.traceparent)
+			}
+			span := startRemoteSpan(request, "wake", "service", "web")
+			if test.traceID == "" {
+				if len(span.traceID) != 32 || span.parentID != "" {
+					t.Errorf("expected a root span, got trace %s and parent %s", span.traceID, span.parentID)
+				}
+			} else if span.traceID != test.traceID || span.parentID != test.parentID {
+				t.Errorf("expected trace %s and parent %s, got %s and %s", test.traceID, test.parentID, span.traceID, span.parentID)
+			}
+			if span.attributes["service"] != "web" {
+				t.Errorf("expected the attributes of the span, got %v", span.attributes)
+			}
+		})
+	}
+}
+
+func TestSpansDisabled(t *testing.T) {
+	previous := tracer
+	tracer = nil
+	defer func() { tracer = previous }()
+
+	span := startSpan(nil, "wake")
+	if span != nil {
+		t.Fatalf("expected no span when tracing is disabled, got %+v", span)
+	}
+	// A nil span is a no-op
+	span.SetAttribute("service", "web")
+	span.SetError(errors.New("failed"))
+	span.End()
+}
+
+func TestTracerExport(t *testing.T) {
+	var exported struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&exported)
+	}))
+	defer server.Close()
+
+	previous := tracer
+	tracer = &Tracer{endpoint: server.URL + "/v1/traces", spans: make(chan *Span, 2)}
+	defer func() { tracer = previous }()
+
+	root := startSpan(nil, "wake", "service", "web")
+	child := startSpan(root, "scale")
+	child.SetError(errors.New("no such service"))
+	child.End()
+	root.End()
+	// Ending a span twice does not export it twice
+	root.End()
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 ended spans, got %d", len(tracer.spans))
+	}
+	if err := tracer.export([]*Span{<-tracer.spans, <-tracer.spans}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exported.ResourceSpans) != 1 || len(exported.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("expected one resource and scope, got %+v", exported)
+	}
+	spans := exported.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(spans))
+	}
+	exportedChild, exportedRoot := spans[0], spans[1]
+	if exportedChild.TraceID != root.traceID || exportedChild.ParentSpanID != root.spanID {
+		t.Errorf("expected the child span in the trace of its parent, got %+v", exportedChild)
+	}
+	if exportedChild.Status.Code != 2 || exportedChild.Status.Message != "no such service" {
+		t.Errorf("expected the child span to be failed, got %+v", exportedChild.Status)
+	}
+	if exportedRoot.ParentSpanID != "" || exportedRoot.Status.Code != 0 {
+		t.Errorf("expected a successful root span, got %+v", exportedRoot)
+	}
+	if len(exportedRoot.Attributes) != 1 || exportedRoot.Attributes[0].Key != "service" || exportedRoot.Attributes[0].Value.StringValue != "web" {
+		t.Errorf("expected the attributes of the root span, got %+v", exportedRoot.Attributes)
+	}
+
+	failing := &Tracer{endpoint: server.URL + "/unknown"}
+	if err := failing.export(nil); err == nil {
+		t.Errorf("expected the export to fail when the collector rejects it")
+	}
+}
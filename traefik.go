@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to defer its stop while traefik has open connections to it
+const (
+	traefikServiceLabel  = "ondemand.traefik.service"
+	traefikMaxDelayLabel = "ondemand.traefik.maxdelay"
+)
+
+const openConnectionsMetric = "traefik_service_open_connections"
+
+// defaultMaxDelay is how long a stop can be deferred by open connections by default
+const defaultMaxDelay = time.Hour
+
+var traefikServiceRegexp = regexp.MustCompile(`service="([^"]*)"`)
+
+// countOpenConnections sums the open connections of a traefik service from the traefik Prometheus metrics
+func countOpenConnections(ctx context.Context, metricsURL string, traefikService string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, metricsURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s answered %d", metricsURL, response.StatusCode)
+	}
+
+	total := 0.0
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, openConnectionsMetric+"{") {
+			continue
+		}
+		end := strings.LastIndex(line, "}")
+		match := traefikServiceRegexp.FindStringSubmatch(line[:end+1])
+		if match == nil || match[1] != traefikService {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(line[end+1:]), 64)
+		if err != nil {
+			continue
+		}
+		total += value
+	}
+	return total, scanner.Err()
+}
+
+// hasOpenConnections reports whether traefik still has open connections to the service,
+// in which case its stop is deferred, up to a maximum delay
+func (service *Service) hasOpenConnections(client *client.Client) bool {
+	if *traefikMetricsURL == "" {
+		return false
+	}
+	if service.members != nil {
+		open := false
+		for _, member := range service.members {
+			if member.hasOpenConnections(client) {
+				open = true
+			}
+		}
+		return open
+	}
+
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return false
+	}
+	labels := service.labels(dockerService)
+	traefikService, ok := labels[traefikServiceLabel]
+	if !ok {
+		traefikService = dockerService.Spec.Name + "@docker"
+	}
+	maxDelay := defaultMaxDelay
+	if value, ok := labels[traefikMaxDelayLabel]; ok {
+		if maxDelay, err = time.ParseDuration(value); err != nil {
+			fmt.Printf("Error: %s should be a duration (e.g. 10m)\n ", traefikMaxDelayLabel)
+			maxDelay = defaultMaxDelay
+		}
+	}
+
+	connections, err := countOpenConnections(ctx, *traefikMetricsURL, traefikService)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		service.deferredSince = time.Time{}
+		return false
+	}
+	if connections == 0 {
+		service.deferredSince = time.Time{}
+		return false
+	}
+	if service.deferredSince.IsZero() {
+		service.deferredSince = time.Now()
+	}
+	if time.Since(service.deferredSince) > maxDelay {
+		fmt.Printf("- Service %v still has %.0f open connections, stopping it anyway after %v\n", service.name, connections, maxDelay)
+		service.deferredSince = time.Time{}
+		return false
+	}
+	fmt.Printf("- Service %v still has %.0f open connections\n", service.name, connections)
+	return true
+}
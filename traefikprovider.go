@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service, or in its registration, to generate its traefik router
+const (
+	traefikRuleLabel        = "ondemand.traefik.rule"
+	traefikEntryPointsLabel = "ondemand.traefik.entrypoints"
+	traefikPortLabel        = "ondemand.traefik.port"
+)
+
+// defaultTraefikTimeout is the timeout of the generated middlewares of the services without one, in seconds
+const defaultTraefikTimeout = 300
+
+// TraefikConfig is the dynamic configuration served to the traefik HTTP provider
+type TraefikConfig struct {
+	HTTP TraefikHTTPConfig `json:"http"`
+}
+
+// TraefikHTTPConfig holds the routers, middlewares and services of the HTTP configuration
+type TraefikHTTPConfig struct {
+	Routers     map[string]TraefikRouter     `json:"routers"`
+	Middlewares map[string]TraefikMiddleware `json:"middlewares"`
+	Services    map[string]TraefikService    `json:"services"`
+}
+
+// TraefikRouter routes the requests matching its rule to the service, through the plugin middleware
+type TraefikRouter struct {
+	Rule        string   `json:"rule"`
+	EntryPoints []string `json:"entryPoints,omitempty"`
+	Middlewares []string `json:"middlewares"`
+	Service     string   `json:"service"`
+}
+
+// TraefikMiddleware configures the plugin by its name, as declared in the static configuration of traefik
+type TraefikMiddleware struct {
+	Plugin map[string]TraefikPluginConfig `json:"plugin"`
+}
+
+// TraefikPluginConfig is the configuration of the plugin, the wake requests of which are sent to ServiceURL
+type TraefikPluginConfig struct {
+	Name       string `json:"name"`
+	ServiceURL string `json:"serviceUrl"`
+	Timeout    string `json:"timeout"`
+}
+
+// TraefikService load balances the requests to the containers of a docker service
+type TraefikService struct {
+	LoadBalancer TraefikLoadBalancer `json:"loadBalancer"`
+}
+
+// TraefikLoadBalancer holds the servers of a service
+type TraefikLoadBalancer struct {
+	Servers []TraefikServer `json:"servers"`
+}
+
+// TraefikServer is reached at the name of the docker service, on the network of traefik
+type TraefikServer struct {
+	URL string `json:"url"`
+}
+
+// traefikName returns the name of the routers, middlewares and services of traefik for a service
+func traefikName(name string) string {
+	return strings.NewReplacer("@", "-", "/", "-", ".", "-").Replace(name)
+}
+
+// addTraefikService adds the router, middleware and service of a service with the ondemand.traefik.rule label
+func (config *TraefikConfig) addTraefikService(name string, labels map[string]string, timeout uint64, serviceURL string) error {
+	rule := labels[traefikRuleLabel]
+	if rule == "" {
+		return nil
+	}
+	port := uint64(80)
+	if value, ok := labels[traefikPortLabel]; ok {
+		parsed, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || parsed == 0 {
+			return fmt.Errorf("%s should be a port", traefikPortLabel)
+		}
+		port = parsed
+	}
+	if parsed, err := strconv.ParseUint(labels[timeoutLabel], 10, 64); err == nil {
+		timeout = parsed
+	}
+	if timeout == 0 {
+		timeout = defaultTraefikTimeout
+	}
+	entryPoints := []string{}
+	for _, entryPoint := range strings.Split(labels[traefikEntryPointsLabel], ",") {
+		if entryPoint = strings.TrimSpace(entryPoint); entryPoint != "" {
+			entryPoints = append(entryPoints, entryPoint)
+		}
+	}
+	key := traefikName(name)
+	middleware := "ondemand-" + key
+	config.HTTP.Routers[key] = TraefikRouter{Rule: rule, EntryPoints: entryPoints, Middlewares: []string{middleware}, Service: key}
+	config.HTTP.Middlewares[middleware] = TraefikMiddleware{Plugin: map[string]TraefikPluginConfig{
+		*traefikPlugin: {Name: name, ServiceURL: serviceURL, Timeout: fmt.Sprintf("%ds", timeout)},
+	}}
+	config.HTTP.Services[key] = TraefikService{LoadBalancer: TraefikLoadBalancer{
+		Servers: []TraefikServer{{URL: fmt.Sprintf("http://%s:%d", name, port)}},
+	}}
+	return nil
+}
+
+// pluginServiceURL returns the URL at which the plugin reaches the scaler, --traefik-service-url or the one traefik
+// used to reach the provider
+func pluginServiceURL(r *http.Request) string {
+	if *traefikServiceURL != "" {
+		return *traefikServiceURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// generateTraefikConfig returns the configuration of the docker services and registrations of the namespace having
+// the ondemand.traefik.rule label
+func generateTraefikConfig(r *http.Request, cli *client.Client) (*TraefikConfig, error) {
+	config := &TraefikConfig{HTTP: TraefikHTTPConfig{
+		Routers:     map[string]TraefikRouter{},
+		Middlewares: map[string]TraefikMiddleware{},
+		Services:    map[string]TraefikService{},
+	}}
+	serviceURL := pluginServiceURL(r)
+	namespace := requestNamespace(r)
+	ctx, cancel := dockerContext(r.Context())
+	defer cancel()
+	dockerServices, err := cli.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for i := range dockerServices {
+		name := dockerServices[i].Spec.Name
+		found[name] = true
+		if !inNamespace(namespace, name) {
+			continue
+		}
+		timeout := uint64(0)
+		if registration := getRegistration(name); registration != nil {
+			timeout = registration.Timeout
+		}
+		labels := (&Service{name: name}).labels(&dockerServices[i])
+		if err := config.addTraefikService(name, labels, timeout, serviceURL); err != nil {
+			fmt.Printf("Error: service %s: %+v\n ", name, err)
+		}
+	}
+	// The registered services that do not exist yet are created from their definition when requested
+	registryMutex.RLock()
+	names := []string{}
+	for name := range registrations {
+		names = append(names, name)
+	}
+	registryMutex.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		registration := getRegistration(name)
+		if found[name] || registration == nil || isPattern(name) || !inNamespace(namespace, name) {
+			continue
+		}
+		if err := config.addTraefikService(name, registration.Labels, registration.Timeout, serviceURL); err != nil {
+			fmt.Printf("Error: service %s: %+v\n ", name, err)
+		}
+	}
+	return config, nil
+}
+
+// handleTraefikConfigAPI serves GET /api/traefik/config, polled by the traefik HTTP provider
+func handleTraefikConfigAPI(cli *client.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+			return
+		}
+		config, err := generateTraefikConfig(r, cli)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, config)
+	}
+}
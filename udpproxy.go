@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// udpSessionTimeout is how long a client that does not exchange datagrams anymore is considered connected,
+// UDP having no connections
+const udpSessionTimeout = 30 * time.Second
+
+// udpQueueSize is the number of datagrams of a client held while its service is starting
+const udpQueueSize = 64
+
+const maxDatagramSize = 65535
+
+// udpSession forwards the datagrams of a client to the backend
+type udpSession struct {
+	datagrams chan []byte
+	// lastDatagramAt is the unix time in nanoseconds of the last datagram exchanged with the client
+	lastDatagramAt int64
+}
+
+func (session *udpSession) touch() {
+	atomic.StoreInt64(&session.lastDatagramAt, time.Now().UnixNano())
+}
+
+func (session *udpSession) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&session.lastDatagramAt)))
+}
+
+// serveUDP receives the datagrams on the port and forwards them to the service, a session by client address
+func (proxy *PortProxy) serveUDP(cli *client.Client, wait time.Duration) error {
+	conn, err := net.ListenPacket("udp", proxy.Listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Proxying %s/udp to service %s (%s).\n", proxy.Listen, proxy.Service, proxy.Backend)
+	sessions := map[string]*udpSession{}
+	var sessionsMutex sync.Mutex
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, address, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		datagram := make([]byte, n)
+		copy(datagram, buffer[:n])
+
+		sessionsMutex.Lock()
+		session, ok := sessions[address.String()]
+		if !ok {
+			session = &udpSession{datagrams: make(chan []byte, udpQueueSize)}
+			sessions[address.String()] = session
+			go func() {
+				proxy.forwardUDP(cli, conn, address, session, wait)
+				sessionsMutex.Lock()
+				delete(sessions, address.String())
+				sessionsMutex.Unlock()
+			}()
+		}
+		session.touch()
+		select {
+		case session.datagrams <- datagram:
+		default:
+			// The session is not keeping up, the datagram is lost as it could be on the network
+		}
+		sessionsMutex.Unlock()
+	}
+}
+
+// forwardUDP wakes the service up and forwards the datagrams of a client until it is idle
+func (proxy *PortProxy) forwardUDP(cli *client.Client, conn net.PacketConn, address net.Addr, session *udpSession, wait time.Duration) {
+	defer reportPanic(nil, "the port proxy "+proxy.Listen)
+	service := GetOrCreateService(proxy.Service, proxy.Timeout)
+	service.openProxyConnection()
+	defer service.closeProxyConnection()
+
+	if err := service.waitUntilStarted(context.Background(), cli, wait); err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	backend, err := net.Dial("udp", proxy.Backend)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return
+	}
+	defer backend.Close()
+
+	go func() {
+		buffer := make([]byte, maxDatagramSize)
+		for {
+			n, err := backend.Read(buffer)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+					continue
+				}
+				return
+			}
+			session.touch()
+			conn.WriteTo(buffer[:n], address)
+		}
+	}()
+
+	for {
+		select {
+		case datagram := <-session.datagrams:
+			if _, err := backend.Write(datagram); err != nil {
+				fmt.Printf("Error: %+v\n ", err)
+			}
+		case <-time.After(udpSessionTimeout):
+			if session.idle() >= udpSessionTimeout {
+				return
+			}
+		}
+	}
+}
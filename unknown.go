@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// unknownAttempts is the number of times the status of a service is read while it is unknown, before the request gets
+// an unknown response
+const unknownAttempts = 3
+
+// unknownBackoff is the delay before the status is read again, doubled at each attempt and jittered
+const unknownBackoff = 200 * time.Millisecond
+
+// UnknownStatusError is returned when the status of a service is still unknown after unknownAttempts reads
+type UnknownStatusError struct {
+	name     string
+	attempts int
+}
+
+func (err *UnknownStatusError) Error() string {
+	return fmt.Sprintf("Status of service %s is unknown after %d attempts", err.name, err.attempts)
+}
+
+// getKnownStatus returns the status of the service, read again with a jittered backoff while it is unknown, and an
+// UnknownStatusError once it stayed unknown unknownAttempts times
+func (service *Service) getKnownStatus(ctx context.Context, cli *client.Client) (Status, error) {
+	status, err := service.getStatus(ctx, cli)
+	for attempt := 1; err == nil && status != UP && status != STARTING && status != DOWN; attempt++ {
+		fmt.Printf("- Service %v status is unknown\n", service.name)
+		if attempt >= unknownAttempts {
+			return UNKNOWN, &UnknownStatusError{name: service.name, attempts: attempt}
+		}
+		delay := unknownBackoff << uint(attempt-1)
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return UNKNOWN, ctx.Err()
+		case <-time.After(delay):
+		}
+		invalidateStatus(service.name)
+		status, err = service.getStatus(ctx, cli)
+	}
+	return status, err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Labels used on the docker service to prevent it from flapping between up and down
+const (
+	minUptimeLabel = "ondemand.minuptime"
+	cooldownLabel  = "ondemand.cooldown"
+)
+
+// parseDurationLabel parses a duration label, which is zero when missing
+func parseDurationLabel(labels map[string]string, label string) (time.Duration, error) {
+	value, ok := labels[label]
+	if !ok {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s should be a duration (e.g. 30s)", label)
+	}
+	return duration, nil
+}
+
+// isWithinMinUptime reports whether the service started less than its minimum uptime ago, in which case it is kept up
+func (service *Service) isWithinMinUptime(client *client.Client) bool {
+	if service.startedAt.IsZero() {
+		return false
+	}
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return false
+	}
+	minUptime, err := parseDurationLabel(labels, minUptimeLabel)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	if time.Since(service.startedAt) >= minUptime {
+		return false
+	}
+	fmt.Printf("- Service %v is kept up for its minimum uptime of %v\n", service.name, minUptime)
+	return true
+}
+
+// isCoolingDown reports whether the service stopped less than its cooldown ago, in which case it is not started again yet
+func (service *Service) isCoolingDown(client *client.Client) bool {
+	if service.stoppedAt.IsZero() {
+		return false
+	}
+	labels, err := service.config(context.Background(), client)
+	if err != nil {
+		return false
+	}
+	cooldown, err := parseDurationLabel(labels, cooldownLabel)
+	if err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+		return false
+	}
+	remaining := cooldown - time.Since(service.stoppedAt)
+	if remaining <= 0 {
+		return false
+	}
+	fmt.Printf("- Service %v is cooling down for %v\n", service.name, remaining.Round(time.Second))
+	return true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// defaultWait and maxWait are the default and longest durations a wait request is held
+const (
+	defaultWait = time.Minute
+	maxWait     = 5 * time.Minute
+)
+
+type waitResponse struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// WaitedSeconds is how long the request was held
+	WaitedSeconds float64 `json:"waitedSeconds"`
+}
+
+// handleWaitAPI serves GET /api/services/{name}/wait, which holds the request until the service is up or the timeout
+// query parameter (a duration, 1m by default) elapses. The service is not woken up, only waited for
+func handleWaitAPI(w http.ResponseWriter, r *http.Request, cli *client.Client, name string) {
+	wait := defaultWait
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("timeout should be a duration (e.g. 60s)"))
+			return
+		}
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	service := getService(name)
+	if service == nil {
+		service = &Service{name: name}
+	}
+	started := time.Now()
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		status, err := service.getStatus(r.Context(), cli)
+		if _, notFound := err.(*NotFoundError); notFound {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		response := waitResponse{name, status, time.Since(started).Seconds()}
+		if status == UP {
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			// Still not up: the client can wait again
+			setAcceptedHeaders(w, service)
+			writeJSON(w, http.StatusAccepted, response)
+			return
+		case <-time.After(proxyPollInterval):
+		}
+	}
+}
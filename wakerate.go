@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// activityInterval is the interval between two updates of the activity metrics of the services, their rate decaying
+// without requests
+const activityInterval = 10 * time.Second
+
+func init() {
+	metrics.Register("ondemand_wake_requests_total", "counter", "Number of wake requests of a service, from the plugin, the proxy or the port proxies")
+	metrics.Register("ondemand_wake_requests_per_second", "gauge", "Rate of the wake requests of a service over the last minute")
+	metrics.Register("ondemand_last_request_timestamp_seconds", "gauge", "Unix time of the last wake request of a service")
+}
+
+// Activity is the activity signal of a service, for external autoscalers to scale it like the scaler does
+type Activity struct {
+	Name string `json:"name"`
+	// Requests is the number of wake requests of the service over the last minute, RequestsPerSecond their rate
+	Requests          int        `json:"requests"`
+	RequestsPerSecond float64    `json:"requestsPerSecond"`
+	LastRequestAt     *time.Time `json:"lastRequestAt,omitempty"`
+	// Active reports whether the service was requested within its idle timeout, the scaler keeping it up
+	Active bool   `json:"active"`
+	State  Status `json:"state"`
+}
+
+// forgetRequestRate forgets the wake requests of a service forgotten by the scaler
+func forgetRequestRate(name string) {
+	requestRatesMutex.Lock()
+	defer requestRatesMutex.Unlock()
+	delete(requestRates, name)
+}
+
+// wakeRequests returns the number of wake requests of the service over the last minute
+func wakeRequests(name string, now time.Time) int {
+	requestRatesMutex.Lock()
+	defer requestRatesMutex.Unlock()
+	if rate := requestRates[name]; rate != nil {
+		return rate.count(now)
+	}
+	return 0
+}
+
+// activity returns the activity of the service, with no requests when it is unknown to the scaler
+func activity(name string, now time.Time) Activity {
+	requests := wakeRequests(name, now)
+	report := Activity{Name: name, Requests: requests, RequestsPerSecond: float64(requests) / rateWindow.Seconds(), State: DOWN}
+	service := getService(name)
+	if service == nil {
+		return report
+	}
+	report.State = service.machine.State()
+	if !service.lastRequestAt.IsZero() {
+		report.LastRequestAt = timePointer(service.lastRequestAt)
+		report.Active = now.Sub(service.lastRequestAt) < time.Duration(service.effectiveTimeout())*time.Second
+	}
+	return report
+}
+
+// reportActivity returns the activity of the services of the namespace known to the scaler, by name
+func reportActivity(now time.Time, namespace string) []Activity {
+	servicesMutex.Lock()
+	names := []string{}
+	for name, service := range services {
+		if service.parent == nil && inNamespace(namespace, name) {
+			names = append(names, name)
+		}
+	}
+	servicesMutex.Unlock()
+	sort.Strings(names)
+	report := []Activity{}
+	for _, name := range names {
+		report = append(report, activity(name, now))
+	}
+	return report
+}
+
+// updateActivityMetrics sets the activity metrics of the services known to the scaler
+func updateActivityMetrics(now time.Time) {
+	for _, report := range reportActivity(now, "") {
+		metrics.Set("ondemand_wake_requests_per_second", report.RequestsPerSecond, "service", report.Name)
+		if report.LastRequestAt != nil {
+			metrics.Set("ondemand_last_request_timestamp_seconds", float64(report.LastRequestAt.Unix()), "service", report.Name)
+		}
+	}
+}
+
+// runActivityMetrics updates the activity metrics of the services every activityInterval
+func runActivityMetrics() {
+	for now := range time.Tick(activityInterval) {
+		updateActivityMetrics(now)
+	}
+}
+
+// handleActivityAPI serves GET /api/activity, the activity of all the services, and GET /api/activity/{name}, that of
+// one service, polled by external autoscalers (e.g. the metrics-api scaler of KEDA)
+func handleActivityAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	segments := pathSegments(r, "/api/activity")
+	switch len(segments) {
+	case 0:
+		writeJSON(w, http.StatusOK, map[string][]Activity{"services": reportActivity(time.Now(), requestNamespace(r))})
+	case 1:
+		// An unknown service is reported idle rather than not found, for the autoscalers to scale it to zero
+		writeJSON(w, http.StatusOK, activity(requestedService(r, segments[0]), time.Now()))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// warmInterval is the interval between two checks of the replacement container of a service being warmed
+const warmInterval = time.Second
+
+// warmTimeout is how long the replacement container of a service has to be ready before the warming is given up,
+// the service being scaled down instead
+const warmTimeout = 10 * time.Minute
+
+// isWarming reports whether the replacement container of the service is being warmed, the service being reported down
+// until it is paused, unless it was woken up meanwhile
+func (service *Service) isWarming() bool {
+	return !service.warmingSince.IsZero() && !service.startedAt.After(service.warmingSince)
+}
+
+// replaceWarm replaces the container of the service by a new one, paused once it is ready to be promoted instantly on
+// the next wake-up, so that the service never resumes the memory of the container that served
+func (service *Service) replaceWarm(ctx context.Context, client *client.Client) error {
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return err
+	}
+	spec := dockerService.Spec
+	spec.TaskTemplate.ForceUpdate++
+	if _, err := client.ServiceUpdate(ctx, dockerService.ID, dockerService.Meta.Version, spec, types.ServiceUpdateOptions{}); err != nil {
+		return err
+	}
+	service.warmingSince = time.Now()
+	go service.warmStandby(client, service.warmingSince)
+	return nil
+}
+
+// warmStandby pauses the replacement container of the service once it is ready, unless the service is woken up first,
+// in which case the container serves it as soon as it is ready
+func (service *Service) warmStandby(client *client.Client, since time.Time) {
+	for time.Since(since) < warmTimeout {
+		time.Sleep(warmInterval)
+		if service.warmingSince != since {
+			// The service was stopped again, warming another container
+			return
+		}
+		if !service.isWarming() {
+			fmt.Printf("- Service %v is woken up while its standby container is warming\n", service.name)
+			return
+		}
+		ready, err := service.isStandbyReady(client)
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			continue
+		}
+		if !ready {
+			continue
+		}
+		ctx, cancel := dockerContext(context.Background())
+		err = service.pause(ctx, client)
+		cancel()
+		if err != nil {
+			fmt.Printf("Error: %+v\n ", err)
+			break
+		}
+		invalidateStatus(service.name)
+		fmt.Printf("- Service %v has a warm standby container, paused after %v\n", service.name, time.Since(since).Round(time.Second))
+		service.warmingSince = time.Time{}
+		return
+	}
+	if service.warmingSince != since || !service.isWarming() {
+		return
+	}
+	fmt.Printf("- Service %v standby container could not be warmed, scaling it down\n", service.name)
+	service.warmingSince = time.Time{}
+	if err := service.setServiceReplicas(client, 0); err != nil {
+		fmt.Printf("Error: %+v\n ", err)
+	}
+	invalidateStatus(service.name)
+}
+
+// isStandbyReady reports whether the replacement container of the service is running and passes its readiness probe
+func (service *Service) isStandbyReady(client *client.Client) (bool, error) {
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	dockerService, err := service.getDockerService(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	containerIDs, err := getRunningContainers(ctx, client, dockerService)
+	if err != nil {
+		return false, err
+	}
+	return service.isReady(ctx, client, dockerService, containerIDs)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// windowsDaemon is true when the docker daemon runs Windows containers
+var windowsDaemon = false
+
+// Defaults on Windows hosts, whose containers are slower to start and to stop
+const (
+	windowsProxyWait     = 3 * time.Minute
+	windowsDockerTimeout = 2 * time.Minute
+	windowsStopTimeout   = 30 * time.Second
+)
+
+// detectDaemonOS detects a Windows docker daemon, and then lengthens the start and stop timeouts
+// that were not set explicitly
+func detectDaemonOS(client *client.Client) {
+	ctx, cancel := dockerContext(context.Background())
+	defer cancel()
+	info, err := client.Info(ctx)
+	if err != nil {
+		fmt.Printf("Could not detect the docker daemon OS: %+v\n", err)
+		return
+	}
+	if info.OSType != "windows" {
+		return
+	}
+	windowsDaemon = true
+	explicit := map[string]bool{}
+	flag.Visit(func(set *flag.Flag) {
+		explicit[set.Name] = true
+	})
+	if !explicit["proxy-wait"] {
+		*proxyWait = windowsProxyWait
+	}
+	if !explicit["docker-timeout"] {
+		dockerTimeout.Set(windowsDockerTimeout.String())
+	}
+	fmt.Printf("Windows docker daemon: proxy wait %s, docker timeout %s, stop grace period %s\n", *proxyWait, dockerTimeout.Get(), windowsStopTimeout)
+}
+
+// isHealthy reports whether the container passes its docker healthcheck, when it has one
+func isHealthy(ctx context.Context, client *client.Client, containerID string) (bool, error) {
+	container, err := containerClient(client, containerID).ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, err
+	}
+	if container.State.Health == nil {
+		return true, nil
+	}
+	return container.State.Health.Status == "healthy", nil
+}